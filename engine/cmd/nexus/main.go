@@ -12,15 +12,20 @@ import (
 	"nexus-engine/internal/core"
 	"nexus-engine/internal/modules/kv"
 	"nexus-engine/internal/modules/pubsub"
+	"nexus-engine/internal/modules/queue"
+	"nexus-engine/internal/modules/ratelimit"
+	"nexus-engine/internal/modules/scheduler"
 	"nexus-engine/internal/pkg/logger"
 )
 
 func main() {
 	// 1. Реестр модулей
-	// Чтобы добавить Queue, просто допишем: queue.NewModule()
 	enabledModules := []core.Module{
 		kv.NewModule(),
 		pubsub.NewModule(),
+		ratelimit.NewModule(),
+		queue.NewModule(),
+		scheduler.NewModule(),
 	}
 
 	// 2. Настройка флагов
@@ -39,6 +44,14 @@ func main() {
 	log := logger.New(*logLevel)
 	log.Info("🚀 Nexus Engine starting...")
 
+	// Шина событий между модулями (например, KV -> PubSub)
+	bus := core.NewBus()
+	for _, mod := range enabledModules {
+		if aware, ok := mod.(core.BusAware); ok {
+			aware.SetBus(bus)
+		}
+	}
+
 	for _, mod := range enabledModules {
 		log.Debug("Initializing module: %s", mod.Name())
 		if err := mod.Init(log); err != nil {
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// Топики шины, которые понимают встроенные модули
+const (
+	// TopicPublish — опубликовать ChannelMessage в PubSub (слушает модуль pubsub)
+	TopicPublish = "pubsub.publish"
+)
+
+// ChannelMessage — payload для TopicPublish
+type ChannelMessage struct {
+	Channel string
+	Data    any
+}
+
+// ErrNoPublisher — модуль pubsub выключен
+var ErrNoPublisher = errors.New("pubsub is not available")
+
+// ChannelPublisher — публикация в канал с результатом (реализует pubsub).
+// Нужна тем, кому важно, принята ли публикация: TopicPublish ошибки не возвращает.
+type ChannelPublisher interface {
+	PublishChannel(channel string, data any) error
+}
+
+// Bus — внутренняя шина событий между модулями.
+// Модули не импортируют друг друга: один публикует в топик, другой подписывается.
+// Доставка синхронная, в порядке публикации.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(payload any)
+
+	publisher ChannelPublisher
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		handlers: make(map[string][]func(payload any)),
+	}
+}
+
+// Subscribe регистрирует обработчик топика (обычно в Init)
+func (b *Bus) Subscribe(topic string, handler func(payload any)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[topic] = append(b.handlers[topic], handler)
+}
+
+// Publish вызывает всех подписчиков топика. Если подписчиков нет — событие теряется.
+func (b *Bus) Publish(topic string, payload any) {
+	b.mu.RLock()
+	handlers := b.handlers[topic]
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(payload)
+	}
+}
+
+// SetPublisher регистрирует ChannelPublisher (обычно в Init)
+func (b *Bus) SetPublisher(p ChannelPublisher) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.publisher = p
+}
+
+// PublishChannel публикует в канал и возвращает ошибку публикации (например, очередь полна)
+func (b *Bus) PublishChannel(channel string, data any) error {
+	b.mu.RLock()
+	p := b.publisher
+	b.mu.RUnlock()
+
+	if p == nil {
+		return ErrNoPublisher
+	}
+	return p.PublishChannel(channel, data)
+}
+
+// BusAware — модуль, которому нужна шина. Main отдает ее всем таким модулям до Init.
+type BusAware interface {
+	SetBus(bus *Bus)
+}
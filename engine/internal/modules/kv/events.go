@@ -0,0 +1,49 @@
+package kv
+
+import "time"
+
+// KeyEvent — изменение ключа в Storage (уходит наблюдателям и в keyspace-каналы PubSub).
+// События одного ключа могут прийти не по порядку: порядок задает Version. Новее то, у которого
+// она больше; "expired" несет версию протухшего значения и применяется, только если она совпадает.
+type KeyEvent struct {
+	Op    string `json:"op"` // "set", "del", "expired"
+	Key   string `json:"key"`
+	Value any    `json:"value,omitempty"`
+
+	Version uint64 `json:"version,omitempty"` // set/del — версия записи, expired — версия протухшего значения
+	At      int64  `json:"-"`                 // Когда случилось изменение (UnixNano): для записей — под локом шарда
+
+	state any // Для истории: значение именно этой записи (изменяемые структуры — копией)
+}
+
+// setEvent собирает событие записи. Вызывается под локом шарда, пока item — именно эта запись.
+func (s *Storage) setEvent(key string, item Item) KeyEvent {
+	ev := KeyEvent{Op: "set", Key: key, Value: eventValue(item.Value), Version: item.Version, At: time.Now().UnixNano()}
+	if s.history != nil && s.history.Enabled(key) {
+		ev.state = frozenValue(item.Value)
+	}
+	return ev
+}
+
+// OnChange регистрирует наблюдателя изменений.
+// Вызывается после применения изменения, вне локов шарда и снапшота. Конкурентные записи одного
+// ключа могут прийти в любом порядке: упорядочивайте по Version (или читайте ключ заново).
+func (s *Storage) OnChange(fn func(KeyEvent)) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+func (s *Storage) notify(ev KeyEvent) {
+	if ev.At == 0 {
+		ev.At = time.Now().UnixNano()
+	}
+
+	s.observersMu.RLock()
+	observers := s.observers
+	s.observersMu.RUnlock()
+
+	for _, fn := range observers {
+		fn(ev)
+	}
+}
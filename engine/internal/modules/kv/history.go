@@ -0,0 +1,272 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryRule — сколько хранить прошлых версий ключей с префиксом
+type HistoryRule struct {
+	Prefix   string
+	MaxCount int           // 0 — без лимита по количеству
+	MaxAge   time.Duration // 0 — без лимита по возрасту
+}
+
+// HistoryVersion — одна версия ключа. Deleted — ключ был удален (или протух).
+type HistoryVersion struct {
+	Key     string `json:"k"`
+	Version uint64 `json:"ver,omitempty"`
+	Value   any    `json:"v,omitempty"`
+	Deleted bool   `json:"del,omitempty"`
+	At      int64  `json:"at"` // UnixNano
+}
+
+// History — журнал версий для ключей с включенной историей.
+// Пишется append-only файлом рядом с WAL, при снапшоте сжимается до того, что осталось по лимитам.
+type History struct {
+	s     *Storage
+	rules []HistoryRule // Отсортированы по длине префикса (самый длинный — первый)
+	path  string
+
+	mu       sync.RWMutex
+	versions map[string][]HistoryVersion // key -> версии по возрастанию времени
+	file     *os.File
+	enc      *json.Encoder
+}
+
+// ParseHistoryRules разбирает флаг вида "config:=20,feature:=72h,flags:=10/24h"
+func ParseHistoryRules(spec string) ([]HistoryRule, error) {
+	var rules []HistoryRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		prefix, limits, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad history rule %q: expected prefix=limit", part)
+		}
+
+		rule := HistoryRule{Prefix: prefix}
+		for _, limit := range strings.Split(limits, "/") {
+			if n, err := strconv.Atoi(limit); err == nil {
+				rule.MaxCount = n
+				continue
+			}
+			d, err := time.ParseDuration(limit)
+			if err != nil {
+				return nil, fmt.Errorf("bad history limit %q: expected count or duration", limit)
+			}
+			rule.MaxAge = d
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func openHistory(s *Storage, path string, rules []HistoryRule) (*History, error) {
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].Prefix) > len(rules[j].Prefix) })
+
+	h := &History{
+		s:        s,
+		rules:    rules,
+		path:     path,
+		versions: make(map[string][]HistoryVersion),
+	}
+
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if err := h.Compact(); err != nil {
+		return nil, err
+	}
+
+	s.OnChange(h.onChange)
+	return h, nil
+}
+
+func (h *History) rule(key string) (HistoryRule, bool) {
+	for _, r := range h.rules {
+		if strings.HasPrefix(key, r.Prefix) {
+			return r, true
+		}
+	}
+	return HistoryRule{}, false
+}
+
+// Enabled — ведется ли история для ключа
+func (h *History) Enabled(key string) bool {
+	_, ok := h.rule(key)
+	return ok
+}
+
+func (h *History) load() error {
+	file, err := os.Open(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var v HistoryVersion
+		if err := decoder.Decode(&v); err != nil {
+			h.s.log.Error("History file is corrupted, stopping replay: %v", err)
+			break
+		}
+		h.versions[v.Key] = insertVersion(h.versions[v.Key], v)
+	}
+	return nil
+}
+
+// onChange — наблюдатель хранилища: записывает изменение как новую версию.
+// Версия, значение и время берутся из события (собраны под локом шарда), а не из текущего состояния:
+// при конкурентных записях каждая попадает в историю своей версией.
+func (h *History) onChange(ev KeyEvent) {
+	rule, ok := h.rule(ev.Key)
+	if !ok {
+		return
+	}
+
+	v := HistoryVersion{Key: ev.Key, At: ev.At}
+	if ev.Op == "set" {
+		v.Version, v.Value = ev.Version, ev.state
+	} else {
+		v.Deleted = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.enc != nil {
+		if err := h.enc.Encode(v); err != nil {
+			h.s.log.Error("History Write Error: %v", err)
+		}
+	}
+	h.versions[ev.Key] = prune(insertVersion(h.versions[ev.Key], v), rule, time.Now().UnixNano())
+}
+
+// insertVersion вставляет версию по времени: наблюдатели вызываются вне локов,
+// и события конкурентных записей могут прийти не в том порядке
+func insertVersion(list []HistoryVersion, v HistoryVersion) []HistoryVersion {
+	i := sort.Search(len(list), func(i int) bool { return list[i].At > v.At })
+	list = append(list, HistoryVersion{})
+	copy(list[i+1:], list[i:])
+	list[i] = v
+	return list
+}
+
+// prune оставляет версии в пределах лимитов правила
+func prune(list []HistoryVersion, rule HistoryRule, now int64) []HistoryVersion {
+	if rule.MaxAge > 0 {
+		cut := now - int64(rule.MaxAge)
+		i := sort.Search(len(list), func(i int) bool { return list[i].At >= cut })
+		list = list[i:]
+	}
+	if rule.MaxCount > 0 && len(list) > rule.MaxCount {
+		list = list[len(list)-rule.MaxCount:]
+	}
+	return list
+}
+
+// List возвращает версии ключа, новые первыми
+func (h *History) List(key string, limit int) []HistoryVersion {
+	rule, _ := h.rule(key)
+
+	h.mu.RLock()
+	list := prune(h.versions[key], rule, time.Now().UnixNano())
+	h.mu.RUnlock()
+
+	out := make([]HistoryVersion, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		out = append(out, list[i])
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// At возвращает значение ключа на момент ts (UnixNano). false — ключа тогда не было или история не сохранилась.
+func (h *History) At(key string, ts int64) (HistoryVersion, bool) {
+	rule, _ := h.rule(key)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	list := prune(h.versions[key], rule, time.Now().UnixNano())
+	i := sort.Search(len(list), func(i int) bool { return list[i].At > ts })
+	if i == 0 {
+		return HistoryVersion{}, false
+	}
+	v := list[i-1]
+	return v, !v.Deleted
+}
+
+// Compact переписывает файл истории, оставляя только версии в пределах лимитов
+func (h *History) Compact() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	tmp := h.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(file)
+	for key, list := range h.versions {
+		rule, ok := h.rule(key)
+		if !ok {
+			delete(h.versions, key) // Правило убрали из конфига
+			continue
+		}
+		list = prune(list, rule, now)
+		if len(list) == 0 {
+			delete(h.versions, key)
+			continue
+		}
+		h.versions[key] = list
+		for _, v := range list {
+			if err := enc.Encode(v); err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+	file.Close()
+
+	if h.file != nil {
+		h.file.Close()
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+
+	h.file, err = os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		h.file, h.enc = nil, nil
+		return err
+	}
+	h.enc = json.NewEncoder(h.file)
+	return nil
+}
+
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	return h.file.Close()
+}
@@ -0,0 +1,339 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-engine/internal/pkg/jsonpath"
+)
+
+var (
+	ErrIndexNotFound = errors.New("index not found")
+	ErrIndexExists   = errors.New("index already exists")
+	ErrBadQuery      = errors.New("bad query")
+)
+
+// IndexDef — объявление индекса: документы с префиксом Prefix индексируются по полям Fields
+type IndexDef struct {
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"` // order:
+	Fields []string `json:"fields"` // ["$.status", "$.customerId"]
+}
+
+// fieldEntry — одна запись отсортированного списка поля
+type fieldEntry struct {
+	val any
+	key string
+}
+
+// Index — вторичный индекс. Хранит копии индексируемых значений, сам документ читается из шардов.
+type Index struct {
+	def   IndexDef
+	paths map[string]jsonpath.Path // field -> разобранный путь
+
+	mu     sync.RWMutex
+	values map[string]map[string]any // key -> field -> значение
+	sorted map[string][]fieldEntry   // field -> записи по (значение, ключ)
+}
+
+// IndexManager — все индексы хранилища. Определения лежат в отдельном файле рядом со снапшотом,
+// сами индексы не персистятся: при старте строятся заново из загруженного снапшота + WAL.
+type IndexManager struct {
+	s    *Storage
+	path string
+
+	mu      sync.RWMutex
+	indexes map[string]*Index
+}
+
+func newIndexManager(s *Storage, path string) (*IndexManager, error) {
+	m := &IndexManager{s: s, path: path, indexes: make(map[string]*Index)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var defs []IndexDef
+		if err := json.Unmarshal(data, &defs); err != nil {
+			return nil, fmt.Errorf("bad index definitions %s: %w", path, err)
+		}
+		for _, def := range defs {
+			idx, err := newIndex(def)
+			if err != nil {
+				return nil, err
+			}
+			idx.rebuild(s)
+			m.indexes[def.Name] = idx
+			s.log.Info("🔎 Index '%s' rebuilt (%d docs)", def.Name, len(idx.values))
+		}
+	}
+
+	// Индексы обновляются по событиям хранилища
+	s.OnChange(m.onChange)
+	return m, nil
+}
+
+func newIndex(def IndexDef) (*Index, error) {
+	if def.Name == "" || len(def.Fields) == 0 {
+		return nil, fmt.Errorf("%w: index needs a name and at least one field", ErrBadQuery)
+	}
+
+	idx := &Index{
+		def:    def,
+		paths:  make(map[string]jsonpath.Path, len(def.Fields)),
+		values: make(map[string]map[string]any),
+		sorted: make(map[string][]fieldEntry, len(def.Fields)),
+	}
+	for _, f := range def.Fields {
+		p, err := jsonpath.Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		idx.paths[f] = p
+	}
+	return idx, nil
+}
+
+// Create объявляет индекс, строит его по текущим данным и сохраняет определения
+func (m *IndexManager) Create(def IndexDef) error {
+	idx, err := newIndex(def)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.indexes[def.Name]; ok {
+		return ErrIndexExists
+	}
+	idx.rebuild(m.s)
+	m.indexes[def.Name] = idx
+	m.s.log.Info("🔎 Index '%s' created on '%s*' (%d docs)", def.Name, def.Prefix, len(idx.values))
+	return m.saveLocked()
+}
+
+// Drop удаляет индекс
+func (m *IndexManager) Drop(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.indexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(m.indexes, name)
+	return m.saveLocked()
+}
+
+// List возвращает определения индексов
+func (m *IndexManager) List() []IndexDef {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	defs := make([]IndexDef, 0, len(m.indexes))
+	for _, idx := range m.indexes {
+		defs = append(defs, idx.def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+func (m *IndexManager) get(name string) (*Index, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	idx, ok := m.indexes[name]
+	return idx, ok
+}
+
+// saveLocked пишет определения во временный файл и атомарно подменяет
+func (m *IndexManager) saveLocked() error {
+	defs := make([]IndexDef, 0, len(m.indexes))
+	for _, idx := range m.indexes {
+		defs = append(defs, idx.def)
+	}
+
+	data, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// onChange — наблюдатель хранилища. Значение берем из шарда, а не из события:
+// события по одному ключу от разных писателей могут прийти не по порядку, а шард всегда прав.
+func (m *IndexManager) onChange(ev KeyEvent) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, idx := range m.indexes {
+		if strings.HasPrefix(ev.Key, idx.def.Prefix) {
+			idx.refresh(m.s, ev.Key)
+		}
+	}
+}
+
+// refresh переиндексирует ключ по значению в шарде. Шард читаем под локом индекса: иначе два
+// писателя могли бы прочитать A, B, а применить B, A. Так последний обновивший видит последнюю запись.
+func (idx *Index) refresh(s *Storage, key string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	item, ok := s.peek(key)
+	idx.updateLocked(key, item.Value, ok)
+}
+
+// rebuild строит индекс с нуля по всем шардам
+func (idx *Index) rebuild(s *Storage) {
+	now := time.Now().UnixNano()
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for k, item := range shard.items {
+			if strings.HasPrefix(k, idx.def.Prefix) && item.ExpiresAt >= now {
+				idx.update(k, item.Value, true)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// update переиндексирует ключ (exists=false — удалить из индекса)
+func (idx *Index) update(key string, doc any, exists bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.updateLocked(key, doc, exists)
+}
+
+func (idx *Index) updateLocked(key string, doc any, exists bool) {
+	if old, ok := idx.values[key]; ok {
+		for field, v := range old {
+			idx.removeEntry(field, fieldEntry{val: v, key: key})
+		}
+		delete(idx.values, key)
+	}
+	if !exists {
+		return
+	}
+
+	vals := idx.fields(doc)
+	for field, v := range vals {
+		idx.insertEntry(field, fieldEntry{val: v, key: key})
+	}
+	idx.values[key] = vals
+}
+
+// fields — индексируемые значения полей документа
+func (idx *Index) fields(doc any) map[string]any {
+	vals := make(map[string]any, len(idx.paths))
+	for field, p := range idx.paths {
+		if v, ok := jsonpath.Get(doc, p); ok && indexable(v) {
+			vals[field] = v
+		}
+	}
+	return vals
+}
+
+func (idx *Index) search(field string, e fieldEntry) int {
+	list := idx.sorted[field]
+	return sort.Search(len(list), func(i int) bool { return compareEntry(list[i], e) >= 0 })
+}
+
+func (idx *Index) insertEntry(field string, e fieldEntry) {
+	i := idx.search(field, e)
+	list := append(idx.sorted[field], fieldEntry{})
+	copy(list[i+1:], list[i:])
+	list[i] = e
+	idx.sorted[field] = list
+}
+
+func (idx *Index) removeEntry(field string, e fieldEntry) {
+	list := idx.sorted[field]
+	i := idx.search(field, e)
+	if i < len(list) && compareEntry(list[i], e) == 0 {
+		idx.sorted[field] = append(list[:i], list[i+1:]...)
+	}
+}
+
+// indexable — индексируем только скаляры
+func indexable(v any) bool {
+	switch v.(type) {
+	case nil, bool, float64, string:
+		return true
+	}
+	return false
+}
+
+// typeRank задает порядок между типами: null < bool < number < string
+func typeRank(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+func compareValues(a, b any) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	}
+	return 0
+}
+
+func compareEntry(a, b fieldEntry) int {
+	if c := compareValues(a.val, b.val); c != 0 {
+		return c
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+// peek читает живой ключ из шарда без похода в upstream
+func (s *Storage) peek(key string) (Item, bool) {
+	shard := s.shards[getShardIndex(key)]
+	shard.mu.RLock()
+	item, ok := shard.items[key]
+	shard.mu.RUnlock()
+
+	if !ok || time.Now().UnixNano() > item.ExpiresAt {
+		return Item{}, false
+	}
+	return item, true
+}
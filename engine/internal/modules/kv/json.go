@@ -0,0 +1,263 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"nexus-engine/internal/pkg/jsonpath"
+)
+
+var ErrJSONOp = errors.New("json operation failed")
+
+// JSONOp — частичное изменение JSON документа по пути (в WAL пишется именно она, а не весь документ)
+type JSONOp struct {
+	Op    string  `json:"op"`              // get, set, del, append, incr
+	Path  string  `json:"path"`            // $.user.tags[0]
+	Value any     `json:"value,omitempty"` // для set/append
+	By    float64 `json:"by,omitempty"`    // для incr
+}
+
+// JSON выполняет операцию над документом ключа атомарно под локом шарда.
+// Результат: get — значение по пути, set — nil, del — удалено ли, append — новая длина массива, incr — новое число.
+// Для ключей с записью в origin туда уходит весь получившийся документ (origin про пути ничего не знает).
+// del корня ("$") удаляет сам ключ.
+func (s *Storage) JSON(key string, op JSONOp) (any, error) {
+	path, err := jsonpath.Parse(op.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrJSONOp, err)
+	}
+
+	if op.Op != "get" {
+		if err := checkUserWrite(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if op.Op == "get" {
+		item, ok := s.Get(key)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		v, ok := jsonpath.Get(item.Value, path)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+
+	if op.Op == "del" && len(path) == 0 {
+		return s.jsonDeleteKey(key)
+	}
+
+	switch s.writeMode(key) {
+	case "through":
+		return s.jsonThrough(key, path, op)
+	case "behind":
+		return s.jsonBehind(key, path, op)
+	}
+
+	s.snapshotMu.RLock()
+
+	shard := s.shards[getShardIndex(key)]
+	shard.mu.Lock()
+
+	item, exists := shard.items[key]
+	if exists && time.Now().UnixNano() > item.ExpiresAt {
+		exists = false
+	}
+	if !exists {
+		item = Item{ExpiresAt: expiresAt(0)}
+	}
+
+	doc, result, changed, err := applyJSONOp(item.Value, path, op)
+	if err != nil || !changed {
+		shard.mu.Unlock()
+		s.snapshotMu.RUnlock()
+		return result, err
+	}
+
+	item.Value = doc
+	item.Version = s.nextVersion()
+	item.Upstream = false
+
+	if s.wal != nil {
+		patch := op
+		if err := s.wal.WriteEvent(WALEntry{Op: "json", Key: key, Exp: item.ExpiresAt, Ver: item.Version, Patch: &patch}); err != nil {
+			s.log.Error("WAL Write Error: %v", err)
+		}
+	}
+	shard.items[key] = item
+	ev := s.setEvent(key, item)
+	shard.mu.Unlock()
+	s.snapshotMu.RUnlock()
+
+	s.log.Debug("JSON %s key='%s' path=%s", op.Op, key, path)
+	s.notify(ev)
+	return result, nil
+}
+
+// jsonDeleteKey — удаление корня документа: удаляется сам ключ (а не null под ключом)
+func (s *Storage) jsonDeleteKey(key string) (any, error) {
+	_, exists := s.Get(key)
+	if !exists && s.writeMode(key) == "" {
+		return false, nil
+	}
+	// В origin ключ удаляем, даже если прочитать его не удалось
+	if err := s.Delete(key); err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
+
+// jsonBase — документ ключа с записью в origin, к которому применяется операция. Origin получит
+// документ целиком, поэтому считать можно только от того, что в origin есть: живого значения
+// в памяти, свежего ответа origin или его 404. Stale-копию не берем. Если origin не ответил — ошибка, а не запись поверх.
+func (s *Storage) jsonBase(key string) (Item, error) {
+	if item, ok := s.peek(key); ok {
+		return item, nil
+	}
+	if s.outbox != nil && s.outbox.pendingDelete(key) {
+		return Item{ExpiresAt: expiresAt(0)}, nil // Удаление еще не дошло до origin
+	}
+
+	value, ttl, status, err := s.loaderFor(key).Fetch(key)
+	switch {
+	case err != nil:
+		return Item{}, fmt.Errorf("%w: failed to load document '%s': %v", ErrUpstreamWrite, key, err)
+	case status == http.StatusNotFound:
+		return Item{ExpiresAt: expiresAt(0)}, nil
+	case status != http.StatusOK:
+		return Item{}, fmt.Errorf("%w: failed to load document '%s': status %d", ErrUpstreamWrite, key, status)
+	}
+	// Origin запретил кэширование — документ живет как после Set без TTL
+	return Item{Value: value, ExpiresAt: expiresAt(max(ttl, 0))}, nil
+}
+
+// jsonThrough — операция над ключом в режиме write-through: документ считается по текущему значению
+// (при промахе — из origin), пишется в origin и только потом целиком ложится в память.
+// Порядок записей ключа держит lockThrough, как в Set.
+func (s *Storage) jsonThrough(key string, path jsonpath.Path, op JSONOp) (any, error) {
+	unlock := s.lockThrough(key)
+	defer unlock()
+
+	item, err := s.jsonBase(key)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, result, changed, err := applyJSONOp(item.Value, path, op)
+	if err != nil || !changed {
+		return result, err
+	}
+
+	if err := s.writeThrough(WALEntry{Op: "set", Key: key, Value: doc}); err != nil {
+		return nil, err
+	}
+	if err := s.set(key, Item{Value: doc, ExpiresAt: item.ExpiresAt}); err != nil {
+		return nil, err
+	}
+
+	s.log.Debug("JSON %s key='%s' path=%s", op.Op, key, path)
+	return result, nil
+}
+
+// jsonBehind — операция над ключом в режиме write-behind: документ считается по текущему значению
+// (при промахе — из origin), в очередь уходит весь документ. Если ключ изменили, пока шел запрос
+// в origin, считаем заново от нового значения (compare-and-set по версии).
+func (s *Storage) jsonBehind(key string, path jsonpath.Path, op JSONOp) (any, error) {
+	for {
+		ver := s.keyVersion(key)
+		item, err := s.jsonBase(key)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, result, changed, err := applyJSONOp(item.Value, path, op)
+		if err != nil || !changed {
+			return result, err
+		}
+		ok, err := s.setIf(key, &ver, Item{Value: doc, ExpiresAt: item.ExpiresAt})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.log.Debug("JSON %s key='%s' path=%s", op.Op, key, path)
+			return result, nil
+		}
+	}
+}
+
+// applyJSONOp считает новый документ. Исходный doc не меняется (copy-on-write).
+func applyJSONOp(doc any, path jsonpath.Path, op JSONOp) (newDoc any, result any, changed bool, err error) {
+	wrap := func(err error) error { return fmt.Errorf("%w: %v", ErrJSONOp, err) }
+
+	switch op.Op {
+	case "set":
+		if newDoc, err = jsonpath.Set(doc, path, op.Value); err != nil {
+			return nil, nil, false, wrap(err)
+		}
+		return newDoc, nil, true, nil
+
+	case "del":
+		newDoc, deleted, err := jsonpath.Delete(doc, path)
+		if err != nil {
+			return nil, nil, false, wrap(err)
+		}
+		return newDoc, deleted, deleted, nil
+
+	case "append":
+		var arr []any
+		if cur, ok := jsonpath.Get(doc, path); ok && cur != nil {
+			if arr, ok = cur.([]any); !ok {
+				return nil, nil, false, wrap(fmt.Errorf("%s is not an array", path))
+			}
+		}
+		next := make([]any, 0, len(arr)+1)
+		next = append(append(next, arr...), op.Value)
+		if newDoc, err = jsonpath.Set(doc, path, next); err != nil {
+			return nil, nil, false, wrap(err)
+		}
+		return newDoc, float64(len(next)), true, nil
+
+	case "incr":
+		var n float64
+		if cur, ok := jsonpath.Get(doc, path); ok && cur != nil {
+			if n, ok = cur.(float64); !ok {
+				return nil, nil, false, wrap(fmt.Errorf("%s is not a number", path))
+			}
+		}
+		n += op.By
+		if newDoc, err = jsonpath.Set(doc, path, n); err != nil {
+			return nil, nil, false, wrap(err)
+		}
+		return newDoc, n, true, nil
+	}
+
+	return nil, nil, false, wrap(fmt.Errorf("unknown op %q", op.Op))
+}
+
+// replayJSON применяет частичное изменение из WAL
+func (s *Storage) replayJSON(entry WALEntry) {
+	if entry.Patch == nil {
+		return
+	}
+	path, err := jsonpath.Parse(entry.Patch.Path)
+	if err != nil {
+		s.log.Error("WAL: bad json patch path for '%s': %v", entry.Key, err)
+		return
+	}
+
+	shard := s.shards[getShardIndex(entry.Key)]
+	shard.mu.RLock()
+	cur := shard.items[entry.Key].Value
+	shard.mu.RUnlock()
+
+	doc, _, _, err := applyJSONOp(cur, path, *entry.Patch)
+	if err != nil {
+		s.log.Error("WAL: failed to replay json patch for '%s': %v", entry.Key, err)
+		return
+	}
+	s.restoreFromWAL(entry.Key, Item{Value: doc, ExpiresAt: entry.Exp, Version: entry.Ver})
+}
@@ -0,0 +1,280 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-engine/internal/pkg/jsonpath"
+)
+
+// LoaderConfig — описание одного upstream источника (элемент JSON конфига -kv-upstream-config)
+//
+//	[{
+//	  "prefix": "user:",
+//	  "url": "https://users.internal/api/users/{id}",
+//	  "headers": {"Authorization": "Bearer ${USERS_TOKEN}"},
+//	  "timeout": 3,
+//	  "ttl": 300,
+//	  "value_path": "$.data",
+//	  "ttl_path": "$.meta.ttl",
+//	  "cache_control": true,
+//	  "write": "through"
+//	}]
+type LoaderConfig struct {
+	Prefix       string            `json:"prefix"`
+	URL          string            `json:"url"`           // Шаблон: {key} — ключ целиком, {id} — ключ без префикса
+	Headers      map[string]string `json:"headers"`       // Значения проходят через os.ExpandEnv (секреты из окружения)
+	Timeout      int               `json:"timeout"`       // Секунды, 0 — глобальный таймаут
+	TTL          int               `json:"ttl"`           // Секунды, 0 — глобальный TTL
+	ValuePath    string            `json:"value_path"`    // Откуда брать значение в ответе (пусто — весь ответ)
+	TTLPath      string            `json:"ttl_path"`      // Откуда брать TTL в ответе (секунды)
+	CacheControl bool              `json:"cache_control"` // Учитывать Cache-Control: max-age / no-store
+	Write        string            `json:"write"`         // none, through, behind. Пусто — глобальный режим
+}
+
+// Loader — готовый к работе upstream источник для одного префикса
+type Loader struct {
+	cfg       LoaderConfig
+	client    *http.Client
+	sem       chan struct{} // Общий на все лоадеры лимит запросов в полете
+	headers   map[string]string
+	valuePath jsonpath.Path
+	ttlPath   jsonpath.Path
+	writeMode string
+}
+
+// LoadLoaderConfigs читает список лоадеров из JSON файла
+func LoadLoaderConfigs(path string) ([]LoaderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []LoaderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("bad upstream config %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+func newLoader(cfg LoaderConfig, transport http.RoundTripper, sem chan struct{}, defaults Options) (*Loader, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("upstream loader %q: empty url", cfg.Prefix)
+	}
+
+	// Без плейсхолдеров ведем себя как старый UpstreamURL: ключ дописывается в конец
+	if !strings.Contains(cfg.URL, "{key}") && !strings.Contains(cfg.URL, "{id}") {
+		cfg.URL = strings.TrimSuffix(cfg.URL, "/") + "/{key}"
+	}
+
+	timeout := defaults.UpstreamTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaults.DefaultUpstreamTTL
+	}
+
+	l := &Loader{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: timeout, Transport: transport},
+		sem:       sem,
+		headers:   make(map[string]string, len(cfg.Headers)),
+		writeMode: cfg.Write,
+	}
+	if l.writeMode == "" {
+		l.writeMode = defaults.UpstreamWriteMode
+	}
+	switch l.writeMode {
+	case "", "none", "through", "behind":
+	default:
+		return nil, fmt.Errorf("upstream loader %q: unknown write mode %q", cfg.Prefix, l.writeMode)
+	}
+
+	for k, v := range cfg.Headers {
+		l.headers[k] = os.ExpandEnv(v)
+	}
+
+	var err error
+	if cfg.ValuePath != "" {
+		if l.valuePath, err = jsonpath.Parse(cfg.ValuePath); err != nil {
+			return nil, fmt.Errorf("upstream loader %q: value_path: %w", cfg.Prefix, err)
+		}
+	}
+	if cfg.TTLPath != "" {
+		if l.ttlPath, err = jsonpath.Parse(cfg.TTLPath); err != nil {
+			return nil, fmt.Errorf("upstream loader %q: ttl_path: %w", cfg.Prefix, err)
+		}
+	}
+	return l, nil
+}
+
+// url подставляет ключ в шаблон
+func (l *Loader) url(key string) string {
+	id := strings.TrimPrefix(key, l.cfg.Prefix)
+	r := strings.NewReplacer("{key}", url.PathEscape(key), "{id}", url.PathEscape(id))
+	return r.Replace(l.cfg.URL)
+}
+
+func (l *Loader) newRequest(method, key string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, l.url(key), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range l.headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// do выполняет запрос, соблюдая общий лимит запросов в полете
+func (l *Loader) do(req *http.Request) (*http.Response, error) {
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+	return l.client.Do(req)
+}
+
+// Fetch загружает значение ключа.
+// ttl < 0 означает, что origin запретил кэширование (Cache-Control: no-store).
+func (l *Loader) Fetch(key string) (value any, ttl int, status int, err error) {
+	req, err := l.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Лимит запросов в полете уже держит Upstream.run
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, resp.StatusCode, nil
+	}
+
+	var body any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, 0, resp.StatusCode, err
+	}
+
+	value = body
+	if l.valuePath != nil {
+		var ok bool
+		if value, ok = jsonpath.Get(body, l.valuePath); !ok {
+			return nil, 0, resp.StatusCode, fmt.Errorf("value_path %s not found in response", l.valuePath)
+		}
+	}
+
+	return value, l.resolveTTL(body, resp.Header), resp.StatusCode, nil
+}
+
+// resolveTTL: ttl_path > Cache-Control > TTL лоадера
+func (l *Loader) resolveTTL(body any, header http.Header) int {
+	if l.ttlPath != nil {
+		if v, ok := jsonpath.Get(body, l.ttlPath); ok {
+			if n, ok := v.(float64); ok && n > 0 {
+				return int(n)
+			}
+		}
+	}
+
+	if l.cfg.CacheControl {
+		if ttl, ok := parseCacheControl(header.Get("Cache-Control")); ok {
+			return ttl
+		}
+	}
+
+	return l.cfg.TTL
+}
+
+// parseCacheControl достает max-age (s-maxage приоритетнее). no-store/no-cache -> -1.
+func parseCacheControl(value string) (int, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	ttl, found := 0, false
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return -1, true
+		case "max-age":
+			if n, err := strconv.Atoi(arg); err == nil && !found {
+				ttl, found = n, true
+			}
+		case "s-maxage":
+			if n, err := strconv.Atoi(arg); err == nil {
+				ttl, found = n, true
+			}
+		}
+	}
+	if found && ttl <= 0 {
+		return -1, true
+	}
+	return ttl, found
+}
+
+// Write синхронно делает PUT (set) или DELETE (del) в origin
+func (l *Loader) Write(entry WALEntry) error {
+	var req *http.Request
+	var err error
+
+	switch entry.Op {
+	case "set":
+		body, mErr := json.Marshal(entry.Value)
+		if mErr != nil {
+			return mErr
+		}
+		req, err = l.newRequest(http.MethodPut, entry.Key, body)
+	case "del":
+		req, err = l.newRequest(http.MethodDelete, entry.Key, nil)
+	default:
+		return fmt.Errorf("unsupported upstream op: %s", entry.Op)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := l.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 404 на удаление — ключа и так нет, это успех
+	if entry.Op == "del" && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if permanent(resp.StatusCode) {
+			return fmt.Errorf("%w: %w: status %d", ErrUpstreamWrite, ErrUpstreamRejected, resp.StatusCode)
+		}
+		return fmt.Errorf("%w: status %d", ErrUpstreamWrite, resp.StatusCode)
+	}
+	return nil
+}
+
+// permanent — ответ origin, после которого запись повторять бесполезно: 4xx,
+// кроме таймаута и лимита запросов
+func permanent(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
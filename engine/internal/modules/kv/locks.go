@@ -0,0 +1,267 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ключи, под которыми LockManager хранит состояние в Storage. Менять их через публичные операции
+// нельзя: удаленный __fence__ сбросил бы счетчик и сломал монотонность токенов.
+const (
+	lockKeyPrefix  = "__lock__:"  // Текущий владелец: {"owner", "token"}, TTL = аренда
+	fenceKeyPrefix = "__fence__:" // Счетчик fencing token, живет вечно
+)
+
+// internalKey — служебный ключ LockManager (не уходит в upstream origin)
+func internalKey(key string) bool {
+	return strings.HasPrefix(key, lockKeyPrefix) || strings.HasPrefix(key, fenceKeyPrefix)
+}
+
+// checkUserWrite — можно ли менять ключ публичной операцией (Set, Delete, tx, скрипты, JSON, скетчи, стримы)
+func checkUserWrite(key string) error {
+	if internalKey(key) {
+		return fmt.Errorf("%w: '%s'", ErrReservedKey, key)
+	}
+	return nil
+}
+
+var (
+	// ErrReservedKey — ключ принадлежит LockManager
+	ErrReservedKey  = errors.New("key is reserved for locks")
+	ErrLockTimeout  = errors.New("lock wait timeout")
+	ErrLockNotOwner = errors.New("lock is not held by this owner")
+	ErrLockInvalid  = errors.New("invalid lock request")
+)
+
+// LockInfo — состояние захваченного лока
+type LockInfo struct {
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	Token     uint64 `json:"token"`      // Fencing token: строго растет при каждом захвате
+	ExpiresAt int64  `json:"expires_at"` // Unix ms
+}
+
+// lockWaiter — участник очереди ожидания одного лока
+type lockWaiter struct {
+	wake chan struct{}
+}
+
+// LockManager — распределенные локи поверх Storage.
+// Состояние лока и счетчик токенов — обычные ключи, изменяемые транзакционно (withKeys),
+// поэтому они попадают в WAL/снапшот и переживают рестарт. Очередь ожидающих живет в памяти.
+type LockManager struct {
+	s *Storage
+
+	mu     sync.Mutex
+	queues map[string][]*lockWaiter // name -> FIFO очередь (голова пробует захватить)
+}
+
+func NewLockManager(s *Storage) *LockManager {
+	return &LockManager{s: s, queues: make(map[string][]*lockWaiter)}
+}
+
+// Acquire захватывает лок на lease. Если он занят — ждет в очереди до wait (0 — не ждать).
+// Повторный Acquire тем же owner продлевает аренду и возвращает тот же токен.
+func (m *LockManager) Acquire(ctx context.Context, name, owner string, lease, wait time.Duration) (LockInfo, error) {
+	if name == "" || owner == "" || lease <= 0 {
+		return LockInfo{}, ErrLockInvalid
+	}
+
+	w := m.enqueue(name)
+	defer m.dequeue(name, w)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		// Пробует только голова очереди — так ожидающие получают лок по порядку
+		var retryAt time.Time
+		if m.isHead(name, w) {
+			info, holderExp, err := m.tryAcquire(name, owner, lease)
+			if err == nil {
+				return info, nil
+			}
+			if !errors.Is(err, ErrLockTimeout) {
+				return LockInfo{}, err
+			}
+			// Занято: проснемся, когда аренда текущего владельца истечет (если его раньше не отпустят)
+			retryAt = time.Unix(0, holderExp)
+		}
+
+		if wait <= 0 {
+			return LockInfo{}, ErrLockTimeout
+		}
+
+		if err := m.sleep(ctx, w, retryAt, timer.C); err != nil {
+			return LockInfo{}, err
+		}
+	}
+}
+
+// sleep ждет сигнала очереди, конца чужой аренды (retryAt) или общего таймаута
+func (m *LockManager) sleep(ctx context.Context, w *lockWaiter, retryAt time.Time, deadline <-chan time.Time) error {
+	var expiry <-chan time.Time
+	if !retryAt.IsZero() {
+		t := time.NewTimer(time.Until(retryAt) + time.Millisecond)
+		defer t.Stop()
+		expiry = t.C
+	}
+
+	select {
+	case <-w.wake:
+	case <-expiry:
+	case <-deadline:
+		return ErrLockTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// tryAcquire атомарно захватывает свободный (или свой) лок. Если занят — ErrLockTimeout и время конца чужой аренды.
+func (m *LockManager) tryAcquire(name, owner string, lease time.Duration) (LockInfo, int64, error) {
+	lockKey, fenceKey := lockKeyPrefix+name, fenceKeyPrefix+name
+
+	var info LockInfo
+	var holderExp int64
+	err := m.s.withKeys([]string{lockKey, fenceKey}, func(view *txView) error {
+		cur := view.get(lockKey)
+		holder, token := lockState(cur)
+
+		if cur.exists && holder != owner {
+			holderExp = cur.item.ExpiresAt
+			return ErrLockTimeout
+		}
+
+		// Свой лок — продлеваем с тем же токеном, иначе выдаем новый
+		if !cur.exists {
+			fence := view.get(fenceKey)
+			prev, _ := fence.item.Value.(float64)
+			token = uint64(prev) + 1
+			view.put(fenceKey, Item{Value: float64(token), ExpiresAt: expiresAt(0)})
+		}
+
+		exp := time.Now().Add(lease).UnixNano()
+		view.put(lockKey, Item{
+			Value:     map[string]any{"owner": owner, "token": float64(token)},
+			ExpiresAt: exp,
+		})
+		info = LockInfo{Name: name, Owner: owner, Token: token, ExpiresAt: exp / int64(time.Millisecond)}
+		return nil
+	})
+	return info, holderExp, err
+}
+
+// Renew продлевает аренду. Владелец и токен должны совпадать, а аренда — еще не истечь.
+func (m *LockManager) Renew(name, owner string, token uint64, lease time.Duration) (LockInfo, error) {
+	if lease <= 0 {
+		return LockInfo{}, ErrLockInvalid
+	}
+	lockKey := lockKeyPrefix + name
+
+	var info LockInfo
+	err := m.s.withKeys([]string{lockKey}, func(view *txView) error {
+		cur := view.get(lockKey)
+		holder, curToken := lockState(cur)
+		if !cur.exists || holder != owner || curToken != token {
+			return ErrLockNotOwner
+		}
+
+		item := cur.item
+		item.ExpiresAt = time.Now().Add(lease).UnixNano()
+		view.put(lockKey, item)
+		info = LockInfo{Name: name, Owner: owner, Token: token, ExpiresAt: item.ExpiresAt / int64(time.Millisecond)}
+		return nil
+	})
+	return info, err
+}
+
+// Release отпускает лок и будит следующего в очереди
+func (m *LockManager) Release(name, owner string, token uint64) error {
+	lockKey := lockKeyPrefix + name
+
+	err := m.s.withKeys([]string{lockKey}, func(view *txView) error {
+		cur := view.get(lockKey)
+		holder, curToken := lockState(cur)
+		if !cur.exists || holder != owner || curToken != token {
+			return ErrLockNotOwner
+		}
+		view.remove(lockKey)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.wakeHead(name)
+	return nil
+}
+
+// lockState достает владельца и токен из значения лока
+func lockState(st *staged) (string, uint64) {
+	if !st.exists {
+		return "", 0
+	}
+	v, _ := st.item.Value.(map[string]any)
+	owner, _ := v["owner"].(string)
+	token, _ := v["token"].(float64)
+	return owner, uint64(token)
+}
+
+func (m *LockManager) enqueue(name string) *lockWaiter {
+	w := &lockWaiter{wake: make(chan struct{}, 1)}
+
+	m.mu.Lock()
+	m.queues[name] = append(m.queues[name], w)
+	m.mu.Unlock()
+	return w
+}
+
+// dequeue убирает участника из очереди; если он был головой — будит следующего
+func (m *LockManager) dequeue(name string, w *lockWaiter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q := m.queues[name]
+	for i, cur := range q {
+		if cur != w {
+			continue
+		}
+		q = append(q[:i], q[i+1:]...)
+		if len(q) == 0 {
+			delete(m.queues, name)
+			return
+		}
+		m.queues[name] = q
+		if i == 0 {
+			signal(q[0])
+		}
+		return
+	}
+}
+
+func (m *LockManager) isHead(name string, w *lockWaiter) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queues[name]
+	return len(q) > 0 && q[0] == w
+}
+
+func (m *LockManager) wakeHead(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if q := m.queues[name]; len(q) > 0 {
+		signal(q[0])
+	}
+}
+
+func signal(w *lockWaiter) {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"nexus-engine/internal/core"
 	"nexus-engine/internal/pkg/logger"
+	"nexus-engine/internal/pkg/sketch"
 	"time"
 )
 
@@ -13,13 +14,25 @@ var _ core.Module = (*Module)(nil)
 
 type Module struct {
 	store *Storage
+	bus   *core.Bus
 
 	// Флаги CLI
 	fDataDir         *string
 	fUpstreamURL     *string
+	fUpstreamConfig  *string
 	fUpstreamTTL     *int
+	fUpstreamFlight  *int
+	fUpstreamTimeout *int
+	fUpstreamStale   *int
+	fUpstreamMissing *int
+	fUpstreamWrite   *string
+	fWriteBatch      *int
+	fWriteInterval   *int
 	fSaveInterval    *int
 	fCleanupInterval *int
+	fEventsPrefix    *string
+	fScriptSteps     *int
+	fHistory         *string
 }
 
 func NewModule() *Module {
@@ -30,6 +43,11 @@ func (m *Module) Name() string {
 	return "KV-Store"
 }
 
+// SetBus — KV публикует keyspace-события в PubSub через шину
+func (m *Module) SetBus(bus *core.Bus) {
+	m.bus = bus
+}
+
 func (m *Module) RegisterFlags(fs *flag.FlagSet) {
 	m.fDataDir = fs.String("kv-data-dir", "./data", "Directory for KV persistence")
 
@@ -37,11 +55,37 @@ func (m *Module) RegisterFlags(fs *flag.FlagSet) {
 	m.fSaveInterval = fs.Int("kv-save-interval", 30, "Interval in seconds to save to disk")
 	m.fCleanupInterval = fs.Int("kv-cleanup-interval", 10, "Interval in seconds to remove expired keys")
 
+	m.fScriptSteps = fs.Int("kv-script-max-steps", 100000, "Max evaluation steps per script run")
+	m.fHistory = fs.String("kv-history", "", "Key history retention per prefix, e.g. config:=20,feature:=72h,flags:=10/24h")
+	m.fEventsPrefix = fs.String("kv-events-prefix", "", "PubSub channel prefix for keyspace events, e.g. __kv__: (empty = disabled). Events of one key may arrive out of order: order them by version")
+
 	m.fUpstreamURL = fs.String("kv-upstream-url", "", "URL for cache-aside pattern")
+	m.fUpstreamConfig = fs.String("kv-upstream-config", "", "Path to JSON file with per-prefix upstream loaders")
 	m.fUpstreamTTL = fs.Int("kv-upstream-ttl", 60, "TTL for upstream items")
+	m.fUpstreamFlight = fs.Int("kv-upstream-max-inflight", 64, "Max concurrent requests to upstream")
+	m.fUpstreamTimeout = fs.Int("kv-upstream-timeout", 5, "Timeout in seconds for upstream requests")
+	m.fUpstreamStale = fs.Int("kv-upstream-stale-ttl", 0, "Seconds to serve expired upstream items while refreshing in background")
+	m.fUpstreamMissing = fs.Int("kv-upstream-missing-ttl", 0, "Seconds to cache upstream 404 responses")
+
+	m.fUpstreamWrite = fs.String("kv-upstream-write", "none", "Write mode to upstream: none, through or behind")
+	m.fWriteBatch = fs.Int("kv-upstream-write-batch", 100, "Max writes per write-behind batch")
+	m.fWriteInterval = fs.Int("kv-upstream-write-interval", 1000, "Interval in milliseconds to flush write-behind queue")
 }
 
 func (m *Module) Init(log *logger.Logger) error {
+	var loaders []LoaderConfig
+	if *m.fUpstreamConfig != "" {
+		var err error
+		if loaders, err = LoadLoaderConfigs(*m.fUpstreamConfig); err != nil {
+			return err
+		}
+	}
+
+	history, err := ParseHistoryRules(*m.fHistory)
+	if err != nil {
+		return err
+	}
+
 	// Собираем конфиг из флагов
 	opts := Options{
 		PersistPath: *m.fDataDir + "/kv.json",
@@ -50,23 +94,55 @@ func (m *Module) Init(log *logger.Logger) error {
 		CleanupInterval: time.Duration(*m.fCleanupInterval) * time.Second,
 
 		UpstreamURL:        *m.fUpstreamURL,
-		UpstreamEnabled:    *m.fUpstreamURL != "",
+		UpstreamLoaders:    loaders,
+		UpstreamEnabled:    *m.fUpstreamURL != "" || len(loaders) > 0,
 		DefaultUpstreamTTL: *m.fUpstreamTTL,
+		UpstreamMaxFlight:  *m.fUpstreamFlight,
+		UpstreamTimeout:    time.Duration(*m.fUpstreamTimeout) * time.Second,
+		UpstreamStaleTTL:   time.Duration(*m.fUpstreamStale) * time.Second,
+		UpstreamMissingTTL: time.Duration(*m.fUpstreamMissing) * time.Second,
+		UpstreamWriteMode:  *m.fUpstreamWrite,
+		WriteBatchSize:     *m.fWriteBatch,
+		WriteInterval:      time.Duration(*m.fWriteInterval) * time.Millisecond,
+		ScriptMaxSteps:     *m.fScriptSteps,
+		HistoryRules:       history,
 		Logger:             log,
 	}
 
-	var err error
 	m.store, err = New(opts)
 	if err != nil {
 		return err
 	}
 
+	// Keyspace-события: set/del/expired ключа user:42 уходят в канал <prefix>user:42
+	if prefix := *m.fEventsPrefix; prefix != "" && m.bus != nil {
+		m.store.OnChange(func(ev KeyEvent) {
+			m.bus.Publish(core.TopicPublish, core.ChannelMessage{Channel: prefix + ev.Key, Data: ev})
+		})
+		log.Info("📣 Keyspace events enabled: %s<key>", prefix)
+	}
+
 	return nil
 }
 
 func (m *Module) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/kv/get", m.handleGet)
 	mux.HandleFunc("/kv/set", m.handleSet)
+	mux.HandleFunc("/kv/delete", m.handleDelete)
+	mux.HandleFunc("/kv/tx", m.handleTx)
+	mux.HandleFunc("/kv/json", m.handleJSON)
+	mux.HandleFunc("/kv/index", m.handleIndex)
+	mux.HandleFunc("/kv/query", m.handleQuery)
+	mux.HandleFunc("/kv/hll", m.handleSketch(sketch.KindHLL))
+	mux.HandleFunc("/kv/bloom", m.handleSketch(sketch.KindBloom))
+	mux.HandleFunc("/kv/cms", m.handleSketch(sketch.KindCMS))
+	mux.HandleFunc("/kv/stream", m.handleStream)
+	mux.HandleFunc("/kv/history", m.handleHistory)
+	mux.HandleFunc("/kv/lock/acquire", m.handleLockAcquire)
+	mux.HandleFunc("/kv/lock/renew", m.handleLockRenew)
+	mux.HandleFunc("/kv/lock/release", m.handleLockRelease)
+	mux.HandleFunc("/kv/script/load", m.handleScriptLoad)
+	mux.HandleFunc("/kv/script/eval", m.handleScriptEval)
 }
 
 func (m *Module) Shutdown() {
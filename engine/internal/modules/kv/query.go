@@ -0,0 +1,243 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// QueryCond — условие на индексируемое поле
+type QueryCond struct {
+	Field string `json:"field"`
+	Op    string `json:"op"` // eq, ne, lt, lte, gt, gte
+	Value any    `json:"value"`
+}
+
+type QuerySort struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+// Query — запрос к индексу с пагинацией через offset
+type Query struct {
+	Index  string      `json:"index"`
+	Where  []QueryCond `json:"where"`
+	Sort   *QuerySort  `json:"sort,omitempty"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+type QueryHit struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+type QueryResult struct {
+	Items      []QueryHit `json:"items"`
+	Total      int        `json:"total"`
+	NextOffset int        `json:"next_offset,omitempty"` // 0 — дальше страниц нет
+}
+
+// Query выполняет запрос: берет диапазон по одному полю из отсортированного списка,
+// остальные условия проверяет по значениям в индексе, документы читает из шардов.
+func (s *Storage) Query(q Query) (QueryResult, error) {
+	if s.indexes == nil {
+		return QueryResult{}, ErrIndexNotFound
+	}
+	idx, ok := s.indexes.get(q.Index)
+	if !ok {
+		return QueryResult{}, ErrIndexNotFound
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	}
+	q.Limit = min(q.Limit, maxQueryLimit)
+	q.Offset = max(q.Offset, 0)
+
+	keys, err := idx.query(q)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	// Индекс может отставать от шарда (протухшие, удаленные и только что измененные ключи).
+	// Документ перепроверяем по условиям до пагинации, иначе Total и окно offset/limit
+	// разойдутся с тем, что реально отдаем.
+	res := QueryResult{Items: []QueryHit{}}
+	for _, k := range keys {
+		item, ok := s.peek(k)
+		if !ok || !matchValues(idx.fields(item.Value), q.Where) {
+			continue
+		}
+		if res.Total >= q.Offset && len(res.Items) < q.Limit {
+			res.Items = append(res.Items, QueryHit{Key: k, Value: item.Value})
+		}
+		res.Total++
+	}
+	if end := q.Offset + len(res.Items); end < res.Total {
+		res.NextOffset = end
+	}
+	return res, nil
+}
+
+// query возвращает все подходящие ключи в нужном порядке
+func (idx *Index) query(q Query) ([]string, error) {
+	for _, c := range q.Where {
+		if _, ok := idx.paths[c.Field]; !ok {
+			return nil, fmt.Errorf("%w: field '%s' is not indexed", ErrBadQuery, c.Field)
+		}
+		switch c.Op {
+		case "eq", "ne", "lt", "lte", "gt", "gte":
+		default:
+			return nil, fmt.Errorf("%w: unknown op '%s'", ErrBadQuery, c.Op)
+		}
+		if !indexable(c.Value) {
+			return nil, fmt.Errorf("%w: value for '%s' must be a scalar", ErrBadQuery, c.Field)
+		}
+	}
+	if q.Sort != nil {
+		if _, ok := idx.paths[q.Sort.Field]; !ok {
+			return nil, fmt.Errorf("%w: sort field '%s' is not indexed", ErrBadQuery, q.Sort.Field)
+		}
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// 1. Ведущее поле: равенство, затем диапазон, затем поле сортировки
+	driving := ""
+	for _, c := range q.Where {
+		if c.Op == "eq" {
+			driving = c.Field
+			break
+		}
+	}
+	if driving == "" {
+		for _, c := range q.Where {
+			if c.Op != "ne" {
+				driving = c.Field
+				break
+			}
+		}
+	}
+	if driving == "" && q.Sort != nil {
+		driving = q.Sort.Field
+	}
+
+	// 2. Кандидаты
+	var keys []string
+	if driving != "" {
+		list := idx.sorted[driving]
+		from, to := idx.bounds(list, driving, q.Where)
+		for _, e := range list[from:to] {
+			if idx.matches(e.key, q.Where) {
+				keys = append(keys, e.key)
+			}
+		}
+	} else {
+		for k := range idx.values {
+			if idx.matches(k, q.Where) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+	}
+
+	// 3. Порядок
+	if q.Sort != nil {
+		if q.Sort.Field != driving {
+			field := q.Sort.Field
+			sort.SliceStable(keys, func(i, j int) bool {
+				vi, iok := idx.values[keys[i]][field]
+				vj, jok := idx.values[keys[j]][field]
+				if iok != jok {
+					return iok // Документы без поля — в конце
+				}
+				return compareEntry(fieldEntry{vi, keys[i]}, fieldEntry{vj, keys[j]}) < 0
+			})
+		}
+		if q.Sort.Desc {
+			for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+		}
+	}
+	return keys, nil
+}
+
+// bounds сужает отсортированный список по условиям на ведущее поле
+func (idx *Index) bounds(list []fieldEntry, field string, where []QueryCond) (int, int) {
+	from, to := 0, len(list)
+	for _, c := range where {
+		if c.Field != field {
+			continue
+		}
+		// Первый элемент >= v и первый элемент > v
+		geq := sort.Search(len(list), func(i int) bool { return compareValues(list[i].val, c.Value) >= 0 })
+		gt := sort.Search(len(list), func(i int) bool { return compareValues(list[i].val, c.Value) > 0 })
+
+		switch c.Op {
+		case "eq":
+			from, to = max(from, geq), min(to, gt)
+		case "gt":
+			from = max(from, gt)
+		case "gte":
+			from = max(from, geq)
+		case "lt":
+			to = min(to, geq)
+		case "lte":
+			to = min(to, gt)
+		}
+	}
+	if from > to {
+		return 0, 0
+	}
+	return from, to
+}
+
+// matches проверяет все условия по значениям из индекса
+func (idx *Index) matches(key string, where []QueryCond) bool {
+	return matchValues(idx.values[key], where)
+}
+
+// matchValues проверяет все условия по значениям полей
+func matchValues(vals map[string]any, where []QueryCond) bool {
+	for _, c := range where {
+		v, ok := vals[c.Field]
+		if !ok {
+			return false
+		}
+		// Сравниваем только значения одного типа: "lt 10" не должно находить строки и null
+		if typeRank(v) != typeRank(c.Value) {
+			if c.Op == "ne" {
+				continue
+			}
+			return false
+		}
+
+		cmp := compareValues(v, c.Value)
+		var pass bool
+		switch c.Op {
+		case "eq":
+			pass = cmp == 0
+		case "ne":
+			pass = cmp != 0
+		case "lt":
+			pass = cmp < 0
+		case "lte":
+			pass = cmp <= 0
+		case "gt":
+			pass = cmp > 0
+		case "gte":
+			pass = cmp >= 0
+		}
+		if !pass {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package kv
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"nexus-engine/internal/pkg/script"
+)
+
+var ErrScriptNotFound = errors.New("script not found")
+
+// ScriptCache — скомпилированные скрипты по SHA1 исходника (как SCRIPT LOAD в Redis).
+// Живет только в памяти: после рестарта клиент загружает скрипты заново.
+type ScriptCache struct {
+	mu       sync.RWMutex
+	programs map[string]*script.Program
+}
+
+func NewScriptCache() *ScriptCache {
+	return &ScriptCache{programs: make(map[string]*script.Program)}
+}
+
+// Load компилирует скрипт и возвращает его хэш
+func (c *ScriptCache) Load(src string) (string, *script.Program, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+
+	c.mu.RLock()
+	prog, ok := c.programs[sha]
+	c.mu.RUnlock()
+	if ok {
+		return sha, prog, nil
+	}
+
+	prog, err := script.Parse(src)
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.mu.Lock()
+	c.programs[sha] = prog
+	c.mu.Unlock()
+	return sha, prog, nil
+}
+
+func (c *ScriptCache) Get(sha string) (*script.Program, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	prog, ok := c.programs[sha]
+	return prog, ok
+}
+
+// EvalScript атомарно выполняет скрипт над объявленными ключами.
+// Все ключи лочатся на время исполнения, изменения пишутся в WAL одной записью "tx".
+// Если скрипт упал (ошибка, лимит шагов) — ни одно изменение не применяется.
+// Как и в транзакциях, ключи write-behind уходят в очередь origin с коммитом, а ключи
+// write-through скрипт менять не может (ошибка на kv.set/kv.del/kv.incr).
+func (s *Storage) EvalScript(prog *script.Program, keys []string, args []any) (any, error) {
+	declared := make(map[string]bool, len(keys))
+	keyList := make([]any, len(keys))
+	for i, k := range keys {
+		declared[k] = true
+		keyList[i] = k
+	}
+	if args == nil {
+		args = []any{}
+	}
+
+	var result any
+	err := s.withKeys(keys, func(view *txView) error {
+		// Скрипт видит только объявленные ключи — иначе мы не смогли бы их залочить заранее
+		keyArg := func(v any) (string, error) {
+			k, ok := v.(string)
+			if !ok {
+				return "", fmt.Errorf("key must be string")
+			}
+			if !declared[k] {
+				return "", fmt.Errorf("key '%s' is not declared in KEYS", k)
+			}
+			return k, nil
+		}
+		// writeKey — ключ, который скрипт меняет
+		writeKey := func(v any) (string, error) {
+			k, err := keyArg(v)
+			if err != nil {
+				return "", err
+			}
+			return k, s.checkTxWrite(k)
+		}
+
+		funcs := map[string]script.Func{
+			"kv.get": func(a []any) (any, error) {
+				if len(a) != 1 {
+					return nil, fmt.Errorf("expects (kv.get key)")
+				}
+				k, err := keyArg(a[0])
+				if err != nil {
+					return nil, err
+				}
+				return view.get(k).item.Value, nil
+			},
+			"kv.exists": func(a []any) (any, error) {
+				if len(a) != 1 {
+					return nil, fmt.Errorf("expects (kv.exists key)")
+				}
+				k, err := keyArg(a[0])
+				if err != nil {
+					return nil, err
+				}
+				return view.get(k).exists, nil
+			},
+			"kv.set": func(a []any) (any, error) {
+				if len(a) != 2 && len(a) != 3 {
+					return nil, fmt.Errorf("expects (kv.set key value [ttl])")
+				}
+				k, err := writeKey(a[0])
+				if err != nil {
+					return nil, err
+				}
+				ttl := 0
+				if len(a) == 3 {
+					n, ok := a[2].(float64)
+					if !ok {
+						return nil, fmt.Errorf("ttl must be number")
+					}
+					ttl = int(n)
+				}
+				view.put(k, Item{Value: a[1], ExpiresAt: expiresAt(ttl)})
+				return a[1], nil
+			},
+			"kv.del": func(a []any) (any, error) {
+				if len(a) != 1 {
+					return nil, fmt.Errorf("expects (kv.del key)")
+				}
+				k, err := writeKey(a[0])
+				if err != nil {
+					return nil, err
+				}
+				existed := view.get(k).exists
+				view.remove(k)
+				return existed, nil
+			},
+			"kv.incr": func(a []any) (any, error) {
+				if len(a) != 2 {
+					return nil, fmt.Errorf("expects (kv.incr key by)")
+				}
+				k, err := writeKey(a[0])
+				if err != nil {
+					return nil, err
+				}
+				by, ok := a[1].(float64)
+				if !ok {
+					return nil, fmt.Errorf("increment must be number")
+				}
+
+				st := view.get(k)
+				item := st.item
+				var cur float64
+				if st.exists {
+					if cur, ok = item.Value.(float64); !ok {
+						return nil, fmt.Errorf("value of '%s' is not a number", k)
+					}
+				} else {
+					item.ExpiresAt = expiresAt(0)
+				}
+				item.Value = cur + by
+				view.put(k, item)
+				return item.Value, nil
+			},
+		}
+
+		var err error
+		result, err = prog.Run(
+			map[string]any{"KEYS": keyList, "ARGV": args},
+			funcs,
+			script.Limits{MaxSteps: s.opts.ScriptMaxSteps},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,401 @@
+package kv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"nexus-engine/internal/pkg/sketch"
+)
+
+var (
+	ErrSketchOp    = errors.New("sketch operation failed")
+	ErrWrongType   = errors.New("key holds a value of another type")
+	ErrSketchExist = errors.New("key already exists")
+)
+
+// Параметры по умолчанию, если структура создается неявно (первым add/incr)
+const (
+	defaultBloomCapacity  = 100000
+	defaultBloomErrorRate = 0.01
+	defaultCMSWidth       = 2000
+	defaultCMSDepth       = 5
+	defaultCMSTopK        = 10
+)
+
+// SketchValue — значение ключа с вероятностной структурой (HLL, Bloom, CMS).
+// В отличие от JSON документов меняется на месте под своим локом:
+// копировать мегабайтный фильтр на каждый add слишком дорого.
+type SketchValue struct {
+	mu sync.RWMutex
+	s  sketch.Sketch
+}
+
+// sketchEnvelope — вид скетча в JSON (снапшот, WAL, /kv/get): тип + бинарные данные в base64
+type sketchEnvelope struct {
+	Type string `json:"$sketch"`
+	Data []byte `json:"data"`
+}
+
+func (v *SketchValue) MarshalJSON() ([]byte, error) {
+	v.mu.RLock()
+	data, err := v.s.MarshalBinary()
+	kind := v.s.Kind()
+	v.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sketchEnvelope{Type: kind, Data: data})
+}
+
+func (v *SketchValue) clone() *SketchValue {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return &SketchValue{s: v.s.Clone()}
+}
+
+func (v *SketchValue) cloneValue() any { return v.clone() }
+
+func decodeSketch(m map[string]any) (*SketchValue, bool) {
+	kind, ok := m["$sketch"].(string)
+	if !ok || len(m) != 2 {
+		return nil, false
+	}
+	encoded, ok := m["data"].(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	s, err := sketch.Unmarshal(kind, data)
+	if err != nil {
+		return nil, false
+	}
+	return &SketchValue{s: s}, true
+}
+
+// SketchOp — операция над скетчем (в WAL пишется она, а не вся структура)
+type SketchOp struct {
+	Type  string   `json:"type"` // hll, bloom, cms
+	Op    string   `json:"op"`
+	Items []string `json:"items,omitempty"`
+	Keys  []string `json:"keys,omitempty"` // hll count/merge: дополнительные ключи
+	By    uint64   `json:"by,omitempty"`   // cms incr (по умолчанию 1)
+
+	// Параметры создания (reserve/init или неявное создание первым add/incr)
+	Capacity  uint64  `json:"capacity,omitempty"`
+	ErrorRate float64 `json:"error_rate,omitempty"`
+	Width     uint32  `json:"width,omitempty"`
+	Depth     uint32  `json:"depth,omitempty"`
+	TopK      *int    `json:"top_k,omitempty"`
+	TTL       int     `json:"ttl,omitempty"` // Только при создании ключа
+}
+
+func (op *SketchOp) readOnly() bool {
+	switch op.Type + "." + op.Op {
+	case "hll.count", "bloom.check", "bloom.info", "cms.query", "cms.top", "cms.info":
+		return true
+	}
+	return false
+}
+
+// Sketch выполняет операцию над скетчем ключа.
+// Результаты: hll add — изменилась ли оценка, count/merge — оценка; bloom add — []bool "добавлен впервые",
+// check — []bool "возможно есть"; cms incr/query — []uint64 оценок, top — []TopEntry.
+// Скетч origin не передать, поэтому ключи с записью в origin (write-through/behind) менять нельзя.
+func (s *Storage) Sketch(key string, op SketchOp) (any, error) {
+	switch {
+	case op.readOnly():
+		return s.readSketch(key, op)
+	case internalKey(key):
+		return nil, checkUserWrite(key)
+	case s.writeMode(key) != "":
+		return nil, fmt.Errorf("%w: sketch '%s' can't be written to upstream origin", ErrUpstreamWriteMode, key)
+	case op.Type == sketch.KindHLL && op.Op == "merge":
+		return s.mergeHLL(key, op.Keys)
+	}
+
+	s.snapshotMu.RLock()
+
+	shard := s.shards[getShardIndex(key)]
+	shard.mu.Lock()
+
+	item, exists := shard.items[key]
+	if exists && time.Now().UnixNano() > item.ExpiresAt {
+		exists = false
+	}
+	if !exists {
+		item = Item{ExpiresAt: expiresAt(op.TTL)}
+	}
+
+	sv, result, changed, err := applySketchOp(item.Value, exists, op)
+	if err != nil || !changed {
+		shard.mu.Unlock()
+		s.snapshotMu.RUnlock()
+		return result, err
+	}
+
+	item.Value = sv
+	item.Version = s.nextVersion()
+
+	if s.wal != nil {
+		patch := op
+		if err := s.wal.WriteEvent(WALEntry{Op: "sketch", Key: key, Exp: item.ExpiresAt, Ver: item.Version, Sketch: &patch}); err != nil {
+			s.log.Error("WAL Write Error: %v", err)
+		}
+	}
+	shard.items[key] = item
+	ev := s.setEvent(key, item)
+	shard.mu.Unlock()
+	s.snapshotMu.RUnlock()
+
+	s.log.Debug("SKETCH %s.%s key='%s'", op.Type, op.Op, key)
+	s.notify(ev)
+	return result, nil
+}
+
+// applySketchOp применяет изменяющую операцию. Существующий скетч меняется на месте,
+// отсутствующий создается с параметрами из op.
+func applySketchOp(cur any, exists bool, op SketchOp) (sv *SketchValue, result any, changed bool, err error) {
+	create := op.Op == "reserve" || op.Op == "init"
+	if exists {
+		if create {
+			return nil, nil, false, ErrSketchExist
+		}
+		var ok bool
+		if sv, ok = cur.(*SketchValue); !ok || sv.s.Kind() != op.Type {
+			return nil, nil, false, ErrWrongType
+		}
+	} else {
+		s, err := newSketch(op)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		sv = &SketchValue{s: s}
+		changed = true
+	}
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	switch t := sv.s.(type) {
+	case *sketch.HLL:
+		if op.Op == "add" {
+			updated := false
+			for _, it := range op.Items {
+				updated = t.Add(it) || updated
+			}
+			return sv, updated, changed || updated, nil
+		}
+
+	case *sketch.Bloom:
+		switch op.Op {
+		case "reserve":
+			return sv, nil, true, nil
+		case "add":
+			added := make([]bool, len(op.Items))
+			for i, it := range op.Items {
+				added[i] = t.Add(it)
+				changed = changed || added[i]
+			}
+			return sv, added, changed, nil
+		}
+
+	case *sketch.CMS:
+		switch op.Op {
+		case "init":
+			return sv, nil, true, nil
+		case "incr":
+			by := op.By
+			if by == 0 {
+				by = 1
+			}
+			counts := make([]uint64, len(op.Items))
+			for i, it := range op.Items {
+				counts[i] = t.Incr(it, by)
+			}
+			return sv, counts, changed || len(op.Items) > 0, nil
+		}
+	}
+	return nil, nil, false, fmt.Errorf("%w: unknown op %q for %s", ErrSketchOp, op.Op, op.Type)
+}
+
+func newSketch(op SketchOp) (sketch.Sketch, error) {
+	switch op.Type {
+	case sketch.KindHLL:
+		if op.Op != "add" {
+			break
+		}
+		return sketch.NewHLL(), nil
+
+	case sketch.KindBloom:
+		if op.Op != "add" && op.Op != "reserve" {
+			break
+		}
+		capacity, rate := op.Capacity, op.ErrorRate
+		if capacity == 0 {
+			capacity = defaultBloomCapacity
+		}
+		if rate == 0 {
+			rate = defaultBloomErrorRate
+		}
+		b, err := sketch.NewBloom(capacity, rate)
+		if err != nil {
+			return nil, fmt.Errorf("%w: capacity must be positive and error_rate in (0, 1)", ErrSketchOp)
+		}
+		return b, nil
+
+	case sketch.KindCMS:
+		if op.Op != "incr" && op.Op != "init" {
+			break
+		}
+		width, depth, topK := op.Width, op.Depth, defaultCMSTopK
+		if width == 0 {
+			width = defaultCMSWidth
+		}
+		if depth == 0 {
+			depth = defaultCMSDepth
+		}
+		if op.TopK != nil {
+			topK = *op.TopK
+		}
+		c, err := sketch.NewCMS(width, depth, topK)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad width/depth/top_k", ErrSketchOp)
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q for %s", ErrSketchOp, op.Op, op.Type)
+}
+
+// peekSketch возвращает скетч ключа нужного типа (nil — ключа нет)
+func (s *Storage) peekSketch(key, kind string) (*SketchValue, error) {
+	item, ok := s.peek(key)
+	if !ok {
+		return nil, nil
+	}
+	sv, ok := item.Value.(*SketchValue)
+	if !ok || sv.s.Kind() != kind {
+		return nil, ErrWrongType
+	}
+	return sv, nil
+}
+
+func (s *Storage) readSketch(key string, op SketchOp) (any, error) {
+	if op.Type == sketch.KindHLL {
+		// Оценка объединения: сливаем копии, исходные ключи не трогаем
+		union := sketch.NewHLL()
+		for _, k := range append([]string{key}, op.Keys...) {
+			sv, err := s.peekSketch(k, sketch.KindHLL)
+			if err != nil {
+				return nil, err
+			}
+			if sv != nil {
+				sv.mu.RLock()
+				union.Merge(sv.s.(*sketch.HLL))
+				sv.mu.RUnlock()
+			}
+		}
+		return union.Count(), nil
+	}
+
+	sv, err := s.peekSketch(key, op.Type)
+	if err != nil {
+		return nil, err
+	}
+	if sv == nil {
+		return nil, ErrNotFound
+	}
+
+	sv.mu.RLock()
+	defer sv.mu.RUnlock()
+
+	switch t := sv.s.(type) {
+	case *sketch.Bloom:
+		if op.Op == "info" {
+			return t.Info(), nil
+		}
+		found := make([]bool, len(op.Items))
+		for i, it := range op.Items {
+			found[i] = t.Test(it)
+		}
+		return found, nil
+
+	case *sketch.CMS:
+		switch op.Op {
+		case "top":
+			return t.Top(), nil
+		case "info":
+			return map[string]uint64{"total": t.Total()}, nil
+		}
+		counts := make([]uint64, len(op.Items))
+		for i, it := range op.Items {
+			counts[i] = t.Query(it)
+		}
+		return counts, nil
+	}
+	return nil, ErrWrongType
+}
+
+// mergeHLL записывает в dest объединение dest и sources (как PFMERGE).
+// Идет через транзакцию: источники лежат в других шардах, а в WAL уходит итоговая структура.
+func (s *Storage) mergeHLL(dest string, sources []string) (any, error) {
+	var count uint64
+	err := s.withKeys(append([]string{dest}, sources...), func(view *txView) error {
+		merged := sketch.NewHLL()
+		exp := expiresAt(0)
+
+		for i, k := range append([]string{dest}, sources...) {
+			st := view.get(k)
+			if !st.exists {
+				continue
+			}
+			sv, ok := st.item.Value.(*SketchValue)
+			if !ok || sv.s.Kind() != sketch.KindHLL {
+				return ErrWrongType
+			}
+			if i == 0 {
+				exp = st.item.ExpiresAt // TTL приемника сохраняем
+			}
+			sv.mu.RLock()
+			merged.Merge(sv.s.(*sketch.HLL))
+			sv.mu.RUnlock()
+		}
+
+		count = merged.Count()
+		view.put(dest, Item{Value: &SketchValue{s: merged}, ExpiresAt: exp})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// replaySketch применяет операцию из WAL
+func (s *Storage) replaySketch(entry WALEntry) {
+	if entry.Sketch == nil {
+		return
+	}
+
+	shard := s.shards[getShardIndex(entry.Key)]
+	shard.mu.RLock()
+	item, exists := shard.items[entry.Key]
+	shard.mu.RUnlock()
+	// Как в Sketch: протухший скетч операция создает заново
+	if exists && time.Now().UnixNano() > item.ExpiresAt {
+		exists = false
+	}
+
+	sv, _, _, err := applySketchOp(item.Value, exists, *entry.Sketch)
+	if err != nil {
+		s.log.Error("WAL: failed to replay sketch op for '%s': %v", entry.Key, err)
+		return
+	}
+	s.restoreFromWAL(entry.Key, Item{Value: sv, ExpiresAt: entry.Exp, Version: entry.Ver})
+}
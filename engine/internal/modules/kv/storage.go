@@ -2,18 +2,24 @@ package kv
 
 import (
 	"encoding/json"
+	"errors"
+	"hash/fnv"
 	"net/http"
 	"nexus-engine/internal/pkg/logger"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Item — единица хранения
 type Item struct {
-	Value     any   `json:"value"`
-	ExpiresAt int64 `json:"expires_at"`
+	Value     any    `json:"value"`
+	ExpiresAt int64  `json:"expires_at"`
+	Version   uint64 `json:"version,omitempty"`  // Растет при каждой записи ключа (нужно для WATCH)
+	Upstream  bool   `json:"upstream,omitempty"` // Значение загружено из origin (только такие отдаются stale)
+	Struct    bool   `json:"struct,omitempty"`   // В снапшоте: значение — конверт скетча/стрима (см. reviveValue)
 }
 
 // Options — настройки, передаваемые извне (из флагов CLI)
@@ -22,18 +28,55 @@ type Options struct {
 	SaveInterval       time.Duration
 	CleanupInterval    time.Duration
 	UpstreamURL        string
+	UpstreamLoaders    []LoaderConfig // Лоадеры по префиксам (UpstreamURL добавляется как лоадер с пустым префиксом)
 	UpstreamEnabled    bool
 	DefaultUpstreamTTL int
+	UpstreamMaxFlight  int           // Максимум одновременных запросов в origin
+	UpstreamTimeout    time.Duration // Таймаут одного запроса в origin
+	UpstreamStaleTTL   time.Duration // Сколько отдаем протухшее значение, пока идет фоновое обновление
+	UpstreamMissingTTL time.Duration // Сколько помним 404 от origin (negative caching)
+	UpstreamWriteMode  string        // "", "through" или "behind"
+	WriteBatchSize     int           // Размер батча для write-behind
+	WriteInterval      time.Duration // Как часто сбрасывать очередь write-behind
+	ScriptMaxSteps     int           // Лимит шагов одного запуска скрипта
+	HistoryRules       []HistoryRule // Для каких префиксов хранить прошлые версии
 	Logger             *logger.Logger
 }
 
+var (
+	// ErrNotFound — ключа (или пути в документе) нет
+	ErrNotFound = errors.New("not found")
+	// ErrUpstreamWrite — origin не принял запись (write-through)
+	ErrUpstreamWrite = errors.New("upstream write failed")
+	// ErrUpstreamRejected — origin отверг запись окончательно (4xx): повтор не поможет
+	ErrUpstreamRejected = errors.New("rejected by upstream origin")
+	// ErrUpstreamWriteMode — операция не поддерживается для ключей с таким режимом записи в origin
+	ErrUpstreamWriteMode = errors.New("operation is not supported in upstream write mode of the key")
+)
+
+// throughStripes — на сколько локов делятся ключи в режиме write-through (см. lockThrough)
+const throughStripes = 256
+
 // Storage — структура модуля
 type Storage struct {
 	shards     [ShardCount]*Shard
 	wal        *WAL
+	upstream   *Upstream
+	outbox     *Outbox
+	scripts    *ScriptCache
+	indexes    *IndexManager
+	history    *History
+	locks      *LockManager
 	opts       Options
 	log        *logger.Logger
 	snapshotMu sync.RWMutex
+	version    atomic.Uint64 // Последняя выданная версия
+	throughMu  [throughStripes]sync.Mutex
+
+	observersMu sync.RWMutex
+	observers   []func(KeyEvent)
+
+	streamWait streamWaiters // Блокирующие чтения стримов
 }
 
 // LoadSnapshot загружает "базовое" состояние из JSON
@@ -58,7 +101,7 @@ func (s *Storage) LoadSnapshot(path string) error {
 	for k, v := range flatMap {
 		// Восстанавливаем только живые ключи
 		if v.ExpiresAt == 0 || time.Now().UnixNano() < v.ExpiresAt {
-			s.restoreFromWAL(k, v.Value, v.ExpiresAt)
+			s.restoreFromWAL(k, v)
 			count++
 		}
 	}
@@ -82,6 +125,7 @@ func (s *Storage) CreateSnapshot() error {
 		shard.mu.RLock()
 		for k, v := range shard.items {
 			if v.ExpiresAt > now {
+				v.Value, v.Struct = frozenValue(v.Value), isStruct(v.Value)
 				allItems[k] = v
 			}
 		}
@@ -120,14 +164,22 @@ func (s *Storage) CreateSnapshot() error {
 	}
 
 	s.log.Info("📸 Snapshot created successfully (%d items)", len(allItems))
+
+	// Заодно выкидываем из файла истории версии, вышедшие за лимиты
+	if s.history != nil {
+		if err := s.history.Compact(); err != nil {
+			s.log.Error("History compaction failed: %v", err)
+		}
+	}
 	return nil
 }
 
 // New создает новый инстанс KV
 func New(opts Options) (*Storage, error) {
 	s := &Storage{
-		opts: opts,
-		log:  opts.Logger,
+		opts:    opts,
+		log:     opts.Logger,
+		scripts: NewScriptCache(),
 	}
 
 	for i := 0; i < ShardCount; i++ {
@@ -164,49 +216,209 @@ func New(opts Options) (*Storage, error) {
 	s.wal = wal
 	s.log.Info("💾 Persistence enabled: %s", walPath)
 
+	// 5. Вторичные индексы строятся по уже загруженным данным
+	if s.indexes, err = newIndexManager(s, opts.PersistPath+".indexes"); err != nil {
+		return nil, err
+	}
+
+	s.locks = NewLockManager(s)
+
+	// 6. История версий (только для префиксов из правил)
+	if len(opts.HistoryRules) > 0 {
+		if s.history, err = openHistory(s, opts.PersistPath+".history", opts.HistoryRules); err != nil {
+			return nil, err
+		}
+		s.log.Info("🕰 Key history enabled for %d prefixes", len(opts.HistoryRules))
+	}
+
+	if opts.UpstreamEnabled {
+		if err := s.initUpstream(); err != nil {
+			return nil, err
+		}
+	}
+
 	s.startWorkers()
 	return s, nil
 }
 
-// restoreFromWAL — спец. метод для восстановления (принимает уже готовый timestamp)
-func (s *Storage) restoreFromWAL(key string, value any, expiresAt int64) {
+// restoreFromWAL — спец. метод для восстановления (принимает уже готовый timestamp и версию)
+func (s *Storage) restoreFromWAL(key string, item Item) {
 	// Если ключ уже протух пока сервер лежал — не загружаем его в память
-	if expiresAt > 0 && time.Now().UnixNano() > expiresAt {
+	if item.ExpiresAt > 0 && time.Now().UnixNano() > item.ExpiresAt {
 		return
 	}
 
+	if item.Struct {
+		item.Value, item.Struct = reviveValue(item.Value), false
+	}
+
+	// Старые снапшоты без версий: выдаем новую. Иначе двигаем счетчик, чтобы не выдать ту же версию снова.
+	if item.Version == 0 {
+		item.Version = s.nextVersion()
+	} else {
+		s.observeVersion(item.Version)
+	}
+
 	idx := getShardIndex(key)
 	shard := s.shards[idx]
 
 	shard.mu.Lock()
-	shard.items[key] = Item{Value: value, ExpiresAt: expiresAt}
+	shard.items[key] = item
 	shard.mu.Unlock()
 }
 
-// Set — Публичный метод: пишет в WAL -> потом в RAM
-func (s *Storage) Set(key string, value any, ttlSeconds int) {
+// nextVersion выдает новую версию для записи
+func (s *Storage) nextVersion() uint64 {
+	return s.version.Add(1)
+}
+
+// observeVersion поднимает счетчик версий до v (при восстановлении)
+func (s *Storage) observeVersion(v uint64) {
+	for {
+		cur := s.version.Load()
+		if v <= cur || s.version.CompareAndSwap(cur, v) {
+			return
+		}
+	}
+}
+
+// expiresAt переводит TTL в секундах в абсолютное время (0 — "вечно")
+func expiresAt(ttlSeconds int) int64 {
+	if ttlSeconds > 0 {
+		return time.Now().Add(time.Duration(ttlSeconds) * time.Second).UnixNano()
+	}
+	return time.Now().Add(time.Hour * 24 * 365 * 100).UnixNano()
+}
+
+// Set — Публичный метод: (origin) -> WAL -> RAM
+func (s *Storage) Set(key string, value any, ttlSeconds int) error {
+	if err := checkUserWrite(key); err != nil {
+		return err
+	}
+
+	// Write-through: держим ключ на время записи в origin и в память,
+	// иначе конкурентные Set могут прийти в origin как A,B, а в память — как B,A
+	unlock := s.lockThrough(key)
+	defer unlock()
+
+	if err := s.writeThrough(WALEntry{Op: "set", Key: key, Value: value}); err != nil {
+		return err
+	}
+	return s.set(key, Item{Value: value, ExpiresAt: expiresAt(ttlSeconds)})
+}
+
+// set пишет в WAL -> потом в RAM (в origin — только очередь write-behind, и только не для значений из origin)
+func (s *Storage) set(key string, item Item) error {
+	_, err := s.setIf(key, nil, item)
+	return err
+}
+
+// keyVersion — версия ключа в памяти, в том числе протухшего (0 — ключа нет)
+func (s *Storage) keyVersion(key string) uint64 {
+	shard := s.shards[getShardIndex(key)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.items[key].Version
+}
+
+// setIf — set, только если версия ключа все еще *ver (см. keyVersion; nil — без условия).
+// false — ключ успели изменить, ничего не записано.
+func (s *Storage) setIf(key string, ver *uint64, item Item) (bool, error) {
 	// Блокируем Снапшоттинг, но разрешаем другим Set работать параллельно
 	s.snapshotMu.RLock()
-	defer s.snapshotMu.RUnlock()
 
-	var expires int64
-	if ttlSeconds > 0 {
-		expires = time.Now().Add(time.Duration(ttlSeconds) * time.Second).UnixNano()
-	} else {
-		expires = time.Now().Add(time.Hour * 24 * 365 * 100).UnixNano()
+	value := ownValue(item.Value)
+	item.Value = value
+
+	// Держим лок шарда на WAL + RAM, чтобы порядок версий совпадал с порядком в журнале
+	idx := getShardIndex(key)
+	shard := s.shards[idx]
+	shard.mu.Lock()
+
+	if ver != nil && shard.items[key].Version != *ver {
+		shard.mu.Unlock()
+		s.snapshotMu.RUnlock()
+		return false, nil
 	}
 
+	// Очередь write-behind — под тем же локом: origin получит записи в порядке версий
+	if !item.Upstream {
+		if err := s.writeBehind(WALEntry{Op: "set", Key: key, Value: value}); err != nil {
+			shard.mu.Unlock()
+			s.snapshotMu.RUnlock()
+			return false, err
+		}
+	}
+	item.Version = s.nextVersion()
+
 	// 1. Пишем в WAL (атомарно внутри WAL.WriteEvent)
 	if s.wal != nil {
 		// Ошибки WAL логируем, но не роняем запрос (лучше потерять персистенцию, чем доступность)
-		if err := s.wal.WriteEvent(WALEntry{Op: "set", Key: key, Value: value, Exp: expires}); err != nil {
+		if err := s.wal.WriteEvent(WALEntry{Op: "set", Key: key, Value: value, Exp: item.ExpiresAt, Ver: item.Version, Upstream: item.Upstream, Struct: isStruct(value)}); err != nil {
 			s.log.Error("WAL Write Error: %v", err)
 		}
 	}
 
 	// 2. Пишем в RAM
-	s.restoreFromWAL(key, value, expires)
+	shard.items[key] = item
+	ev := s.setEvent(key, item)
+	shard.mu.Unlock()
+	s.snapshotMu.RUnlock()
+
+	if s.upstream != nil {
+		s.upstream.forgetMissing(key)
+	}
 	s.log.Debug("SET key='%s'", key)
+	s.notify(ev)
+	return true, nil
+}
+
+// Delete — удаляет ключ: (origin) -> WAL -> RAM
+func (s *Storage) Delete(key string) error {
+	if err := checkUserWrite(key); err != nil {
+		return err
+	}
+
+	unlock := s.lockThrough(key)
+	defer unlock()
+
+	if err := s.writeThrough(WALEntry{Op: "del", Key: key}); err != nil {
+		return err
+	}
+
+	s.snapshotMu.RLock()
+
+	idx := getShardIndex(key)
+	shard := s.shards[idx]
+	shard.mu.Lock()
+	if err := s.writeBehind(WALEntry{Op: "del", Key: key}); err != nil {
+		shard.mu.Unlock()
+		s.snapshotMu.RUnlock()
+		return err
+	}
+	if s.wal != nil {
+		if err := s.wal.WriteEvent(WALEntry{Op: "del", Key: key}); err != nil {
+			s.log.Error("WAL Write Error: %v", err)
+		}
+	}
+	delete(shard.items, key)
+	ev := KeyEvent{Op: "del", Key: key, Version: s.nextVersion(), At: time.Now().UnixNano()}
+	shard.mu.Unlock()
+	s.snapshotMu.RUnlock()
+
+	s.log.Debug("DEL key='%s'", key)
+	s.notify(ev)
+	return nil
+}
+
+// removeFromWAL удаляет ключ из памяти (используется при Replay)
+func (s *Storage) removeFromWAL(key string) {
+	idx := getShardIndex(key)
+	shard := s.shards[idx]
+
+	shard.mu.Lock()
+	delete(shard.items, key)
+	shard.mu.Unlock()
 }
 
 // Get — получить значение
@@ -218,56 +430,220 @@ func (s *Storage) Get(key string) (Item, bool) {
 	item, ok := shard.items[key]
 	shard.mu.RUnlock()
 
+	now := time.Now().UnixNano()
+
 	// Проверка TTL (ленивое удаление не делаем, просто скрываем)
 	if ok {
-		if time.Now().UnixNano() <= item.ExpiresAt {
+		if now <= item.ExpiresAt {
 			return item, true
 		}
+
+		// Stale-while-revalidate: отдаем старое значение и обновляем его в фоне.
+		// Только для значений из origin: локально записанное протухает как обычно.
+		if loader := s.loaderFor(key); item.Upstream && loader != nil && now <= item.ExpiresAt+int64(s.opts.UpstreamStaleTTL) {
+			s.log.Debug("🌐 Stale hit for '%s', revalidating in background", key)
+			s.upstream.Refresh(key, func() (Item, bool) {
+				return s.loadFromUpstream(loader, key)
+			})
+			return item, true
+		}
+
 		// Протухло — считаем что не нашли
 		ok = false
 	}
 
 	// === Upstream Logic ===
-	if !ok && s.opts.UpstreamEnabled && s.opts.UpstreamURL != "" {
+	if loader := s.loaderFor(key); !ok && loader != nil {
+		// Origin недавно сказал, что такого ключа нет — не дергаем его снова
+		if s.upstream.isMissing(key) || (s.outbox != nil && s.outbox.pendingDelete(key)) {
+			s.log.Debug("🌐 Negative hit for '%s'", key)
+			return Item{}, false
+		}
+
 		s.log.Info("🌐 Miss! Fetching '%s' from upstream...", key)
-		return s.fetchFromUpstream(key)
+		return s.fetchFromUpstream(loader, key)
 	}
 
 	return Item{}, false
 }
 
-// fetchFromUpstream делает HTTP запрос и сохраняет результат
-func (s *Storage) fetchFromUpstream(key string) (Item, bool) {
-	start := time.Now()
+// initUpstream собирает лоадеры и, если нужно, поднимает очередь write-behind
+func (s *Storage) initUpstream() error {
+	configs := s.opts.UpstreamLoaders
+	if s.opts.UpstreamURL != "" {
+		configs = append(configs, LoaderConfig{URL: s.opts.UpstreamURL})
+	}
+	if len(configs) == 0 {
+		return nil
+	}
 
-	// Используем client с таймаутом, чтобы не зависнуть
-	client := http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(s.opts.UpstreamURL + "/" + key)
+	upstream, err := NewUpstream(configs, s.opts)
+	if err != nil {
+		return err
+	}
+	s.upstream = upstream
 
-	if err != nil || resp.StatusCode != http.StatusOK {
+	for _, l := range upstream.loaders {
+		s.log.Info("🌐 Upstream loader '%s' -> %s (write: %s)", l.cfg.Prefix, l.cfg.URL, l.writeMode)
+	}
+
+	if upstream.hasWriteMode("behind") {
+		s.outbox, err = OpenOutbox(s.opts.PersistPath+".outbox", s.opts.WriteBatchSize, s.opts.WriteInterval, s.writeToUpstream, s.log)
+		if err != nil {
+			return err
+		}
+		s.log.Info("🌐 Upstream write-behind enabled (batch: %d, interval: %v)", s.opts.WriteBatchSize, s.opts.WriteInterval)
+	}
+	return nil
+}
+
+// loaderFor возвращает upstream лоадер для ключа (nil — ключ не кэшируется из origin)
+func (s *Storage) loaderFor(key string) *Loader {
+	// Служебные ключи локов живут только здесь
+	if s.upstream == nil || internalKey(key) {
+		return nil
+	}
+	return s.upstream.route(key)
+}
+
+// fetchFromUpstream идет в origin. Конкурентные промахи по одному ключу склеиваются в один запрос.
+func (s *Storage) fetchFromUpstream(loader *Loader, key string) (Item, bool) {
+	return s.upstream.Do(key, func() (Item, bool) {
+		return s.loadFromUpstream(loader, key)
+	})
+}
+
+// loadFromUpstream делает HTTP запрос и сохраняет результат
+func (s *Storage) loadFromUpstream(loader *Loader, key string) (Item, bool) {
+	start := time.Now()
+
+	ver := s.keyVersion(key)
+	value, ttl, status, err := loader.Fetch(key)
+	if err != nil {
 		s.log.Debug("Upstream error for '%s': %v", key, err)
 		return Item{}, false
 	}
-	defer resp.Body.Close()
 
-	var remoteValue any
-	if err := json.NewDecoder(resp.Body).Decode(&remoteValue); err != nil {
+	if status == http.StatusNotFound {
+		// Ключа нет в origin: запоминаем это и выкидываем stale-копию, если была
+		s.upstream.markMissing(key, s.opts.UpstreamMissingTTL)
+		s.dropExpired(key)
+		s.log.Debug("Upstream: '%s' not found", key)
 		return Item{}, false
 	}
 
-	// Сохраняем (это запишет и в WAL, и в память)
-	// Используем DefaultUpstreamTTL из настроек
-	s.Set(key, remoteValue, s.opts.DefaultUpstreamTTL)
+	if status != http.StatusOK {
+		s.log.Debug("Upstream error for '%s': status %d", key, status)
+		return Item{}, false
+	}
 
-	s.log.Debug("Upstream success for '%s' in %v", key, time.Since(start))
+	// Origin запретил кэшировать — просто отдаем значение
+	if ttl < 0 {
+		s.log.Debug("Upstream success for '%s' in %v (not cached)", key, time.Since(start))
+		return Item{Value: value, ExpiresAt: time.Now().UnixNano()}, true
+	}
+
+	// Сохраняем (это запишет и в WAL, и в память, но не обратно в origin). Если ключ записали
+	// локально, пока шел запрос, ответ origin старее: его не кладем, отдаем то, что в памяти.
+	if ok, _ := s.setIf(key, &ver, Item{Value: value, ExpiresAt: expiresAt(ttl), Upstream: true}); !ok {
+		s.log.Debug("Upstream value for '%s' is outdated by a local write", key)
+	}
 
-	// Возвращаем то, что только что сохранили (немного неоптимально читать снова, но надежно)
-	// Либо можно сконструировать Item вручную, зная expiration
-	return s.Get(key)
+	s.log.Debug("Upstream success for '%s' in %v (ttl %ds)", key, time.Since(start), ttl)
+
+	// Читаем из шарда, а не через Get — иначе при TTL=0/протухании снова уйдем в upstream
+	idx := getShardIndex(key)
+	shard := s.shards[idx]
+	shard.mu.RLock()
+	item, ok := shard.items[key]
+	shard.mu.RUnlock()
+	return item, ok
+}
+
+// writeMode — режим записи в origin для ключа ("" — ключ в origin не пишется)
+func (s *Storage) writeMode(key string) string {
+	if loader := s.loaderFor(key); loader != nil && loader.writeMode != "none" {
+		return loader.writeMode
+	}
+	return ""
+}
+
+// writeThrough синхронно пишет изменение в origin, если ключ в режиме write-through.
+// Вызывается под lockThrough ключа, до записи в память.
+func (s *Storage) writeThrough(entry WALEntry) error {
+	if s.writeMode(entry.Key) == "through" {
+		return s.loaderFor(entry.Key).Write(entry)
+	}
+	return nil
+}
+
+// writeBehind ставит изменение в очередь, если ключ в режиме write-behind.
+// Вызывается под локом шарда, чтобы порядок в очереди совпадал с порядком версий и WAL.
+func (s *Storage) writeBehind(entry WALEntry) error {
+	if s.writeMode(entry.Key) == "behind" {
+		return s.outbox.Enqueue(entry)
+	}
+	return nil
+}
+
+// lockThrough упорядочивает записи ключа в режиме write-through: пока одна идет в origin,
+// следующая ждет. Для остальных ключей ничего не держит.
+func (s *Storage) lockThrough(key string) func() {
+	if s.writeMode(key) != "through" {
+		return func() {}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &s.throughMu[h.Sum32()%throughStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// writeToUpstream синхронно пишет изменение в origin (используется очередью write-behind)
+func (s *Storage) writeToUpstream(entry WALEntry) error {
+	loader := s.loaderFor(entry.Key)
+	if loader == nil {
+		// Конфиг поменялся между рестартами — писать больше некуда
+		s.log.Error("Write-behind: no upstream loader for '%s', dropping write", entry.Key)
+		return nil
+	}
+	return loader.Write(entry)
+}
+
+// dropExpired удаляет ключ из памяти, только если он уже протух (stale-копия)
+func (s *Storage) dropExpired(key string) {
+	idx := getShardIndex(key)
+	shard := s.shards[idx]
+
+	shard.mu.Lock()
+	item, ok := shard.items[key]
+	expired := ok && time.Now().UnixNano() > item.ExpiresAt
+	if expired {
+		delete(shard.items, key)
+	}
+	shard.mu.Unlock()
+
+	if expired {
+		s.notify(KeyEvent{Op: "expired", Key: key, Version: item.Version, At: item.ExpiresAt})
+	}
 }
 
 // Close закрывает файл журнала
 func (s *Storage) Close() error {
+	if s.outbox != nil {
+		if err := s.outbox.Close(); err != nil {
+			s.log.Error("Failed to close write-behind journal: %v", err)
+		}
+	}
+	if s.upstream != nil {
+		s.upstream.Close()
+	}
+	if s.history != nil {
+		if err := s.history.Close(); err != nil {
+			s.log.Error("Failed to close history file: %v", err)
+		}
+	}
 	if s.wal != nil {
 		return s.wal.Close()
 	}
@@ -0,0 +1,330 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrStreamOp      = errors.New("stream operation failed")
+	ErrStreamID      = errors.New("bad stream id")
+	ErrNoGroup       = errors.New("consumer group not found")
+	ErrGroupExists   = errors.New("consumer group already exists")
+	ErrIDNotIncrease = errors.New("id must be greater than the last stream id")
+)
+
+// Сколько записей отдаем за раз, если count не задан
+const defaultStreamCount = 100
+
+// StreamID — время в миллисекундах + порядковый номер внутри миллисекунды ("1700000000000-0")
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(o StreamID) bool {
+	return id.Ms < o.Ms || (id.Ms == o.Ms && id.Seq < o.Seq)
+}
+
+// ParseStreamID разбирает "ms-seq" или "ms". Для "ms" seq берется из missingSeq
+// (0 для начала диапазона, MaxUint64 для конца).
+func ParseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("%w: %q", ErrStreamID, s)
+	}
+	seq := missingSeq
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, fmt.Errorf("%w: %q", ErrStreamID, s)
+		}
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// StreamEntry — запись стрима
+type StreamEntry struct {
+	ID    string `json:"id"`
+	Value any    `json:"value"`
+}
+
+type streamEntry struct {
+	id    StreamID
+	value any
+}
+
+// PendingEntry — выданная консьюмеру, но еще не подтвержденная запись
+type PendingEntry struct {
+	ID          string `json:"id"`
+	Consumer    string `json:"consumer"`
+	DeliveredAt int64  `json:"delivered_at"` // Unix ms последней выдачи
+	Deliveries  int    `json:"deliveries"`
+	IdleMs      int64  `json:"idle_ms"` // Только в ответах
+}
+
+type pending struct {
+	consumer    string
+	deliveredAt int64
+	deliveries  int
+}
+
+type consumerGroup struct {
+	lastDelivered StreamID
+	pending       map[StreamID]*pending // PEL
+}
+
+// Stream — append-only лог записей с группами консьюмеров.
+// Как и скетчи, меняется на месте под своим локом.
+type Stream struct {
+	mu      sync.RWMutex
+	entries []streamEntry // Отсортированы по id
+	lastID  StreamID
+	maxLen  int // 0 — без ограничения
+	groups  map[string]*consumerGroup
+}
+
+func newStream() *Stream {
+	return &Stream{groups: make(map[string]*consumerGroup)}
+}
+
+// search — индекс первой записи с id >= target
+func (st *Stream) search(target StreamID) int {
+	return sort.Search(len(st.entries), func(i int) bool { return !st.entries[i].id.Less(target) })
+}
+
+func (st *Stream) lookup(id StreamID) (streamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// nextID — новый ID не меньше текущего времени и строго больше последнего
+func (st *Stream) nextID(nowMs int64) StreamID {
+	ms := uint64(max(nowMs, 0))
+	if ms > st.lastID.Ms {
+		return StreamID{Ms: ms}
+	}
+	return StreamID{Ms: st.lastID.Ms, Seq: st.lastID.Seq + 1}
+}
+
+func (st *Stream) add(id StreamID, value any, maxLen int) {
+	st.entries = append(st.entries, streamEntry{id: id, value: value})
+	st.lastID = id
+	if maxLen > 0 {
+		st.maxLen = maxLen
+	}
+	st.trim(st.maxLen)
+}
+
+// trim оставляет последние maxLen записей. Записи из PEL остаются там (при выдаче их value будет null).
+func (st *Stream) trim(maxLen int) int {
+	if maxLen <= 0 || len(st.entries) <= maxLen {
+		return 0
+	}
+	drop := len(st.entries) - maxLen
+	st.entries = st.entries[drop:]
+	// Срез головы не освобождает память массива: периодически переносим в новый
+	if cap(st.entries) > 2*len(st.entries)+64 {
+		st.entries = append([]streamEntry(nil), st.entries...)
+	}
+	return drop
+}
+
+// after — до count записей с id > from
+func (st *Stream) after(from StreamID, count int) []StreamEntry {
+	i := st.search(from)
+	if i < len(st.entries) && st.entries[i].id == from {
+		i++
+	}
+	return st.collect(i, StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}, count)
+}
+
+// idsAfter — до count ID записей с id > from
+func (st *Stream) idsAfter(from StreamID, count int) []StreamID {
+	i := st.search(from)
+	if i < len(st.entries) && st.entries[i].id == from {
+		i++
+	}
+	var ids []StreamID
+	for ; i < len(st.entries) && len(ids) < count; i++ {
+		ids = append(ids, st.entries[i].id)
+	}
+	return ids
+}
+
+// collect — записи начиная с индекса i, пока id <= end
+func (st *Stream) collect(i int, end StreamID, count int) []StreamEntry {
+	out := []StreamEntry{}
+	for ; i < len(st.entries) && len(out) < count; i++ {
+		e := st.entries[i]
+		if end.Less(e.id) {
+			break
+		}
+		out = append(out, StreamEntry{ID: e.id.String(), Value: e.value})
+	}
+	return out
+}
+
+// deliver выдает записи консьюмеру группы (чтение группой и claim).
+// Записи, которых уже нет в стриме (обрезаны), выкидываются из PEL.
+func (g *consumerGroup) deliver(st *Stream, ids []StreamID, consumer string, at int64) []StreamEntry {
+	out := make([]StreamEntry, 0, len(ids))
+	for _, id := range ids {
+		e, ok := st.lookup(id)
+		if !ok {
+			delete(g.pending, id)
+			continue
+		}
+
+		p := g.pending[id]
+		if p == nil {
+			p = &pending{}
+			g.pending[id] = p
+		}
+		p.consumer, p.deliveredAt = consumer, at
+		p.deliveries++
+
+		if g.lastDelivered.Less(id) {
+			g.lastDelivered = id
+		}
+		out = append(out, StreamEntry{ID: id.String(), Value: e.value})
+	}
+	return out
+}
+
+func (g *consumerGroup) pendingList(consumer string, now int64, count int) []PendingEntry {
+	ids := make([]StreamID, 0, len(g.pending))
+	for id, p := range g.pending {
+		if consumer == "" || p.consumer == consumer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	if len(ids) > count {
+		ids = ids[:count]
+	}
+
+	out := make([]PendingEntry, len(ids))
+	for i, id := range ids {
+		p := g.pending[id]
+		out[i] = PendingEntry{
+			ID:          id.String(),
+			Consumer:    p.consumer,
+			DeliveredAt: p.deliveredAt,
+			Deliveries:  p.deliveries,
+			IdleMs:      max(0, now-p.deliveredAt),
+		}
+	}
+	return out
+}
+
+func (st *Stream) cloneValue() any {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	cp := &Stream{
+		entries: append([]streamEntry(nil), st.entries...),
+		lastID:  st.lastID,
+		maxLen:  st.maxLen,
+		groups:  make(map[string]*consumerGroup, len(st.groups)),
+	}
+	for name, g := range st.groups {
+		cg := &consumerGroup{lastDelivered: g.lastDelivered, pending: make(map[StreamID]*pending, len(g.pending))}
+		for id, p := range g.pending {
+			pp := *p
+			cg.pending[id] = &pp
+		}
+		cp.groups[name] = cg
+	}
+	return cp
+}
+
+// streamJSON — вид стрима в снапшоте и WAL: {"$stream": {...}}
+type streamJSON struct {
+	Entries []StreamEntry        `json:"entries"`
+	LastID  string               `json:"last_id"`
+	MaxLen  int                  `json:"max_len,omitempty"`
+	Groups  map[string]groupJSON `json:"groups,omitempty"`
+}
+
+type groupJSON struct {
+	LastDelivered string         `json:"last_delivered"`
+	Pending       []PendingEntry `json:"pending,omitempty"`
+}
+
+func (st *Stream) MarshalJSON() ([]byte, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	out := streamJSON{
+		Entries: st.collect(0, StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}, len(st.entries)),
+		LastID:  st.lastID.String(),
+		MaxLen:  st.maxLen,
+		Groups:  make(map[string]groupJSON, len(st.groups)),
+	}
+	for name, g := range st.groups {
+		gj := groupJSON{LastDelivered: g.lastDelivered.String()}
+		for id, p := range g.pending {
+			gj.Pending = append(gj.Pending, PendingEntry{ID: id.String(), Consumer: p.consumer, DeliveredAt: p.deliveredAt, Deliveries: p.deliveries})
+		}
+		out.Groups[name] = gj
+	}
+	return json.Marshal(map[string]streamJSON{"$stream": out})
+}
+
+func decodeStream(m map[string]any) (*Stream, bool) {
+	raw, ok := m["$stream"]
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	// Снапшот уже разобран в map[string]any — проще прогнать через JSON еще раз
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false
+	}
+	var sj streamJSON
+	if err := json.Unmarshal(data, &sj); err != nil {
+		return nil, false
+	}
+
+	st := newStream()
+	st.maxLen = sj.MaxLen
+	if st.lastID, err = ParseStreamID(sj.LastID, 0); err != nil {
+		return nil, false
+	}
+	for _, e := range sj.Entries {
+		id, err := ParseStreamID(e.ID, 0)
+		if err != nil {
+			return nil, false
+		}
+		st.entries = append(st.entries, streamEntry{id: id, value: e.Value})
+	}
+	for name, gj := range sj.Groups {
+		g := &consumerGroup{pending: make(map[StreamID]*pending, len(gj.Pending))}
+		if g.lastDelivered, err = ParseStreamID(gj.LastDelivered, 0); err != nil {
+			return nil, false
+		}
+		for _, p := range gj.Pending {
+			id, err := ParseStreamID(p.ID, 0)
+			if err != nil {
+				return nil, false
+			}
+			g.pending[id] = &pending{consumer: p.Consumer, deliveredAt: p.DeliveredAt, deliveries: p.Deliveries}
+		}
+		st.groups[name] = g
+	}
+	return st, true
+}
@@ -0,0 +1,503 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StreamOp — операция над стримом. В WAL пишется нормализованная запись:
+// add с уже выданным ID, чтение группой и claim — как "deliver" с конкретными ID и временем.
+type StreamOp struct {
+	Op string `json:"op"` // add, trim, range, read, len, info, group.create, group.destroy, group.read, ack, pending, claim
+
+	ID     string `json:"id,omitempty"`     // add: явный ID (по умолчанию авто); group.create: с какого ID выдавать ("$" — только новые, "0" — все); group.read: ">" — новые, иначе свои pending после ID
+	Value  any    `json:"value,omitempty"`  // add
+	MaxLen int    `json:"maxlen,omitempty"` // add: ограничить длину (запоминается стримом); trim
+
+	Start string `json:"start,omitempty"` // range: "-" или ID
+	End   string `json:"end,omitempty"`   // range: "+" или ID
+	After string `json:"after,omitempty"` // read: записи после ID ("$" — только новые)
+	Count int    `json:"count,omitempty"`
+
+	Group     string   `json:"group,omitempty"`
+	Consumer  string   `json:"consumer,omitempty"`
+	IDs       []string `json:"ids,omitempty"`         // ack, claim
+	MinIdleMs int64    `json:"min_idle_ms,omitempty"` // claim: забирать записи, которые висят у консьюмера дольше
+
+	BlockMs int64 `json:"block_ms,omitempty"` // read, group.read: ждать новых записей (long-poll)
+	At      int64 `json:"at,omitempty"`       // deliver: время выдачи (unix ms)
+	TTL     int   `json:"ttl,omitempty"`      // Только при создании ключа
+}
+
+func (op *StreamOp) readOnly() bool {
+	switch op.Op {
+	case "range", "read", "len", "info", "pending":
+		return true
+	case "group.read":
+		return op.ID != "" && op.ID != ">"
+	}
+	return false
+}
+
+// streamWaiters — ожидающие новых записей: канал на ключ, закрывается при каждом add
+type streamWaiters struct {
+	mu    sync.Mutex
+	chans map[string]*streamWait
+}
+
+// streamWait — канал пробуждения ключа и сколько ожидающих его держат
+type streamWait struct {
+	ch chan struct{}
+	n  int
+}
+
+// channel выдает канал пробуждения ключа. release вызывается, когда ожидание закончилось:
+// последний ушедший по таймауту убирает запись, иначе ключи без записей копились бы вечно.
+func (w *streamWaiters) channel(key string) (wake <-chan struct{}, release func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.chans == nil {
+		w.chans = make(map[string]*streamWait)
+	}
+	sw, ok := w.chans[key]
+	if !ok {
+		sw = &streamWait{ch: make(chan struct{})}
+		w.chans[key] = sw
+	}
+	sw.n++
+	return sw.ch, func() { w.release(key, sw) }
+}
+
+func (w *streamWaiters) release(key string, sw *streamWait) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sw.n--
+	// Запись могли уже убрать (wake) и завести новую — трогаем только свою
+	if sw.n == 0 && w.chans[key] == sw {
+		delete(w.chans, key)
+	}
+}
+
+func (w *streamWaiters) wake(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if sw, ok := w.chans[key]; ok {
+		close(sw.ch)
+		delete(w.chans, key)
+	}
+}
+
+// Stream выполняет операцию над стримом ключа.
+// read и group.read с block_ms ждут новых записей, пока не истечет время или ctx.
+// Стрим origin не передать, поэтому ключи с записью в origin (write-through/behind) менять нельзя.
+func (s *Storage) Stream(ctx context.Context, key string, op StreamOp) (any, error) {
+	if op.Op == "deliver" {
+		return nil, fmt.Errorf("%w: unknown op %q", ErrStreamOp, op.Op)
+	}
+	if !op.readOnly() && internalKey(key) {
+		return nil, checkUserWrite(key)
+	}
+	if !op.readOnly() && s.writeMode(key) != "" {
+		return nil, fmt.Errorf("%w: stream '%s' can't be written to upstream origin", ErrUpstreamWriteMode, key)
+	}
+	if op.Count <= 0 {
+		op.Count = defaultStreamCount
+	}
+
+	// "$" фиксируем один раз, иначе при повторных попытках пропустим записи, пришедшие между ними
+	if op.Op == "read" && (op.After == "$" || op.After == "") {
+		var last StreamID
+		if item, ok := s.peek(key); ok && op.After == "$" {
+			if st, ok := item.Value.(*Stream); ok {
+				st.mu.RLock()
+				last = st.lastID
+				st.mu.RUnlock()
+			}
+		}
+		op.After = last.String()
+	}
+
+	blocking := op.BlockMs > 0 && (op.Op == "read" || (op.Op == "group.read" && !op.readOnly()))
+	if !blocking {
+		return s.streamOnce(key, op)
+	}
+
+	timer := time.NewTimer(time.Duration(op.BlockMs) * time.Millisecond)
+	defer timer.Stop()
+
+	for {
+		res, done, err := s.streamAttempt(ctx, key, op, timer.C)
+		if done || err != nil {
+			return res, err
+		}
+	}
+}
+
+// streamAttempt — одна попытка блокирующего чтения: done=false — проснулись по add, пробуем снова
+func (s *Storage) streamAttempt(ctx context.Context, key string, op StreamOp, deadline <-chan time.Time) (any, bool, error) {
+	// Канал берем до попытки: add между попыткой и ожиданием закроет именно его
+	wake, release := s.streamWait.channel(key)
+	defer release()
+
+	res, err := s.streamOnce(key, op)
+	if err != nil {
+		return nil, true, err
+	}
+	if entries, ok := res.([]StreamEntry); !ok || len(entries) > 0 {
+		return res, true, nil
+	}
+
+	select {
+	case <-wake:
+		return nil, false, nil
+	case <-deadline:
+		return []StreamEntry{}, true, nil
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	}
+}
+
+func (s *Storage) streamOnce(key string, op StreamOp) (any, error) {
+	if op.readOnly() {
+		return s.readStream(key, op)
+	}
+
+	s.snapshotMu.RLock()
+
+	shard := s.shards[getShardIndex(key)]
+	shard.mu.Lock()
+
+	item, exists := shard.items[key]
+	if exists && time.Now().UnixNano() > item.ExpiresAt {
+		exists = false
+	}
+
+	var st *Stream
+	switch {
+	case exists:
+		var ok bool
+		if st, ok = item.Value.(*Stream); !ok {
+			shard.mu.Unlock()
+			s.snapshotMu.RUnlock()
+			return nil, ErrWrongType
+		}
+	case op.Op == "add" || op.Op == "group.create":
+		st = newStream()
+		item = Item{ExpiresAt: expiresAt(op.TTL)}
+	default:
+		shard.mu.Unlock()
+		s.snapshotMu.RUnlock()
+		return nil, ErrNotFound
+	}
+
+	st.mu.Lock()
+	result, record, err := st.apply(op, time.Now().UnixMilli())
+	st.mu.Unlock()
+	if err != nil || record == nil {
+		shard.mu.Unlock()
+		s.snapshotMu.RUnlock()
+		return result, err
+	}
+
+	item.Value = st
+	item.Version = s.nextVersion()
+
+	if s.wal != nil {
+		if err := s.wal.WriteEvent(WALEntry{Op: "stream", Key: key, Exp: item.ExpiresAt, Ver: item.Version, Stream: record}); err != nil {
+			s.log.Error("WAL Write Error: %v", err)
+		}
+	}
+	shard.items[key] = item
+	ev := s.setEvent(key, item)
+	shard.mu.Unlock()
+	s.snapshotMu.RUnlock()
+
+	s.log.Debug("STREAM %s key='%s'", op.Op, key)
+	s.notify(ev)
+	if record.Op == "add" {
+		s.streamWait.wake(key)
+	}
+	return result, nil
+}
+
+// apply выполняет изменяющую операцию (под st.mu.Lock). record — что записать в WAL (nil — ничего не изменилось).
+func (st *Stream) apply(op StreamOp, nowMs int64) (result any, record *StreamOp, err error) {
+	switch op.Op {
+	case "add":
+		if op.Value == nil {
+			return nil, nil, fmt.Errorf("%w: missing value", ErrStreamOp)
+		}
+		id := st.nextID(nowMs)
+		if op.ID != "" && op.ID != "*" {
+			if id, err = ParseStreamID(op.ID, 0); err != nil {
+				return nil, nil, err
+			}
+			if !st.lastID.Less(id) {
+				return nil, nil, ErrIDNotIncrease
+			}
+		}
+		st.add(id, op.Value, op.MaxLen)
+		return id.String(), &StreamOp{Op: "add", ID: id.String(), Value: op.Value, MaxLen: op.MaxLen}, nil
+
+	case "trim":
+		if op.MaxLen <= 0 {
+			return nil, nil, fmt.Errorf("%w: maxlen must be positive", ErrStreamOp)
+		}
+		n := st.trim(op.MaxLen)
+		if n == 0 {
+			return 0, nil, nil
+		}
+		return n, &StreamOp{Op: "trim", MaxLen: op.MaxLen}, nil
+
+	case "group.create":
+		if op.Group == "" {
+			return nil, nil, fmt.Errorf("%w: missing group", ErrStreamOp)
+		}
+		if _, ok := st.groups[op.Group]; ok {
+			return nil, nil, ErrGroupExists
+		}
+		start := st.lastID
+		if op.ID != "" && op.ID != "$" {
+			if start, err = ParseStreamID(op.ID, 0); err != nil {
+				return nil, nil, err
+			}
+		}
+		st.groups[op.Group] = &consumerGroup{lastDelivered: start, pending: make(map[StreamID]*pending)}
+		return nil, &StreamOp{Op: "group.create", Group: op.Group, ID: start.String()}, nil
+
+	case "group.destroy":
+		if _, ok := st.groups[op.Group]; !ok {
+			return false, nil, nil
+		}
+		delete(st.groups, op.Group)
+		return true, &StreamOp{Op: "group.destroy", Group: op.Group}, nil
+	}
+
+	// Дальше — операции группы
+	g, ok := st.groups[op.Group]
+	if !ok {
+		return nil, nil, ErrNoGroup
+	}
+	ids, err := parseStreamIDs(op.IDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch op.Op {
+	case "group.read":
+		if op.Consumer == "" {
+			return nil, nil, fmt.Errorf("%w: missing consumer", ErrStreamOp)
+		}
+		fresh := st.idsAfter(g.lastDelivered, op.Count)
+		if len(fresh) == 0 {
+			return []StreamEntry{}, nil, nil
+		}
+		entries := g.deliver(st, fresh, op.Consumer, nowMs)
+		return entries, &StreamOp{Op: "deliver", Group: op.Group, Consumer: op.Consumer, IDs: entryIDs(entries), At: nowMs}, nil
+
+	case "ack":
+		acked := 0
+		for _, id := range ids {
+			if _, ok := g.pending[id]; ok {
+				delete(g.pending, id)
+				acked++
+			}
+		}
+		if acked == 0 {
+			return 0, nil, nil
+		}
+		return acked, &StreamOp{Op: "ack", Group: op.Group, IDs: op.IDs}, nil
+
+	case "claim":
+		if op.Consumer == "" {
+			return nil, nil, fmt.Errorf("%w: missing consumer", ErrStreamOp)
+		}
+		// Без ids — забираем самые старые зависшие записи (как XAUTOCLAIM)
+		if len(ids) == 0 {
+			for id := range g.pending {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+		}
+
+		var claim []StreamID
+		var claimStr []string
+		for _, id := range ids {
+			p, ok := g.pending[id]
+			if !ok || nowMs-p.deliveredAt < op.MinIdleMs {
+				continue
+			}
+			claim = append(claim, id)
+			claimStr = append(claimStr, id.String())
+			if len(claim) >= op.Count {
+				break
+			}
+		}
+		if len(claim) == 0 {
+			return []StreamEntry{}, nil, nil
+		}
+		// В WAL пишем все забранные ID: обрезанные deliver выкинет из PEL и при повторе
+		return g.deliver(st, claim, op.Consumer, nowMs), &StreamOp{Op: "deliver", Group: op.Group, Consumer: op.Consumer, IDs: claimStr, At: nowMs}, nil
+
+	case "deliver":
+		return g.deliver(st, ids, op.Consumer, op.At), &op, nil
+	}
+	return nil, nil, fmt.Errorf("%w: unknown op %q", ErrStreamOp, op.Op)
+}
+
+func entryIDs(entries []StreamEntry) []string {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func parseStreamIDs(raw []string) ([]StreamID, error) {
+	ids := make([]StreamID, 0, len(raw))
+	for _, r := range raw {
+		id, err := ParseStreamID(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// StreamInfo — сводка по стриму
+type StreamInfo struct {
+	Length  int         `json:"length"`
+	FirstID string      `json:"first_id,omitempty"`
+	LastID  string      `json:"last_id"`
+	MaxLen  int         `json:"max_len,omitempty"`
+	Groups  []GroupInfo `json:"groups"`
+}
+
+type GroupInfo struct {
+	Name          string `json:"name"`
+	LastDelivered string `json:"last_delivered"`
+	Pending       int    `json:"pending"`
+	Consumers     int    `json:"consumers"`
+}
+
+func (s *Storage) readStream(key string, op StreamOp) (any, error) {
+	item, exists := s.peek(key)
+	if !exists {
+		// Пустой стрим и отсутствующий неотличимы для чтения
+		switch op.Op {
+		case "range", "read":
+			return []StreamEntry{}, nil
+		case "len":
+			return 0, nil
+		}
+		return nil, ErrNotFound
+	}
+	st, ok := item.Value.(*Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	switch op.Op {
+	case "range":
+		start, end := StreamID{}, StreamID{Ms: ^uint64(0), Seq: ^uint64(0)}
+		var err error
+		if op.Start != "" && op.Start != "-" {
+			if start, err = ParseStreamID(op.Start, 0); err != nil {
+				return nil, err
+			}
+		}
+		if op.End != "" && op.End != "+" {
+			if end, err = ParseStreamID(op.End, ^uint64(0)); err != nil {
+				return nil, err
+			}
+		}
+		return st.collect(st.search(start), end, op.Count), nil
+
+	case "read":
+		after, err := ParseStreamID(op.After, 0)
+		if err != nil {
+			return nil, err
+		}
+		return st.after(after, op.Count), nil
+
+	case "len":
+		return len(st.entries), nil
+
+	case "info":
+		info := StreamInfo{Length: len(st.entries), LastID: st.lastID.String(), MaxLen: st.maxLen, Groups: []GroupInfo{}}
+		if len(st.entries) > 0 {
+			info.FirstID = st.entries[0].id.String()
+		}
+		for name, g := range st.groups {
+			consumers := make(map[string]struct{})
+			for _, p := range g.pending {
+				consumers[p.consumer] = struct{}{}
+			}
+			info.Groups = append(info.Groups, GroupInfo{Name: name, LastDelivered: g.lastDelivered.String(), Pending: len(g.pending), Consumers: len(consumers)})
+		}
+		sort.Slice(info.Groups, func(i, j int) bool { return info.Groups[i].Name < info.Groups[j].Name })
+		return info, nil
+	}
+
+	g, ok := st.groups[op.Group]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	now := time.Now().UnixMilli()
+
+	switch op.Op {
+	case "pending":
+		return g.pendingList(op.Consumer, now, op.Count), nil
+
+	case "group.read":
+		// Повторное чтение своих неподтвержденных записей (после рестарта воркера)
+		after, err := ParseStreamID(op.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		out := []StreamEntry{}
+		for _, p := range g.pendingList(op.Consumer, now, len(g.pending)) {
+			id, _ := ParseStreamID(p.ID, 0)
+			if !after.Less(id) {
+				continue
+			}
+			e, _ := st.lookup(id)
+			out = append(out, StreamEntry{ID: p.ID, Value: e.value})
+			if len(out) >= op.Count {
+				break
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrStreamOp, op.Op)
+}
+
+// replayStream применяет операцию из WAL
+func (s *Storage) replayStream(entry WALEntry) {
+	if entry.Stream == nil {
+		return
+	}
+
+	shard := s.shards[getShardIndex(entry.Key)]
+	shard.mu.RLock()
+	item, exists := shard.items[entry.Key]
+	shard.mu.RUnlock()
+
+	st, ok := item.Value.(*Stream)
+	if !exists || !ok {
+		st = newStream()
+	}
+	if _, _, err := st.apply(*entry.Stream, entry.Stream.At); err != nil && !errors.Is(err, ErrGroupExists) {
+		s.log.Error("WAL: failed to replay stream op for '%s': %v", entry.Key, err)
+		return
+	}
+	s.restoreFromWAL(entry.Key, Item{Value: st, ExpiresAt: entry.Exp, Version: entry.Ver})
+}
@@ -2,8 +2,13 @@ package kv
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-engine/internal/pkg/script"
 )
 
 func (m *Module) handleGet(w http.ResponseWriter, r *http.Request) {
@@ -13,6 +18,12 @@ func (m *Module) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?at= — значение на момент времени (нужна включенная история для префикса)
+	if at := r.URL.Query().Get("at"); at != "" {
+		m.handleGetAt(w, key, at)
+		return
+	}
+
 	item, found := m.store.Get(key)
 	if !found {
 		http.Error(w, "Not found", http.StatusNotFound)
@@ -20,9 +31,81 @@ func (m *Module) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if item.Version > 0 {
+		w.Header().Set("X-Nexus-Version", strconv.FormatUint(item.Version, 10))
+	}
 	json.NewEncoder(w).Encode(item.Value)
 }
 
+func (m *Module) handleGetAt(w http.ResponseWriter, key, at string) {
+	if m.store.history == nil || !m.store.history.Enabled(key) {
+		http.Error(w, "History is not enabled for this key", http.StatusBadRequest)
+		return
+	}
+
+	ts, err := parseTimestamp(at)
+	if err != nil {
+		http.Error(w, "Bad timestamp (use unix ms or RFC3339)", http.StatusBadRequest)
+		return
+	}
+
+	v, found := m.store.history.At(key, ts)
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Nexus-Version", strconv.FormatUint(v.Version, 10))
+	json.NewEncoder(w).Encode(v.Value)
+}
+
+func (m *Module) handleHistory(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	if m.store.history == nil || !m.store.history.Enabled(key) {
+		http.Error(w, "History is not enabled for this key", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	type version struct {
+		Version uint64 `json:"version,omitempty"`
+		At      string `json:"at"`
+		Value   any    `json:"value,omitempty"`
+		Deleted bool   `json:"deleted,omitempty"`
+	}
+	list := m.store.history.List(key, limit)
+	out := make([]version, len(list))
+	for i, v := range list {
+		out[i] = version{
+			Version: v.Version,
+			At:      time.Unix(0, v.At).UTC().Format(time.RFC3339Nano),
+			Value:   v.Value,
+			Deleted: v.Deleted,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// parseTimestamp принимает unix миллисекунды или RFC3339, возвращает UnixNano
+func parseTimestamp(s string) (int64, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms * int64(time.Millisecond), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano(), nil
+}
+
 func (m *Module) handleSet(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
@@ -40,7 +123,430 @@ func (m *Module) handleSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m.store.Set(req.Key, req.Value, req.TTL)
+	if err := m.store.Set(req.Key, req.Value, req.TTL); err != nil {
+		if errors.Is(err, ErrReservedKey) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		m.store.log.Error("SET '%s' failed: %v", req.Key, err)
+		http.Error(w, "Upstream write failed", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"success\":true}")
+}
+
+func (m *Module) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Only POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad JSON", http.StatusBadRequest)
+			return
+		}
+		key = req.Key
+	}
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	if err := m.store.Delete(key); err != nil {
+		if errors.Is(err, ErrReservedKey) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		m.store.log.Error("DEL '%s' failed: %v", key, err)
+		http.Error(w, "Upstream write failed", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"success\":true}")
+}
+
+func (m *Module) handleTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tx Tx
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+
+	results, err := m.store.Exec(tx)
+
+	w.Header().Set("Content-Type", "application/json")
+	var abort *TxAbortError
+	switch {
+	case errors.As(err, &abort):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok":     false,
+			"reason": abort.Reason,
+			"key":    abort.Key,
+			"op":     abort.Op,
+		})
+	case errors.Is(err, ErrReservedKey):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "results": results})
+	}
+}
+
+func (m *Module) handleJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+		JSONOp
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	result, err := m.store.JSON(req.Key, req.JSONOp)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrReservedKey):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil && !errors.Is(err, ErrJSONOp):
+		// Документ не ушел в origin (write-through / очередь write-behind)
+		m.store.log.Error("JSON '%s' failed: %v", req.Key, err)
+		http.Error(w, "Upstream write failed", http.StatusBadGateway)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// handleIndex: GET — список индексов, POST — создать, DELETE ?name= — удалить
+func (m *Module) handleIndex(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.store.indexes.List())
+
+	case http.MethodPost:
+		var def IndexDef
+		if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+			http.Error(w, "Bad JSON", http.StatusBadRequest)
+			return
+		}
+		err := m.store.indexes.Create(def)
+		switch {
+		case errors.Is(err, ErrIndexExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "{\"success\":true}")
+
+	case http.MethodDelete:
+		if err := m.store.indexes.Drop(r.URL.Query().Get("name")); err != nil {
+			status := http.StatusInternalServerError // Индекс удален из памяти, но не из файла
+			if errors.Is(err, ErrIndexNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "{\"success\":true}")
+
+	default:
+		http.Error(w, "Only GET, POST or DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *Module) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var q Query
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+
+	res, err := m.store.Query(q)
+	switch {
+	case errors.Is(err, ErrIndexNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// lockRequest — тело запросов /kv/lock/*. Времена в миллисекундах.
+type lockRequest struct {
+	Name    string `json:"name"`
+	Owner   string `json:"owner"`
+	Token   uint64 `json:"token"`
+	LeaseMs int    `json:"lease_ms"`
+	WaitMs  int    `json:"wait_ms"` // Для acquire: сколько ждать в очереди (long-poll)
+}
+
+func (m *Module) decodeLockRequest(w http.ResponseWriter, r *http.Request) (lockRequest, bool) {
+	var req lockRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func (m *Module) writeLockResult(w http.ResponseWriter, info LockInfo, err error) {
+	switch {
+	case errors.Is(err, ErrLockTimeout):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "{\"acquired\":false}")
+	case errors.Is(err, ErrLockNotOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrLockInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		// Клиент отвалился, пока ждал в очереди
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"acquired":   true,
+			"name":       info.Name,
+			"owner":      info.Owner,
+			"token":      info.Token,
+			"expires_at": info.ExpiresAt,
+		})
+	}
+}
+
+func (m *Module) handleLockAcquire(w http.ResponseWriter, r *http.Request) {
+	req, ok := m.decodeLockRequest(w, r)
+	if !ok {
+		return
+	}
+	lease := time.Duration(req.LeaseMs) * time.Millisecond
+	wait := time.Duration(req.WaitMs) * time.Millisecond
+
+	info, err := m.store.locks.Acquire(r.Context(), req.Name, req.Owner, lease, wait)
+	m.writeLockResult(w, info, err)
+}
+
+func (m *Module) handleLockRenew(w http.ResponseWriter, r *http.Request) {
+	req, ok := m.decodeLockRequest(w, r)
+	if !ok {
+		return
+	}
+	lease := time.Duration(req.LeaseMs) * time.Millisecond
+
+	info, err := m.store.locks.Renew(req.Name, req.Owner, req.Token, lease)
+	m.writeLockResult(w, info, err)
+}
+
+func (m *Module) handleLockRelease(w http.ResponseWriter, r *http.Request) {
+	req, ok := m.decodeLockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := m.store.locks.Release(req.Name, req.Owner, req.Token); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "{\"success\":true}")
 }
+
+func (m *Module) handleScriptLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Source string `json:"source"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+
+	sha, _, err := m.store.scripts.Load(req.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+}
+
+func (m *Module) handleScriptEval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Либо sha ранее загруженного скрипта, либо source (он будет закэширован)
+	var req struct {
+		SHA    string   `json:"sha"`
+		Source string   `json:"source"`
+		Keys   []string `json:"keys"`
+		Args   []any    `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+
+	var prog *script.Program
+	if req.Source != "" {
+		var err error
+		if _, prog, err = m.store.scripts.Load(req.Source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var ok bool
+		if prog, ok = m.store.scripts.Get(req.SHA); !ok {
+			http.Error(w, ErrScriptNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	result, err := m.store.EvalScript(prog, req.Keys, req.Args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"result": result})
+}
+
+// handleSketch — операции над HLL/Bloom/CMS: POST {"key": "...", "op": "add", "items": [...]}
+func (m *Module) handleSketch(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Key string `json:"key"`
+			SketchOp
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Key == "" {
+			http.Error(w, "Missing key", http.StatusBadRequest)
+			return
+		}
+		req.Type = kind
+
+		result, err := m.store.Sketch(req.Key, req.SketchOp)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		case errors.Is(err, ErrWrongType), errors.Is(err, ErrSketchExist):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrReservedKey):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+	}
+}
+
+// handleStream — операции над стримом: POST {"key": "...", "op": "add", "value": {...}}.
+// read/group.read с block_ms держат запрос, пока не появятся записи (long-poll).
+func (m *Module) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+		StreamOp
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	result, err := m.store.Stream(r.Context(), req.Key, req.StreamOp)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrNoGroup):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrWrongType), errors.Is(err, ErrGroupExists), errors.Is(err, ErrIDNotIncrease):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrReservedKey):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, r.Context().Err()) && r.Context().Err() != nil:
+		return // Клиент ушел, пока ждал
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
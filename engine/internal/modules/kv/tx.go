@@ -0,0 +1,329 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// TxOp — одна операция транзакции
+type TxOp struct {
+	Op    string  `json:"op"` // get, set, del, incr
+	Key   string  `json:"key"`
+	Value any     `json:"value,omitempty"` // для set
+	TTL   int     `json:"ttl,omitempty"`   // для set (для incr TTL ключа сохраняется)
+	By    float64 `json:"by,omitempty"`    // для incr
+	If    *TxCond `json:"if,omitempty"`
+}
+
+// TxCond — условие операции. Если не выполнено, вся транзакция откатывается.
+type TxCond struct {
+	Exists *bool    `json:"exists,omitempty"` // Ключ должен существовать / отсутствовать
+	Equals any      `json:"equals,omitempty"` // Текущее значение должно совпадать
+	Min    *float64 `json:"min,omitempty"`    // Для incr: результат не меньше
+	Max    *float64 `json:"max,omitempty"`    // Для incr: результат не больше
+}
+
+// Tx — транзакция: WATCH (ключ -> ожидаемая версия, 0 = ключа нет) + список операций
+type Tx struct {
+	Watch map[string]uint64 `json:"watch,omitempty"`
+	Ops   []TxOp            `json:"ops"`
+}
+
+// TxResult — результат операции (для get и incr — значение после операции)
+type TxResult struct {
+	Key     string `json:"key"`
+	Value   any    `json:"value,omitempty"`
+	Found   bool   `json:"found"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// TxAbortError — транзакция не применена (WATCH или условие)
+type TxAbortError struct {
+	Reason string // "watch" или "condition"
+	Key    string
+	Op     int // Индекс операции (для "condition")
+}
+
+func (e *TxAbortError) Error() string {
+	if e.Reason == "watch" {
+		return fmt.Sprintf("transaction aborted: watched key '%s' changed", e.Key)
+	}
+	return fmt.Sprintf("transaction aborted: condition failed for op #%d on '%s'", e.Op, e.Key)
+}
+
+var ErrTxInvalid = errors.New("invalid transaction")
+
+// staged — ключ в процессе транзакции (поверх шарда)
+type staged struct {
+	item    Item
+	exists  bool
+	changed bool
+}
+
+// Exec атомарно применяет транзакцию: лочит все затронутые шарды (в порядке индексов),
+// проверяет WATCH, выполняет операции на копии и одной записью пишет результат в WAL.
+// Ключи в режиме write-behind уходят в очередь origin вместе с коммитом. Ключи в режиме
+// write-through транзакция менять не может: запись в origin не сделать атомарной с локальной.
+func (s *Storage) Exec(tx Tx) ([]TxResult, error) {
+	keys := make([]string, 0, len(tx.Ops)+len(tx.Watch))
+	for k := range tx.Watch {
+		keys = append(keys, k)
+	}
+	for _, op := range tx.Ops {
+		if op.Key == "" {
+			return nil, fmt.Errorf("%w: empty key", ErrTxInvalid)
+		}
+		switch op.Op {
+		case "get":
+		case "set", "del", "incr":
+			if err := s.checkTxWrite(op.Key); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown op %q", ErrTxInvalid, op.Op)
+		}
+		keys = append(keys, op.Key)
+	}
+
+	results := make([]TxResult, 0, len(tx.Ops))
+	err := s.withKeys(keys, func(view *txView) error {
+		// 1. WATCH: версия на момент EXEC должна совпадать с той, что видел клиент
+		for k, expected := range tx.Watch {
+			if view.get(k).version() != expected {
+				return &TxAbortError{Reason: "watch", Key: k}
+			}
+		}
+
+		// 2. Выполняем операции на копии
+		for i, op := range tx.Ops {
+			st := view.get(op.Key)
+			if !checkCond(op.If, st) {
+				return &TxAbortError{Reason: "condition", Key: op.Key, Op: i}
+			}
+
+			switch op.Op {
+			case "get":
+				results = append(results, TxResult{Key: op.Key, Value: st.item.Value, Found: st.exists, Version: st.version()})
+
+			case "set":
+				ver := view.put(op.Key, Item{Value: op.Value, ExpiresAt: expiresAt(op.TTL)})
+				results = append(results, TxResult{Key: op.Key, Value: op.Value, Found: true, Version: ver})
+
+			case "del":
+				results = append(results, TxResult{Key: op.Key, Found: st.exists}) // До remove: st меняется на месте
+				view.remove(op.Key)
+
+			case "incr":
+				var cur float64
+				if st.exists {
+					n, ok := st.item.Value.(float64)
+					if !ok {
+						return fmt.Errorf("%w: value of '%s' is not a number", ErrTxInvalid, op.Key)
+					}
+					cur = n
+				}
+				next := cur + op.By
+				if op.If != nil && ((op.If.Min != nil && next < *op.If.Min) || (op.If.Max != nil && next > *op.If.Max)) {
+					return &TxAbortError{Reason: "condition", Key: op.Key, Op: i}
+				}
+
+				item := st.item
+				if !st.exists {
+					item.ExpiresAt = expiresAt(0)
+				}
+				item.Value = next
+				ver := view.put(op.Key, item)
+				results = append(results, TxResult{Key: op.Key, Value: next, Found: true, Version: ver})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkTxWrite — можно ли менять ключ в транзакции или скрипте
+func (s *Storage) checkTxWrite(key string) error {
+	if err := checkUserWrite(key); err != nil {
+		return err
+	}
+	if s.writeMode(key) == "through" {
+		return fmt.Errorf("%w: key '%s' is written through to upstream origin", ErrUpstreamWriteMode, key)
+	}
+	return nil
+}
+
+// checkCond проверяет условие операции (Min/Max проверяются в incr отдельно)
+func checkCond(cond *TxCond, st *staged) bool {
+	if cond == nil {
+		return true
+	}
+	if cond.Exists != nil && *cond.Exists != st.exists {
+		return false
+	}
+	if cond.Equals != nil && (!st.exists || !reflect.DeepEqual(cond.Equals, st.item.Value)) {
+		return false
+	}
+	return true
+}
+
+func (st *staged) version() uint64 {
+	if !st.exists {
+		return 0
+	}
+	return st.item.Version
+}
+
+// txView — изменения транзакции поверх залоченных шардов
+type txView struct {
+	s     *Storage
+	now   int64
+	keys  map[string]*staged
+	order []string // Порядок первого изменения (для WAL и событий)
+}
+
+func (v *txView) get(key string) *staged {
+	if st, ok := v.keys[key]; ok {
+		return st
+	}
+
+	st := &staged{}
+	item, ok := v.s.shards[getShardIndex(key)].items[key]
+	if ok && v.now <= item.ExpiresAt {
+		st.item, st.exists = item, true
+	}
+	v.keys[key] = st
+	return st
+}
+
+// put ставит новое значение ключа. Версия выдается сразу (пропуски версий при откате не страшны).
+func (v *txView) put(key string, item Item) uint64 {
+	st := v.get(key)
+	if !st.changed {
+		v.order = append(v.order, key)
+	}
+	item.Value = ownValue(item.Value)
+	item.Version = v.s.nextVersion()
+	item.Upstream = false // Изменено локально — больше не копия из origin
+	st.item, st.exists, st.changed = item, true, true
+	return item.Version
+}
+
+func (v *txView) remove(key string) {
+	st := v.get(key)
+	if !st.changed {
+		v.order = append(v.order, key)
+	}
+	st.item, st.exists, st.changed = Item{}, false, true
+}
+
+// events собирает события коммита (вызывается под локами шардов)
+func (v *txView) events() []KeyEvent {
+	events := make([]KeyEvent, 0, len(v.order))
+	for _, k := range v.order {
+		st := v.keys[k]
+		if st.exists {
+			events = append(events, v.s.setEvent(k, st.item))
+		} else {
+			events = append(events, KeyEvent{Op: "del", Key: k, Version: v.s.nextVersion(), At: time.Now().UnixNano()})
+		}
+	}
+	return events
+}
+
+// withKeys лочит шарды ключей, дает fn изменить их через txView и атомарно фиксирует изменения:
+// одна запись "tx" в WAL, очередь write-behind, затем применение к шардам, затем события наблюдателям.
+// Если fn вернула ошибку — ничего не меняется.
+func (s *Storage) withKeys(keys []string, fn func(view *txView) error) error {
+	events, err := s.commitKeys(keys, fn)
+	if err != nil {
+		return err
+	}
+
+	// Наблюдатели вызываются вне локов
+	for _, ev := range events {
+		s.notify(ev)
+	}
+	return nil
+}
+
+func (s *Storage) commitKeys(keys []string, fn func(view *txView) error) ([]KeyEvent, error) {
+	s.snapshotMu.RLock()
+	defer s.snapshotMu.RUnlock()
+
+	unlock := s.lockShards(keys)
+	defer unlock()
+
+	view := &txView{s: s, now: time.Now().UnixNano(), keys: make(map[string]*staged)}
+	if err := fn(view); err != nil {
+		return nil, err
+	}
+	if len(view.order) == 0 {
+		return nil, nil
+	}
+
+	ops := make([]WALEntry, 0, len(view.order))
+	for _, k := range view.order {
+		st := view.keys[k]
+		if st.exists {
+			ops = append(ops, WALEntry{Op: "set", Key: k, Value: st.item.Value, Exp: st.item.ExpiresAt, Ver: st.item.Version, Struct: isStruct(st.item.Value)})
+		} else {
+			ops = append(ops, WALEntry{Op: "del", Key: k})
+		}
+	}
+
+	if s.wal != nil {
+		if err := s.wal.WriteEvent(WALEntry{Op: "tx", Ops: ops}); err != nil {
+			s.log.Error("WAL Write Error: %v", err)
+		}
+	}
+
+	// В очередь write-behind — под локами шардов, как и в set: порядок совпадает с версиями.
+	// Транзакция уже в WAL, поэтому ошибку очереди только логируем.
+	for _, op := range ops {
+		entry := WALEntry{Op: op.Op, Key: op.Key, Value: op.Value}
+		if err := s.writeBehind(entry); err != nil {
+			s.log.Error("Write-behind enqueue error for '%s': %v", op.Key, err)
+		}
+	}
+
+	for _, k := range view.order {
+		st := view.keys[k]
+		shard := s.shards[getShardIndex(k)]
+		if st.exists {
+			shard.items[k] = st.item
+		} else {
+			delete(shard.items, k)
+		}
+	}
+	return view.events(), nil
+}
+
+// lockShards берет эксклюзивные локи шардов ключей в порядке индексов (без дедлоков между транзакциями)
+func (s *Storage) lockShards(keys []string) func() {
+	seen := make(map[int]bool)
+	var idxs []int
+	for _, k := range keys {
+		idx := getShardIndex(k)
+		if !seen[idx] {
+			seen[idx] = true
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Ints(idxs)
+
+	for _, idx := range idxs {
+		s.shards[idx].mu.Lock()
+	}
+	return func() {
+		for i := len(idxs) - 1; i >= 0; i-- {
+			s.shards[idxs[i]].mu.Unlock()
+		}
+	}
+}
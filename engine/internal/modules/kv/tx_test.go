@@ -0,0 +1,238 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"nexus-engine/internal/pkg/logger"
+)
+
+// newTestStorage — хранилище во временной папке, без origin
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := New(Options{
+		PersistPath:     filepath.Join(t.TempDir(), "kv.json"),
+		CleanupInterval: time.Minute,
+		Logger:          logger.New(logger.LevelError),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func boolPtr(b bool) *bool        { return &b }
+func floatPtr(f float64) *float64 { return &f }
+func version(s *Storage, key string) uint64 {
+	item, _ := s.Get(key)
+	return item.Version
+}
+
+func TestExec(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup map[string]any
+		tx    func(s *Storage) Tx // Версии для WATCH известны только после setup
+		want  []TxResult          // Version не сравнивается
+		abort string              // Ожидаемая TxAbortError.Reason
+		err   error               // Ожидаемая ошибка (errors.Is)
+		after map[string]any      // Ключи после Exec (nil — ключа нет)
+	}{
+		{
+			name:  "set get del incr",
+			setup: map[string]any{"a": "old", "n": 1.0},
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{
+					{Op: "set", Key: "a", Value: "new"},
+					{Op: "get", Key: "a"},
+					{Op: "del", Key: "gone"},
+					{Op: "incr", Key: "n", By: 2},
+					{Op: "incr", Key: "fresh", By: 5},
+				}}
+			},
+			want: []TxResult{
+				{Key: "a", Value: "new", Found: true},
+				{Key: "a", Value: "new", Found: true},
+				{Key: "gone", Found: false},
+				{Key: "n", Value: 3.0, Found: true},
+				{Key: "fresh", Value: 5.0, Found: true},
+			},
+			after: map[string]any{"a": "new", "n": 3.0, "fresh": 5.0, "gone": nil},
+		},
+		{
+			name:  "watch matches",
+			setup: map[string]any{"a": "x"},
+			tx: func(s *Storage) Tx {
+				return Tx{Watch: map[string]uint64{"a": version(s, "a"), "missing": 0}, Ops: []TxOp{{Op: "del", Key: "a"}}}
+			},
+			want:  []TxResult{{Key: "a", Found: true}},
+			after: map[string]any{"a": nil},
+		},
+		{
+			name:  "watch changed",
+			setup: map[string]any{"a": "x", "b": "y"},
+			tx: func(s *Storage) Tx {
+				return Tx{Watch: map[string]uint64{"a": version(s, "a") - 1}, Ops: []TxOp{{Op: "set", Key: "b", Value: "z"}}}
+			},
+			abort: "watch",
+			after: map[string]any{"a": "x", "b": "y"},
+		},
+		{
+			name:  "watch on a key that appeared",
+			setup: map[string]any{"a": "x"},
+			tx: func(*Storage) Tx {
+				return Tx{Watch: map[string]uint64{"a": 0}, Ops: []TxOp{{Op: "set", Key: "a", Value: "y"}}}
+			},
+			abort: "watch",
+			after: map[string]any{"a": "x"},
+		},
+		{
+			name:  "failed condition rolls back earlier ops",
+			setup: map[string]any{"a": "x"},
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{
+					{Op: "set", Key: "b", Value: 1.0},
+					{Op: "set", Key: "a", Value: "y", If: &TxCond{Exists: boolPtr(false)}},
+				}}
+			},
+			abort: "condition",
+			after: map[string]any{"a": "x", "b": nil},
+		},
+		{
+			name:  "equals sees staged value",
+			setup: map[string]any{"a": "x"},
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{
+					{Op: "set", Key: "a", Value: "y"},
+					{Op: "del", Key: "a", If: &TxCond{Equals: "y"}},
+				}}
+			},
+			want:  []TxResult{{Key: "a", Value: "y", Found: true}, {Key: "a", Found: true}},
+			after: map[string]any{"a": nil},
+		},
+		{
+			name:  "incr over max",
+			setup: map[string]any{"n": 9.0},
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{{Op: "incr", Key: "n", By: 2, If: &TxCond{Max: floatPtr(10)}}}}
+			},
+			abort: "condition",
+			after: map[string]any{"n": 9.0},
+		},
+		{
+			name:  "incr not a number",
+			setup: map[string]any{"s": "text"},
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{{Op: "incr", Key: "s", By: 1}}}
+			},
+			err:   ErrTxInvalid,
+			after: map[string]any{"s": "text"},
+		},
+		{
+			name: "unknown op",
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{{Op: "set", Key: "a", Value: 1.0}, {Op: "append", Key: "a"}}}
+			},
+			err:   ErrTxInvalid,
+			after: map[string]any{"a": nil},
+		},
+		{
+			name: "empty key",
+			tx: func(*Storage) Tx {
+				return Tx{Ops: []TxOp{{Op: "get"}}}
+			},
+			err: ErrTxInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			for k, v := range tt.setup {
+				if err := s.Set(k, v, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := s.Exec(tt.tx(s))
+			var abort *TxAbortError
+			switch {
+			case tt.abort != "":
+				if !errors.As(err, &abort) || abort.Reason != tt.abort {
+					t.Fatalf("err = %v, want %s abort", err, tt.abort)
+				}
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				for i := range got {
+					got[i].Version = 0
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("results = %+v, want %+v", got, tt.want)
+				}
+			}
+
+			for k, want := range tt.after {
+				item, ok := s.Get(k)
+				if want == nil {
+					if ok {
+						t.Errorf("%s = %v, want no key", k, item.Value)
+					}
+					continue
+				}
+				if !ok || !reflect.DeepEqual(item.Value, want) {
+					t.Errorf("%s = %v (found %v), want %v", k, item.Value, ok, want)
+				}
+			}
+		})
+	}
+}
+
+// Транзакция пишется в WAL одной записью и переживает рестарт
+func TestExecReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.json")
+	open := func() *Storage {
+		s, err := New(Options{PersistPath: path, CleanupInterval: time.Minute, Logger: logger.New(logger.LevelError)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	s := open()
+	s.Set("a", "x", 0)
+	res, err := s.Exec(Tx{Ops: []TxOp{
+		{Op: "set", Key: "b", Value: map[string]any{"n": 1.0}},
+		{Op: "del", Key: "a"},
+		{Op: "incr", Key: "c", By: 3},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = open()
+	defer s.Close()
+	if _, ok := s.Get("a"); ok {
+		t.Error("a survived its delete")
+	}
+	if item, ok := s.Get("b"); !ok || !reflect.DeepEqual(item.Value, map[string]any{"n": 1.0}) || item.Version != res[0].Version {
+		t.Errorf("b = %+v, want version %d", item, res[0].Version)
+	}
+	if item, ok := s.Get("c"); !ok || item.Value != 3.0 {
+		t.Errorf("c = %+v", item)
+	}
+	// Новые версии выдаются после восстановленных
+	s.Set("d", 1.0, 0)
+	if v := version(s, "d"); v <= res[2].Version {
+		t.Errorf("version %d after restart is not above %d", v, res[2].Version)
+	}
+}
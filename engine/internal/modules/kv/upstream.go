@@ -0,0 +1,183 @@
+package kv
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamCall — один запрос в origin, результат которого ждут все конкурентные промахи по ключу
+type upstreamCall struct {
+	wg   sync.WaitGroup
+	item Item
+	ok   bool
+}
+
+// Upstream — общий клиент к origin-ам для cache-aside.
+// Склеивает одновременные промахи по одному ключу в один HTTP запрос (singleflight),
+// ограничивает общее число запросов "в полете" и выбирает Loader по префиксу ключа.
+type Upstream struct {
+	transport *http.Transport
+	sem       chan struct{} // Семафор на количество параллельных запросов
+	loaders   []*Loader     // Отсортированы по длине префикса (самый длинный — первый)
+
+	mu    sync.Mutex
+	calls map[string]*upstreamCall
+
+	// Negative cache: ключи, на которые origin ответил 404 -> время, до которого верим этому ответу
+	negMu    sync.RWMutex
+	negative map[string]int64
+}
+
+func NewUpstream(configs []LoaderConfig, opts Options) (*Upstream, error) {
+	maxInFlight := opts.UpstreamMaxFlight
+	if maxInFlight <= 0 {
+		maxInFlight = 1
+	}
+
+	// Один Transport на всё время жизни — соединения переиспользуются (keep-alive)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxInFlight
+	transport.MaxIdleConnsPerHost = maxInFlight
+	transport.MaxConnsPerHost = maxInFlight
+
+	u := &Upstream{
+		transport: transport,
+		sem:       make(chan struct{}, maxInFlight),
+		calls:     make(map[string]*upstreamCall),
+
+		negative: make(map[string]int64),
+	}
+
+	for _, cfg := range configs {
+		loader, err := newLoader(cfg, transport, u.sem, opts)
+		if err != nil {
+			return nil, err
+		}
+		u.loaders = append(u.loaders, loader)
+	}
+
+	sort.SliceStable(u.loaders, func(i, j int) bool {
+		return len(u.loaders[i].cfg.Prefix) > len(u.loaders[j].cfg.Prefix)
+	})
+	return u, nil
+}
+
+// route возвращает лоадер с самым длинным подходящим префиксом (или nil)
+func (u *Upstream) route(key string) *Loader {
+	for _, l := range u.loaders {
+		if strings.HasPrefix(key, l.cfg.Prefix) {
+			return l
+		}
+	}
+	return nil
+}
+
+// hasWriteMode — есть ли хоть один лоадер с таким режимом записи
+func (u *Upstream) hasWriteMode(mode string) bool {
+	for _, l := range u.loaders {
+		if l.writeMode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Do выполняет fn для ключа ровно один раз, даже если его одновременно запросили N клиентов.
+// Остальные вызовы ждут и получают тот же результат.
+func (u *Upstream) Do(key string, fn func() (Item, bool)) (Item, bool) {
+	call, leader := u.begin(key)
+	if !leader {
+		call.wg.Wait()
+		return call.item, call.ok
+	}
+
+	u.run(key, call, fn)
+	return call.item, call.ok
+}
+
+// Refresh запускает fn в фоне, если по ключу еще нет запроса в полете.
+// Используется для stale-while-revalidate: клиент не ждет origin.
+func (u *Upstream) Refresh(key string, fn func() (Item, bool)) {
+	call, leader := u.begin(key)
+	if !leader {
+		return
+	}
+	go u.run(key, call, fn)
+}
+
+// begin регистрирует запрос по ключу. leader=true, если вызывающий должен его выполнить.
+func (u *Upstream) begin(key string) (*upstreamCall, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if call, ok := u.calls[key]; ok {
+		return call, false
+	}
+
+	call := &upstreamCall{}
+	call.wg.Add(1)
+	u.calls[key] = call
+	return call, true
+}
+
+func (u *Upstream) run(key string, call *upstreamCall, fn func() (Item, bool)) {
+	// Через defer: если fn паникует, слот и ожидающие промахи не должны зависнуть навсегда
+	defer func() {
+		u.mu.Lock()
+		delete(u.calls, key)
+		u.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	// Ждем свободный слот, чтобы не положить origin
+	u.sem <- struct{}{}
+	defer func() { <-u.sem }()
+
+	call.item, call.ok = fn()
+}
+
+// markMissing запоминает, что ключа нет в origin, на время ttl
+func (u *Upstream) markMissing(key string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	u.negMu.Lock()
+	u.negative[key] = time.Now().Add(ttl).UnixNano()
+	u.negMu.Unlock()
+}
+
+// isMissing — есть ли живая negative-запись для ключа
+func (u *Upstream) isMissing(key string) bool {
+	u.negMu.RLock()
+	exp, ok := u.negative[key]
+	u.negMu.RUnlock()
+	return ok && time.Now().UnixNano() <= exp
+}
+
+// forgetMissing снимает negative-запись (ключ появился локально)
+func (u *Upstream) forgetMissing(key string) {
+	u.negMu.Lock()
+	delete(u.negative, key)
+	u.negMu.Unlock()
+}
+
+// cleanupMissing удаляет протухшие negative-записи
+func (u *Upstream) cleanupMissing() {
+	now := time.Now().UnixNano()
+
+	u.negMu.Lock()
+	defer u.negMu.Unlock()
+	for key, exp := range u.negative {
+		if now > exp {
+			delete(u.negative, key)
+		}
+	}
+}
+
+// Close закрывает простаивающие соединения
+func (u *Upstream) Close() {
+	u.transport.CloseIdleConnections()
+}
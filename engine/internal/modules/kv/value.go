@@ -0,0 +1,64 @@
+package kv
+
+import "encoding/json"
+
+// mutableValue — значения, которые меняются на месте под своим локом (скетчи, стримы).
+// Остальные значения в шардах неизменяемы: каждое изменение кладет новый Item.
+type mutableValue interface {
+	json.Marshaler
+	cloneValue() any
+}
+
+// ownValue приводит записываемое значение к виду, в котором оно хранится в шарде: чужая структура
+// копируется (два ключа не должны делить одно изменяемое значение). Конверты ({"$sketch":...},
+// {"$stream":...}) от пользователя остаются обычным JSON: структуры из них поднимает только reviveValue.
+func ownValue(v any) any {
+	if mv, ok := v.(mutableValue); ok {
+		return mv.cloneValue()
+	}
+	return v
+}
+
+// isStruct — изменяемая структура (в снапшоте и WAL такие помечаются, чтобы поднять их из конверта)
+func isStruct(v any) bool {
+	_, ok := v.(mutableValue)
+	return ok
+}
+
+// reviveValue восстанавливает структуру из конверта. Только для своих помеченных данных (снапшот, WAL):
+// из пользовательской записи конверт не поднимается
+func reviveValue(v any) any {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	if _, ok := m["$sketch"]; ok {
+		if sv, ok := decodeSketch(m); ok {
+			return sv
+		}
+	}
+	if _, ok := m["$stream"]; ok {
+		if st, ok := decodeStream(m); ok {
+			return st
+		}
+	}
+	return v
+}
+
+// frozenValue — копия для снапшота: изменяемые значения фиксируем, иначе в снапшот попадут
+// изменения, которые уже лежат в новом WAL, и при старте применятся дважды
+func frozenValue(v any) any {
+	if mv, ok := v.(mutableValue); ok {
+		return mv.cloneValue()
+	}
+	return v
+}
+
+// eventValue — значение для наблюдателей. Изменяемые структуры не рассылаем:
+// это может быть мегабайт на каждую операцию.
+func eventValue(v any) any {
+	if _, ok := v.(mutableValue); ok {
+		return nil
+	}
+	return v
+}
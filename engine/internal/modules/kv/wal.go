@@ -8,10 +8,19 @@ import (
 
 // WALEntry — одна операция в журнале
 type WALEntry struct {
-	Op    string `json:"op"` // "set", "del"
-	Key   string `json:"k"`
-	Value any    `json:"v"`
-	Exp   int64  `json:"e,omitempty"`
+	Op       string `json:"op"` // "set", "del", "tx", "json", "sketch", "stream"
+	Key      string `json:"k"`
+	Value    any    `json:"v"`
+	Exp      int64  `json:"e,omitempty"`
+	Ver      uint64 `json:"ver,omitempty"` // Версия ключа после операции
+	ID       uint64 `json:"id,omitempty"`  // Порядковый номер (используется журналом write-behind)
+	Upstream bool   `json:"up,omitempty"`  // Для "set": значение загружено из origin
+	Struct   bool   `json:"st,omitempty"`  // Для "set": значение — конверт скетча/стрима
+
+	Ops    []WALEntry `json:"ops,omitempty"`    // Для "tx": все изменения транзакции одной записью
+	Patch  *JSONOp    `json:"patch,omitempty"`  // Для "json": частичное изменение документа
+	Sketch *SketchOp  `json:"sketch,omitempty"` // Для "sketch": операция над HLL/Bloom/CMS
+	Stream *StreamOp  `json:"stream,omitempty"` // Для "stream": операция над стримом
 }
 
 type WAL struct {
@@ -79,6 +88,27 @@ func (w *WAL) reopen() error {
 	return nil
 }
 
+// replayEntry применяет одну запись журнала к памяти
+func (s *Storage) replayEntry(entry WALEntry) {
+	switch entry.Op {
+	case "set":
+		s.restoreFromWAL(entry.Key, Item{Value: entry.Value, ExpiresAt: entry.Exp, Version: entry.Ver, Upstream: entry.Upstream, Struct: entry.Struct})
+	case "del":
+		s.removeFromWAL(entry.Key)
+	case "json":
+		s.replayJSON(entry)
+	case "sketch":
+		s.replaySketch(entry)
+	case "stream":
+		s.replayStream(entry)
+	case "tx":
+		// Транзакция — одна строка JSON: либо прочитана целиком, либо Decode упадет на битом хвосте
+		for _, op := range entry.Ops {
+			s.replayEntry(op)
+		}
+	}
+}
+
 // Replay считывает лог и применяет его к хранилищу (используется при старте)
 func ReplayWAL(path string, store *Storage) error {
 	file, err := os.Open(path)
@@ -97,9 +127,7 @@ func ReplayWAL(path string, store *Storage) error {
 			return err // Битая запись
 		}
 
-		if entry.Op == "set" {
-			store.restoreFromWAL(entry.Key, entry.Value, entry.Exp)
-		}
+		store.replayEntry(entry)
 	}
 	return nil
 }
@@ -33,25 +33,42 @@ func (s *Storage) cleanupShards() {
 	for i := 0; i < ShardCount; i++ {
 		s.cleanupSingleShard(s.shards[i])
 	}
+
+	if s.upstream != nil {
+		s.upstream.cleanupMissing()
+	}
 }
 
 func (s *Storage) cleanupSingleShard(shard *Shard) {
 	sampleSize := 20
 	now := time.Now().UnixNano()
 
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
+	// Протухшие ключи держим еще немного, чтобы отдавать их как stale, пока идет обновление
+	var grace int64
+	if s.upstream != nil {
+		grace = int64(s.opts.UpstreamStaleTTL)
+	}
 
+	var expired []KeyEvent
+
+	shard.mu.Lock()
 	processed := 0
 	for key, item := range shard.items {
 		if processed >= sampleSize {
 			break
 		}
-		if now > item.ExpiresAt {
+		if now > item.ExpiresAt+grace {
 			delete(shard.items, key)
+			expired = append(expired, KeyEvent{Op: "expired", Key: key, Version: item.Version, At: item.ExpiresAt})
 			// В идеале: записать в WAL событие {"op":"del", "k":key}
 			// Но для TTL это не обязательно, при перезагрузке они и так будут старыми
 		}
 		processed++
 	}
+	shard.mu.Unlock()
+
+	// Уведомляем уже после снятия лока шарда
+	for _, ev := range expired {
+		s.notify(ev)
+	}
 }
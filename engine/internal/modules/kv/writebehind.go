@@ -0,0 +1,312 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"nexus-engine/internal/pkg/logger"
+)
+
+const (
+	outboxMinBackoff = 500 * time.Millisecond
+	outboxMaxBackoff = 30 * time.Second
+)
+
+// Outbox — очередь записей в origin для режима write-behind.
+// Каждая запись сначала попадает в свой журнал (set/del с ID), после успешной
+// отправки туда дописывается "ack". При рестарте неподтвержденные записи поднимаются снова.
+// Сетевые ошибки и 5xx повторяются с backoff, запись, отвергнутая origin (4xx), выкидывается с ошибкой в логе.
+type Outbox struct {
+	wal  *WAL
+	send func(entry WALEntry) error
+	log  *logger.Logger
+
+	batchSize int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	seq     uint64
+	pending []WALEntry        // В порядке поступления
+	latest  map[string]uint64 // key -> ID последней записи по ключу (нужно для Get)
+	ops     map[uint64]string // ID -> op, чтобы отвечать на pendingDelete без поиска
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func OpenOutbox(path string, batchSize int, interval time.Duration, send func(WALEntry) error, log *logger.Logger) (*Outbox, error) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	o := &Outbox{
+		send:      send,
+		log:       log,
+		batchSize: batchSize,
+		interval:  interval,
+		latest:    make(map[string]uint64),
+		ops:       make(map[uint64]string),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if err := o.replay(path); err != nil {
+		return nil, err
+	}
+
+	wal, err := OpenWAL(path)
+	if err != nil {
+		return nil, err
+	}
+	o.wal = wal
+
+	// Сжимаем журнал: оставляем только то, что еще не доставлено
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+
+	if len(o.pending) > 0 {
+		log.Info("📤 Write-behind: %d pending writes restored", len(o.pending))
+	}
+
+	go o.loop()
+	return o, nil
+}
+
+// replay поднимает неподтвержденные записи из журнала
+func (o *Outbox) replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	byID := make(map[uint64]WALEntry)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var entry WALEntry
+		if err := decoder.Decode(&entry); err != nil {
+			// Хвост мог оборваться при падении — всё, что до него, валидно
+			o.log.Error("Write-behind journal is corrupted, stopping replay: %v", err)
+			break
+		}
+
+		if entry.ID > o.seq {
+			o.seq = entry.ID
+		}
+
+		switch entry.Op {
+		case "set", "del":
+			byID[entry.ID] = entry
+		case "ack":
+			delete(byID, entry.ID)
+		}
+	}
+
+	for _, entry := range byID {
+		o.pending = append(o.pending, entry)
+	}
+	sort.Slice(o.pending, func(i, j int) bool { return o.pending[i].ID < o.pending[j].ID })
+
+	for _, entry := range o.pending {
+		o.latest[entry.Key] = entry.ID
+		o.ops[entry.ID] = entry.Op
+	}
+	return nil
+}
+
+// compact переписывает журнал, оставляя только pending записи
+func (o *Outbox) compact() error {
+	if err := o.wal.Truncate(); err != nil {
+		return err
+	}
+	for _, entry := range o.pending {
+		if err := o.wal.WriteEvent(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enqueue ставит запись в очередь. Возвращается после записи в журнал.
+func (o *Outbox) Enqueue(entry WALEntry) error {
+	o.mu.Lock()
+	o.seq++
+	entry.ID = o.seq
+
+	if err := o.wal.WriteEvent(entry); err != nil {
+		o.seq--
+		o.mu.Unlock()
+		return err
+	}
+
+	o.pending = append(o.pending, entry)
+	o.latest[entry.Key] = entry.ID
+	o.ops[entry.ID] = entry.Op
+	full := len(o.pending) >= o.batchSize
+	o.mu.Unlock()
+
+	// Набрали полный батч — не ждем тикера
+	if full {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// pendingDelete — удален ли ключ локально, но удаление еще не дошло до origin
+func (o *Outbox) pendingDelete(key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id, ok := o.latest[key]
+	return ok && o.ops[id] == "del"
+}
+
+func (o *Outbox) loop() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	backoff := outboxMinBackoff
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+
+		// Выгребаем очередь батчами, пока она не опустеет или origin не начнет сбоить
+		for {
+			sent, failed := o.flush()
+			if failed == 0 {
+				backoff = outboxMinBackoff
+				if sent == 0 {
+					break
+				}
+				continue
+			}
+
+			o.log.Error("📤 Write-behind: %d writes failed, retrying in %v", failed, backoff)
+			select {
+			case <-o.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, outboxMaxBackoff)
+		}
+	}
+}
+
+// flush отправляет один батч. Несколько записей по одному ключу склеиваются в последнюю.
+func (o *Outbox) flush() (sent int, failed int) {
+	o.mu.Lock()
+	n := min(len(o.pending), o.batchSize)
+	batch := make([]WALEntry, n)
+	copy(batch, o.pending[:n])
+	o.mu.Unlock()
+
+	if n == 0 {
+		return 0, 0
+	}
+
+	// key -> последняя запись в батче; все ID по ключу подтверждаются вместе
+	last := make(map[string]WALEntry)
+	ids := make(map[string][]uint64)
+	for _, entry := range batch {
+		last[entry.Key] = entry
+		ids[entry.Key] = append(ids[entry.Key], entry.ID)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		resMu sync.Mutex
+		acked = make(map[uint64]bool)
+	)
+	for key, entry := range last {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := o.send(entry)
+			if errors.Is(err, ErrUpstreamRejected) {
+				o.log.Error("📤 Write-behind: %s '%s' dropped: %v", entry.Op, key, err)
+			} else if err != nil {
+				o.log.Debug("Write-behind error for '%s': %v", key, err)
+				resMu.Lock()
+				failed++
+				resMu.Unlock()
+				return
+			}
+			resMu.Lock()
+			for _, id := range ids[key] {
+				acked[id] = true
+			}
+			sent++
+			resMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	o.ack(acked)
+	return sent, failed
+}
+
+// ack пишет подтверждения в журнал и убирает записи из очереди
+func (o *Outbox) ack(acked map[uint64]bool) {
+	if len(acked) == 0 {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rest := o.pending[:0]
+	for _, entry := range o.pending {
+		if !acked[entry.ID] {
+			rest = append(rest, entry)
+			continue
+		}
+		delete(o.ops, entry.ID)
+		if o.latest[entry.Key] == entry.ID {
+			delete(o.latest, entry.Key)
+		}
+	}
+	o.pending = rest
+
+	// Очередь пуста — журнал можно просто обнулить
+	if len(o.pending) == 0 {
+		if err := o.wal.Truncate(); err != nil {
+			o.log.Error("Failed to truncate write-behind journal: %v", err)
+		}
+		return
+	}
+
+	for id := range acked {
+		if err := o.wal.WriteEvent(WALEntry{Op: "ack", ID: id}); err != nil {
+			o.log.Error("Write-behind ack error: %v", err)
+		}
+	}
+}
+
+// Close останавливает фоновую отправку. Неотправленное останется в журнале до следующего старта.
+func (o *Outbox) Close() error {
+	close(o.stop)
+	<-o.done
+	return o.wal.Close()
+}
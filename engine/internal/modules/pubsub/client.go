@@ -1,6 +1,9 @@
 package pubsub
 
 import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -13,25 +16,93 @@ const (
 	pongWait = 60 * time.Second
 	// Как часто слать Ping (должно быть меньше pongWait)
 	pingPeriod = (pongWait * 9) / 10
+	// Макс размер команды без данных (с запасом на тикет)
+	maxMessageSize = 4096
 )
 
 type Client struct {
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan Message // Буферизированный канал для исходящих
-	channels []string     // Список каналов, доступных клиенту
+	hub     *Hub
+	conn    *websocket.Conn // nil у клиентов SSE и long-polling
+	tickets *TicketStore
+	policy  *PublishPolicy
+	send    chan *frame // Буферизированный канал для исходящих (пишут шарды Hub)
+	userID  string
+
+	mu     sync.Mutex // send закрывается один раз, а пишут в него несколько шардов
+	closed bool
+
+	// Бюджет durable доставки, общий для всех durable каналов клиента во всех шардах
+	inflight atomic.Int64 // Отправлено и еще не подтверждено
+	starved  atomic.Bool  // Какому-то каналу не хватило бюджета: досылать после ack
+
+	initial     []string        // Каналы тикета, на которые подписываем при подключении
+	allowed     map[string]bool // Каналы/шаблоны, разрешенные тикетами для подписки (меняет только читатель команд)
+	publishable map[string]bool // Каналы/шаблоны, разрешенные тикетами для публикации (меняет только читатель команд)
+}
+
+// frame — сообщение, сериализованное один раз на всех получателей
+type frame struct {
+	channel string
+	offset  string // Для Last-Event-ID у SSE
+	data    []byte
+}
+
+// push кладет сообщение в буфер. false — буфер переполнен, клиент закрыт и его нужно отключить.
+func (c *Client) push(f *frame) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return true // Уже отключается
+	}
+	select {
+	case c.send <- f:
+		return true
+	default:
+		c.closed = true
+		close(c.send)
+		return false
+	}
+}
+
+// close закрывает send, чтобы остановить writePump (или поток SSE)
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// isClosed — клиент уже отключается
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// command — команда клиента: {"type":"subscribe","id":"1","channel":"room.1"}.
+// Ticket — свежий тикет того же пользователя, если канала нет в исходном.
+type command struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Channel string          `json:"channel"`
+	Ticket  string          `json:"ticket"`
+	Data    json.RawMessage `json:"data"`   // publish, presence
+	Since   *string         `json:"since"`  // subscribe: дослать сообщения после offset ("" — вся история)
+	Offset  string          `json:"offset"` // ack: подтвердить все до этого offset включительно
 }
 
-// readPump слушает входящие сообщения от клиента (Ping/Pong/Close)
-// В Ticket Pattern мы обычно не ожидаем данных от клиента в сокет,
-// но должны читать, чтобы обрабатывать Control Frames.
+// readPump слушает входящие сообщения от клиента: команды и Control Frames
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregister(c)
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512) // Макс размер сообщения (нам нужны только пинги)
+	// Фреймы больше лимита рвут соединение, поэтому оставляем запас:
+	// слишком большую публикацию отклоняем ответом error, а не разрывом
+	c.conn.SetReadLimit(int64(maxMessageSize + 2*c.policy.MaxSize))
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -39,12 +110,126 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		// Читаем, но игнорируем контент (если мы не реализуем чат через сокеты)
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
+
+		var cmd command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			c.hub.submit(request{client: c, err: "bad JSON"})
+			continue
+		}
+		c.hub.submit(c.handle(cmd))
+	}
+}
+
+// handle проверяет команду и превращает ее в запрос к Hub
+func (c *Client) handle(cmd command) request {
+	req := request{client: c, typ: cmd.Type, channel: cmd.Channel, id: cmd.ID, since: cmd.Since}
+	if cmd.Channel == "" && cmd.Type != "" {
+		req.err = "missing channel"
+		return req
+	}
+	if err := ValidateChannel(cmd.Channel, cmd.Type != TypePublish); err != nil && cmd.Type != "" {
+		req.err = err.Error()
+		return req
+	}
+
+	if cmd.Ticket != "" && (cmd.Type == TypeSubscribe || cmd.Type == TypePublish) {
+		if err := c.grant(cmd.Ticket); err != "" {
+			req.err = err
+			return req
+		}
+	}
+
+	switch cmd.Type {
+	case TypeSubscribe:
+		if !permits(c.allowed, cmd.Channel) {
+			req.err = "not allowed"
+		} else if cmd.Since != nil && IsPattern(cmd.Channel) {
+			req.err = "since is not supported for wildcard subscriptions"
+		} else if err := c.hub.checkPattern(cmd.Channel); err != nil {
+			req.err = err.Error()
+		}
+	case TypeUnsubscribe:
+		// Отписка разрешена всегда
+	case TypePublish:
+		req.data, req.err = c.checkPublish(cmd)
+	case TypePresence:
+		// Подписан ли клиент на канал, проверит Hub
+		if len(cmd.Data) > c.policy.MaxSize {
+			req.err = "message too large"
+		} else if len(cmd.Data) > 0 {
+			req.data = cmd.Data
+		}
+	case TypeMembers:
+		if !permits(c.allowed, cmd.Channel) {
+			req.err = "not allowed"
+		}
+	case TypeAck:
+		offset, err := ParseOffset(cmd.Offset)
+		if err != nil {
+			req.err = err.Error()
+		}
+		req.data = offset
+	default:
+		req.err = "unknown command type"
+	}
+	return req
+}
+
+// checkPublish проверяет право, размер и (если задан) внешний хук публикации
+func (c *Client) checkPublish(cmd command) (any, string) {
+	if !permits(c.publishable, cmd.Channel) {
+		return nil, "publish not allowed"
+	}
+	if len(cmd.Data) == 0 {
+		return nil, "missing data"
+	}
+	if len(cmd.Data) > c.policy.MaxSize {
+		return nil, "message too large"
+	}
+	if c.policy.Hook == nil {
+		return cmd.Data, ""
+	}
+
+	data, err := c.policy.Hook.Check(c.userID, cmd.Channel, cmd.Data)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return data, ""
+}
+
+// permits — разрешен ли канал (или шаблон) хотя бы одним из разрешений тикетов
+func permits(grants map[string]bool, channel string) bool {
+	if grants[channel] {
+		return true
+	}
+	for grant := range grants {
+		if Covers(grant, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// grant добавляет каналы из свежего тикета. Тикет должен быть выписан тому же пользователю.
+func (c *Client) grant(token string) string {
+	info, ok := c.tickets.Validate(token)
+	if !ok {
+		return "invalid or expired ticket"
+	}
+	if info.UserID != c.userID {
+		return "ticket belongs to another user"
+	}
+	for _, ch := range info.Channels {
+		c.allowed[ch] = true
+	}
+	for _, ch := range info.Publish {
+		c.publishable[ch] = true
 	}
+	return ""
 }
 
 // writePump отправляет сообщения из канала send в сокет
@@ -57,7 +242,7 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case msg, ok := <-c.send:
+		case f, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// Hub закрыл канал
@@ -65,8 +250,8 @@ func (c *Client) writePump() {
 				return
 			}
 
-			// Пишем JSON
-			if err := c.conn.WriteJSON(msg); err != nil {
+			// JSON уже готов: один и тот же для всех подписчиков
+			if err := c.conn.WriteMessage(websocket.TextMessage, f.data); err != nil {
 				return
 			}
 
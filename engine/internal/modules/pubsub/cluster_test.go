@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nexus-engine/internal/pkg/cluster"
+	"nexus-engine/internal/pkg/logger"
+)
+
+// startHub поднимает Hub с узлом кластера на httptest.Server, как Module.Init
+func startHub(t *testing.T, id string, seeds ...string) (*Hub, *cluster.Node, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	log := logger.New(logger.LevelError)
+	var hub *Hub
+	node := cluster.New(cluster.Config{
+		ID:             id,
+		Addr:           srv.URL,
+		Seeds:          seeds,
+		Secret:         "s3cret",
+		GossipInterval: 20 * time.Millisecond,
+		Pattern:        IsPattern,
+		Covers:         Covers,
+		Interests:      func() []string { return hub.Interests() },
+		Deliver: func(channel string, data json.RawMessage) error {
+			return hub.Publish(Message{Channel: channel, Data: data, remote: true})
+		},
+		Log: log,
+	})
+	hub = NewHub(log, HubOptions{Shards: 4, QueueSize: 64, Cluster: node})
+	mux.Handle("/cluster/", node.Handler())
+
+	go hub.Run()
+	node.Start()
+	t.Cleanup(node.Stop)
+	return hub, node, srv.URL
+}
+
+// interested — знает ли n, что у узла id есть подписчики channel
+func interested(n *cluster.Node, id, channel string) bool {
+	for _, s := range n.Members() {
+		if s.ID != id || !s.Live {
+			continue
+		}
+		for _, p := range s.Interests {
+			if p == channel || Covers(p, channel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestClusterPublishReachesOtherNode(t *testing.T) {
+	tests := []struct {
+		name      string
+		subscribe string
+		publish   string
+	}{
+		{"channel", "room.1", "room.1"},
+		{"pattern", "orders.>", "orders.eu.42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, nodeA, urlA := startHub(t, "a")
+			b, _, _ := startHub(t, "b", urlA)
+
+			client := &Client{hub: b, send: make(chan *frame, 256), initial: []string{tt.subscribe}}
+			b.register(client)
+			defer b.unregister(client)
+
+			deadline := time.Now().Add(3 * time.Second)
+			for !interested(nodeA, "b", tt.publish) {
+				if time.Now().After(deadline) {
+					t.Fatal("a did not learn b's interest")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if err := a.Publish(Message{Channel: tt.publish, Data: json.RawMessage(`{"n":1}`)}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case f := <-client.send:
+				var msg struct {
+					Channel string          `json:"channel"`
+					Data    json.RawMessage `json:"data"`
+				}
+				if err := json.Unmarshal(f.data, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.Channel != tt.publish || string(msg.Data) != `{"n":1}` {
+					t.Fatalf("got %s", f.data)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("message did not reach the subscriber on b")
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"nexus-engine/internal/pkg/journal"
+	"nexus-engine/internal/pkg/logger"
+)
+
+// Сколько неподтвержденных durable сообщений (всех каналов вместе) держим в полете на соединение.
+// Остальные досылаются по мере ack, поэтому бэклог не переполняет буфер клиента (send — 256).
+const durableWindow = 64
+
+// ErrDurablePattern — шаблонная подписка пересекается с durable каналами
+var ErrDurablePattern = errors.New("wildcard subscriptions cannot cover durable channels")
+
+// Записи журнала durable каналов
+const (
+	recordChannel = "chan" // Канал создан (epoch)
+	recordMessage = "msg"  // Сообщение
+	recordCursor  = "ack"  // Курсор пользователя: все до seq подтверждено
+)
+
+type durableRecord struct {
+	Kind    string          `json:"t"`
+	Channel string          `json:"ch"`
+	Epoch   int64           `json:"epoch,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	At      int64           `json:"at,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	User    string          `json:"user,omitempty"`
+}
+
+// durableChannel — сохраненные сообщения канала и курсоры его пользователей
+type durableChannel struct {
+	channelHistory
+	cursors map[string]uint64 // user -> последний подтвержденный seq
+}
+
+// durable — каналы с доставкой at-least-once: сообщения пишутся в журнал,
+// пользователи подтверждают их ack, неподтвержденное досылается при переподключении.
+// У каждого шарда Hub свой durable со своими каналами, без локов (журнал общий, сам под локом).
+type durable struct {
+	rules    []HistoryRule
+	channels map[string]*durableChannel
+	journal  *journal.Journal
+	log      *logger.Logger
+}
+
+func openDurable(path string, rules []HistoryRule, log *logger.Logger) (*durable, error) {
+	d := &durable{rules: rules, channels: make(map[string]*durableChannel), log: log}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := journal.Replay(path, d.apply); err != nil {
+		return nil, err
+	}
+
+	// Каналы, которые больше не подходят ни под одно правило, забываем
+	for name, ch := range d.channels {
+		rule, ok := d.rule(name)
+		if !ok {
+			delete(d.channels, name)
+			continue
+		}
+		ch.rule = rule
+		ch.evict(time.Now().UnixMilli())
+	}
+
+	j, err := journal.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d.journal = j
+	log.Info("📬 Durable channels enabled: %s (%d channels)", path, len(d.channels))
+
+	if err := d.compact(); err != nil {
+		log.Error("Durable channels compaction failed: %v", err)
+	}
+	return d, nil
+}
+
+func (d *durable) apply(raw json.RawMessage) error {
+	var r durableRecord
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil // Пропускаем мусор
+	}
+
+	ch := d.channels[r.Channel]
+	if ch == nil && r.Kind != recordChannel {
+		return nil
+	}
+	switch r.Kind {
+	case recordChannel:
+		d.channels[r.Channel] = &durableChannel{
+			channelHistory: channelHistory{epoch: r.Epoch, seq: r.Seq},
+			cursors:        make(map[string]uint64),
+		}
+	case recordMessage:
+		ch.entries = append(ch.entries, historyEntry{seq: r.Seq, at: r.At, data: r.Data})
+		ch.seq = max(ch.seq, r.Seq)
+	case recordCursor:
+		ch.cursors[r.User] = r.Seq
+	}
+	return nil
+}
+
+func (d *durable) rule(name string) (HistoryRule, bool) {
+	for _, r := range d.rules {
+		if Covers(r.Pattern, name) {
+			return r, true
+		}
+	}
+	return HistoryRule{}, false
+}
+
+// channel — durable канал по имени (создается при первом обращении). nil — канал не durable.
+func (d *durable) channel(name string) *durableChannel {
+	if ch, ok := d.channels[name]; ok {
+		return ch
+	}
+	rule, ok := d.rule(name)
+	if !ok {
+		return nil
+	}
+
+	ch := &durableChannel{
+		channelHistory: channelHistory{rule: rule, epoch: time.Now().UnixMilli()},
+		cursors:        make(map[string]uint64),
+	}
+	d.channels[name] = ch
+	d.append(durableRecord{Kind: recordChannel, Channel: name, Epoch: ch.epoch})
+	return ch
+}
+
+// store сохраняет сообщение и проставляет ему offset
+func (d *durable) store(ch *durableChannel, msg *Message) {
+	e := ch.append(msg)
+	data, err := json.Marshal(e.data)
+	if err != nil {
+		d.log.Error("Durable message for '%s' is not JSON: %v", msg.Channel, err)
+		return
+	}
+	d.append(durableRecord{Kind: recordMessage, Channel: msg.Channel, Seq: e.seq, At: e.at, Data: data})
+}
+
+// cursor — до какого seq пользователь подтвердил канал. Новый пользователь
+// начинает с текущего конца: старые сообщения канала ему не досылаются.
+func (d *durable) cursor(name string, ch *durableChannel, user string) uint64 {
+	if seq, ok := ch.cursors[user]; ok {
+		return seq
+	}
+	ch.cursors[user] = ch.seq
+	d.append(durableRecord{Kind: recordCursor, Channel: name, User: user, Seq: ch.seq})
+	return ch.seq
+}
+
+// ack сдвигает курсор пользователя (подтверждение кумулятивное: все до offset включительно)
+func (d *durable) ack(name string, ch *durableChannel, user string, offset Offset) error {
+	if offset.Epoch != ch.epoch || offset.Seq > ch.seq {
+		return fmt.Errorf("unknown offset %s", offset)
+	}
+	if offset.Seq <= ch.cursors[user] {
+		return nil
+	}
+	ch.cursors[user] = offset.Seq
+	d.append(durableRecord{Kind: recordCursor, Channel: name, User: user, Seq: offset.Seq})
+	return nil
+}
+
+// next — до count записей после seq. gap — часть уже выкинута по лимитам хранения.
+func (ch *durableChannel) next(after uint64, count int) (entries []historyEntry, gap bool) {
+	ch.evict(time.Now().UnixMilli())
+	i := sort.Search(len(ch.entries), func(i int) bool { return ch.entries[i].seq > after })
+	if i < len(ch.entries) && ch.entries[i].seq > after+1 {
+		gap = true
+	}
+	if i == len(ch.entries) && after < ch.seq {
+		gap = true // Все, что после курсора, уже выкинуто
+	}
+	end := min(len(ch.entries), i+count)
+	return ch.entries[i:end], gap
+}
+
+func (d *durable) append(r durableRecord) {
+	if err := d.journal.Append(r); err != nil {
+		d.log.Error("Durable channels journal error: %v", err)
+	}
+}
+
+// records — компактное состояние: каналы, их сообщения в пределах лимитов и курсоры
+func (d *durable) records() []any {
+	var records []any
+	now := time.Now().UnixMilli()
+	for name, ch := range d.channels {
+		ch.evict(now)
+		// seq нужен, если все сообщения уже выкинуты: offset не должен начаться заново
+		records = append(records, durableRecord{Kind: recordChannel, Channel: name, Epoch: ch.epoch, Seq: ch.seq})
+		for _, e := range ch.entries {
+			data, _ := json.Marshal(e.data)
+			records = append(records, durableRecord{Kind: recordMessage, Channel: name, Seq: e.seq, At: e.at, Data: data})
+		}
+		for user, seq := range ch.cursors {
+			records = append(records, durableRecord{Kind: recordCursor, Channel: name, User: user, Seq: seq})
+		}
+	}
+	return records
+}
+
+// compact переписывает журнал компактным состоянием
+func (d *durable) compact() error {
+	return d.journal.Rewrite(d.records())
+}
+
+// split раздает каналы по шардам Hub. Журнал общий (он сам под локом),
+// компакцию делает Hub, остановив все шарды.
+func (d *durable) split(n int, index func(string) int) []*durable {
+	parts := make([]*durable, n)
+	for i := range parts {
+		parts[i] = &durable{rules: d.rules, channels: make(map[string]*durableChannel), journal: d.journal, log: d.log}
+	}
+	for name, ch := range d.channels {
+		parts[index(name)].channels[name] = ch
+	}
+	return parts
+}
+
+// durableChannel — durable канал по имени (nil — канал не durable или durable выключены)
+func (s *shard) durableChannel(name string) *durableChannel {
+	if s.durable == nil || IsPattern(name) {
+		return nil
+	}
+	return s.durable.channel(name)
+}
+
+// findDurable — durable канал без создания (для чтения). ok — канал подходит под правило,
+// даже если в него еще ничего не публиковали (тогда ch == nil).
+func (s *shard) findDurable(name string) (ch *durableChannel, ok bool) {
+	if s.durable == nil || IsPattern(name) {
+		return nil, false
+	}
+	if ch, ok = s.durable.channels[name]; ok {
+		return ch, true
+	}
+	_, ok = s.durable.rule(name)
+	return nil, ok
+}
+
+// checkPattern запрещает шаблонные подписки, которые ловят durable каналы: durable сообщения
+// доставляются по курсору пользователя и подтверждаются ack только в подписке на сам канал
+func (h *Hub) checkPattern(channel string) error {
+	if h.durable == nil || !IsPattern(channel) {
+		return nil
+	}
+	for _, r := range h.durable.rules {
+		if Overlaps(channel, r.Pattern) {
+			return fmt.Errorf("%w ('%s')", ErrDurablePattern, r.Pattern)
+		}
+	}
+	return nil
+}
+
+// pump досылает клиенту сообщения durable канала по порядку. Окно — общее на все durable
+// каналы клиента: канал, которому его не хватило, дошлется после ack в любом канале.
+func (s *shard) pump(client *Client, name string) {
+	sub := s.clients[client]
+	if sub == nil {
+		return
+	}
+	sent, ok := sub.durable[name]
+	dc, _ := s.findDurable(name)
+	if !ok || dc == nil {
+		return
+	}
+
+	cursor := dc.cursors[client.userID]
+	sent = max(sent, cursor) // Другое устройство пользователя могло подтвердить больше
+	s.charge(client, sub, name, sent-cursor)
+	free := durableWindow - client.inflight.Load()
+	if free <= 0 {
+		if sent < dc.seq {
+			client.starved.Store(true)
+		}
+		return
+	}
+
+	entries, gap := dc.next(sent, int(free))
+	if gap {
+		// Выкинутое по лимитам уже не доставить: сообщаем и сдвигаем курсор, чтобы не сообщать снова
+		skipTo := dc.seq
+		if len(entries) > 0 {
+			skipTo = entries[0].seq - 1
+		}
+		s.durable.ack(name, dc, client.userID, Offset{Epoch: dc.epoch, Seq: skipTo})
+		sent = max(sent, skipTo)
+		s.deliver(client, Message{Type: TypeGap, Channel: name, Offset: Offset{Epoch: dc.epoch, Seq: skipTo}.String()})
+	}
+
+	for _, e := range entries {
+		s.deliver(client, dc.message(name, e))
+		sent = e.seq
+	}
+	sub.durable[name] = sent
+	s.charge(client, sub, name, sent-dc.cursors[client.userID])
+	if sent < dc.seq && len(entries) == int(free) {
+		client.starved.Store(true)
+	}
+}
+
+// charge — сколько сообщений канала сейчас в полете (доля канала в окне клиента)
+func (s *shard) charge(client *Client, sub *subscriber, name string, n uint64) {
+	client.inflight.Add(int64(n) - int64(sub.inflight[name]))
+	if n == 0 {
+		delete(sub.inflight, name)
+	} else {
+		sub.inflight[name] = n
+	}
+}
+
+// refill досылает durable каналы клиента во всех шардах: ack освободил место в окне
+func (h *Hub) refill(client *Client) {
+	h.each(func(s *shard) {
+		if sub := s.clients[client]; sub != nil {
+			for ch := range sub.durable {
+				s.pump(client, ch)
+			}
+		}
+	})
+}
+
+// ack — подтверждение клиента: сдвигаем курсор пользователя и досылаем следующие
+func (s *shard) ack(req request) error {
+	if _, ok := s.clients[req.client].durable[req.channel]; !ok {
+		return fmt.Errorf("not subscribed to a durable channel")
+	}
+	dc, _ := s.findDurable(req.channel)
+	if err := s.durable.ack(req.channel, dc, req.client.userID, req.data.(Offset)); err != nil {
+		return err
+	}
+	starved := req.client.starved.Swap(false)
+	s.pump(req.client, req.channel)
+	if starved {
+		// Каналы в других шардах: не из горутины шарда, иначе шарды ждали бы друг друга
+		go s.hub.refill(req.client)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryRule — сколько хранить прошлых сообщений каналов, подходящих под шаблон
+type HistoryRule struct {
+	Pattern  string
+	MaxCount int           // 0 — без лимита по количеству
+	MaxAge   time.Duration // 0 — без лимита по возрасту
+}
+
+// ParseHistoryRules разбирает флаг вида "chat.*=100,orders.>=5m,feed=1000/1h"
+func ParseHistoryRules(spec string) ([]HistoryRule, error) {
+	var rules []HistoryRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pattern, limits, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad history rule %q: expected channel=limit", part)
+		}
+		if err := ValidateChannel(pattern, true); err != nil {
+			return nil, fmt.Errorf("bad history rule %q: %v", part, err)
+		}
+
+		rule := HistoryRule{Pattern: pattern}
+		for _, limit := range strings.Split(limits, "/") {
+			if n, err := strconv.Atoi(limit); err == nil {
+				rule.MaxCount = n
+				continue
+			}
+			d, err := time.ParseDuration(limit)
+			if err != nil {
+				return nil, fmt.Errorf("bad history limit %q: expected count or duration", limit)
+			}
+			rule.MaxAge = d
+		}
+		if rule.MaxCount <= 0 && rule.MaxAge <= 0 {
+			return nil, fmt.Errorf("bad history rule %q: needs a count or an age limit", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Offset — позиция сообщения в канале: "epoch-seq". Epoch — момент, когда Engine
+// начал вести историю канала (после рестарта он другой), seq растет на 1 с каждым сообщением.
+type Offset struct {
+	Epoch int64
+	Seq   uint64
+}
+
+func (o Offset) String() string {
+	return strconv.FormatInt(o.Epoch, 10) + "-" + strconv.FormatUint(o.Seq, 10)
+}
+
+func ParseOffset(s string) (Offset, error) {
+	epoch, seq, ok := strings.Cut(s, "-")
+	e, err1 := strconv.ParseInt(epoch, 10, 64)
+	n, err2 := strconv.ParseUint(seq, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return Offset{}, fmt.Errorf("bad offset %q", s)
+	}
+	return Offset{Epoch: e, Seq: n}, nil
+}
+
+type historyEntry struct {
+	seq  uint64
+	at   int64 // Unix ms
+	data any
+}
+
+// channelHistory — последние сообщения одного канала
+type channelHistory struct {
+	rule    HistoryRule
+	epoch   int64
+	seq     uint64 // Offset последнего сообщения
+	entries []historyEntry
+}
+
+// history — история каналов с включенными правилами. У каждого шарда Hub своя, без локов.
+type history struct {
+	rules    []HistoryRule
+	channels map[string]*channelHistory
+}
+
+func newHistory(rules []HistoryRule) *history {
+	return &history{rules: rules, channels: make(map[string]*channelHistory)}
+}
+
+// rule — первое подходящее правило (в порядке флага)
+func (h *history) rule(ch string) (HistoryRule, bool) {
+	for _, r := range h.rules {
+		if Covers(r.Pattern, ch) {
+			return r, true
+		}
+	}
+	return HistoryRule{}, false
+}
+
+// record сохраняет сообщение и проставляет ему offset (если для канала ведется история)
+func (h *history) record(msg *Message) {
+	ch, ok := h.channels[msg.Channel]
+	if !ok {
+		rule, ok := h.rule(msg.Channel)
+		if !ok {
+			return
+		}
+		ch = &channelHistory{rule: rule, epoch: time.Now().UnixMilli()}
+		h.channels[msg.Channel] = ch
+	}
+
+	ch.append(msg)
+}
+
+// append добавляет сообщение в конец и проставляет ему offset
+func (ch *channelHistory) append(msg *Message) historyEntry {
+	ch.seq++
+	e := historyEntry{seq: ch.seq, at: time.Now().UnixMilli(), data: msg.Data}
+	ch.entries = append(ch.entries, e)
+	ch.evict(e.at)
+	msg.Offset = ch.last()
+	return e
+}
+
+// evict выкидывает сообщения сверх лимитов
+func (ch *channelHistory) evict(now int64) {
+	drop := 0
+	if ch.rule.MaxCount > 0 && len(ch.entries) > ch.rule.MaxCount {
+		drop = len(ch.entries) - ch.rule.MaxCount
+	}
+	if ch.rule.MaxAge > 0 {
+		cutoff := now - ch.rule.MaxAge.Milliseconds()
+		for drop < len(ch.entries) && ch.entries[drop].at < cutoff {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	ch.entries = ch.entries[drop:]
+	// Срез головы не освобождает память массива: периодически переносим в новый
+	if cap(ch.entries) > 2*len(ch.entries)+64 {
+		ch.entries = append([]historyEntry(nil), ch.entries...)
+	}
+}
+
+// Replay — ответ на "все после offset"
+type Replay struct {
+	Messages []Message `json:"messages"`
+	ResumeInfo
+}
+
+// ResumeInfo — итог восстановления (в ack подписки с since приходит без самих сообщений)
+type ResumeInfo struct {
+	// Gap — часть сообщений после since уже не хранится (или история началась заново
+	// после рестарта): клиенту нужно перечитать состояние целиком
+	Gap bool `json:"gap"`
+	// More — выдано не все (limit): продолжить с offset последнего полученного
+	More bool `json:"more,omitempty"`
+	// Last — offset последнего сообщения канала ("" — сообщений еще не было)
+	Last string `json:"last,omitempty"`
+}
+
+// since — сообщения канала после offset (пустой since — все, что хранится)
+func (h *history) since(channel, since string, limit int) (Replay, error) {
+	if _, ok := h.rule(channel); !ok {
+		return Replay{Messages: []Message{}}, fmt.Errorf("history is not enabled for channel %q", channel)
+	}
+	from, err := parseSince(since)
+	if err != nil {
+		return Replay{Messages: []Message{}}, err
+	}
+
+	ch, ok := h.channels[channel]
+	if !ok {
+		// Сообщений не было с момента старта: если клиент что-то видел, это было до рестарта
+		return Replay{Messages: []Message{}, ResumeInfo: ResumeInfo{Gap: from != nil}}, nil
+	}
+	return ch.replay(channel, from, limit), nil
+}
+
+// parseSince — nil для пустого since (вся история)
+func parseSince(since string) (*Offset, error) {
+	if since == "" {
+		return nil, nil
+	}
+	from, err := ParseOffset(since)
+	if err != nil {
+		return nil, err
+	}
+	return &from, nil
+}
+
+// last — offset последнего сообщения канала
+func (ch *channelHistory) last() string {
+	return Offset{Epoch: ch.epoch, Seq: ch.seq}.String()
+}
+
+// message — сохраненная запись в виде сообщения канала
+func (ch *channelHistory) message(channel string, e historyEntry) Message {
+	return Message{Channel: channel, Data: e.data, Offset: Offset{Epoch: ch.epoch, Seq: e.seq}.String()}
+}
+
+// replay — сообщения после from (nil — все), не больше limit (0 — без лимита)
+func (ch *channelHistory) replay(channel string, from *Offset, limit int) Replay {
+	ch.evict(time.Now().UnixMilli())
+	replay := Replay{Messages: []Message{}, ResumeInfo: ResumeInfo{Last: ch.last()}}
+
+	var after uint64
+	switch {
+	case from == nil:
+	case from.Epoch != ch.epoch || from.Seq > ch.seq:
+		replay.Gap = true // Offset из прошлой жизни канала
+	case len(ch.entries) == 0:
+		replay.Gap, after = from.Seq < ch.seq, from.Seq
+	default:
+		replay.Gap, after = from.Seq+1 < ch.entries[0].seq, from.Seq
+	}
+
+	for _, e := range ch.entries {
+		if e.seq <= after {
+			continue
+		}
+		if limit > 0 && len(replay.Messages) >= limit {
+			replay.More = true
+			break
+		}
+		replay.Messages = append(replay.Messages, ch.message(channel, e))
+	}
+	return replay
+}
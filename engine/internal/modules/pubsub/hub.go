@@ -1,92 +1,384 @@
 package pubsub
 
 import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-engine/internal/pkg/cluster"
 	"nexus-engine/internal/pkg/logger"
 )
 
-// Message — внутренняя структура сообщения
+// Типы служебных сообщений протокола сокета
+const (
+	TypeSubscribe   = "subscribe"   // Клиент -> сервер
+	TypeUnsubscribe = "unsubscribe" // Клиент -> сервер
+	TypePublish     = "publish"     // Клиент -> сервер
+	TypePresence    = "presence"    // Клиент -> сервер: метаданные; сервер -> клиент: join/leave/update
+	TypeMembers     = "members"     // Клиент -> сервер: кто в канале (ответ — ack с data)
+	TypeAck         = "ack"         // Сервер -> клиент: команда выполнена; клиент -> сервер: подтверждение durable сообщения
+	TypeError       = "error"       // Сервер -> клиент: команда отклонена
+	TypeGap         = "gap"         // Сервер -> клиент: часть сообщений durable канала выкинута по лимитам
+)
+
+// Message — внутренняя структура сообщения. Обычные сообщения канала идут без type
+// (и с offset, если для канала ведется история), ответы на команды клиента — с type и id команды.
 type Message struct {
+	Type    string `json:"type,omitempty"`
+	ID      string `json:"id,omitempty"`
 	Channel string `json:"channel"`
-	Data    any    `json:"data"`
+	Data    any    `json:"data,omitempty"`
+	Offset  string `json:"offset,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	remote bool // Пришло с другого узла кластера: дальше не пересылаем
+}
+
+// request — проверенная команда клиента (или отказ, который нужно отправить)
+type request struct {
+	client  *Client
+	typ     string
+	channel string
+	id      string
+	data    any     // publish, presence, ack (Offset)
+	since   *string // subscribe: дослать историю после offset
+	err     string
 }
 
+// ErrBackpressure — очередь шарда полна: публикацию стоит повторить позже
+var ErrBackpressure = errors.New("pubsub is overloaded, retry later")
+
+// Hub раскладывает каналы по шардам (по хэшу имени): у каждого шарда своя горутина,
+// поэтому тысячи подписчиков одного канала не задерживают остальные каналы.
+// Порядок сообщений сохраняется внутри канала.
 type Hub struct {
-	// Зарегистрированные клиенты
-	clients map[*Client]bool
+	shards []*shard
+
+	// Durable каналы: общий журнал и правила (каналы — у шардов)
+	durable         *durable
+	compactInterval time.Duration
+
+	// Кластер (nil — один узел) и интерес этого узла: сколько подписок
+	// во всех шардах на каждый канал и шаблон
+	cluster    *cluster.Node
+	interestMu sync.Mutex
+	interests  map[string]int
 
-	// Подписки: channel -> map[client]bool
-	subscriptions map[string]map[*Client]bool
+	// Компакция и закрытие останавливают все шарды: по одному за раз
+	pauseMu sync.Mutex
+	closed  bool
 
-	// Каналы управления (Action Channels)
-	broadcast  chan Message
-	register   chan *Client
-	unregister chan *Client
+	queueSize   int
+	published   atomic.Uint64
+	rejected    atomic.Uint64
+	slowClients atomic.Uint64
 
 	log *logger.Logger
 }
 
-func NewHub(log *logger.Logger) *Hub {
-	return &Hub{
-		broadcast:     make(chan Message),
-		register:      make(chan *Client),
-		unregister:    make(chan *Client),
-		clients:       make(map[*Client]bool),
-		subscriptions: make(map[string]map[*Client]bool),
-		log:           log,
+// HubOptions — что включено в Hub
+type HubOptions struct {
+	Shards          int // Сколько горутин делят каналы
+	QueueSize       int // Очередь публикаций на шард
+	Presence        bool
+	History         []HistoryRule
+	Durable         *durable
+	CompactInterval time.Duration // Компакция журнала durable каналов
+	Cluster         *cluster.Node // Пересылка публикаций на другие узлы
+}
+
+func NewHub(log *logger.Logger, opts HubOptions) *Hub {
+	h := &Hub{
+		shards:    make([]*shard, max(1, opts.Shards)),
+		durable:   opts.Durable,
+		cluster:   opts.Cluster,
+		interests: make(map[string]int),
+		queueSize: max(1, opts.QueueSize),
+		log:       log,
+
+		compactInterval: opts.CompactInterval,
+	}
+
+	var durables []*durable
+	if h.durable != nil {
+		durables = h.durable.split(len(h.shards), h.index)
+	}
+	for i := range h.shards {
+		s := &shard{
+			hub:           h,
+			clients:       make(map[*Client]*subscriber),
+			subscriptions: newSubTrie(),
+			queue:         make(chan Message, h.queueSize),
+			requests:      make(chan request),
+			calls:         make(chan func()),
+		}
+		if opts.Presence {
+			s.presence = newPresence()
+		}
+		if len(opts.History) > 0 {
+			s.history = newHistory(opts.History)
+		}
+		if durables != nil {
+			s.durable = durables[i]
+		}
+		h.shards[i] = s
 	}
+	return h
+}
+
+// index — номер шарда канала
+func (h *Hub) index(ch string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(ch))
+	return int(hash.Sum32() % uint32(len(h.shards)))
 }
 
-// Run запускает главный цикл обработки событий (Event Loop)
+func (h *Hub) shard(ch string) *shard {
+	return h.shards[h.index(ch)]
+}
+
+// each выполняет fn в каждом шарде по очереди и ждет завершения
+func (h *Hub) each(fn func(s *shard)) {
+	for _, s := range h.shards {
+		s.call(func() { fn(s) })
+	}
+}
+
+// pause останавливает все шарды, выполняет fn и отпускает их
+func (h *Hub) pause(fn func()) {
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+
+	parked := make(chan struct{}, len(h.shards))
+	release := make(chan struct{})
+	for _, s := range h.shards {
+		s.calls <- func() {
+			parked <- struct{}{}
+			<-release
+		}
+	}
+	for range h.shards {
+		<-parked
+	}
+	fn()
+	close(release)
+}
+
+// Run запускает шарды и периодическую компакцию журнала durable каналов
 func (h *Hub) Run() {
-	for {
-		select {
-		// 1. Подключение нового клиента
-		case client := <-h.register:
-			h.clients[client] = true
-			// Подписываем клиента на его разрешенные каналы
-			for _, ch := range client.channels {
-				if _, ok := h.subscriptions[ch]; !ok {
-					h.subscriptions[ch] = make(map[*Client]bool)
-				}
-				h.subscriptions[ch][client] = true
-				h.log.Debug("Client subscribed to '%s'", ch)
-			}
+	for _, s := range h.shards {
+		go s.run()
+	}
+	if h.durable == nil {
+		return
+	}
 
-		// 2. Отключение клиента
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				h.removeClient(client)
+	ticker := time.NewTicker(h.compactInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.pause(func() {
+			if h.closed {
+				return
 			}
-
-		// 3. Рассылка сообщения
-		case msg := <-h.broadcast:
-			clients, ok := h.subscriptions[msg.Channel]
-			if !ok || len(clients) == 0 {
-				continue // Нет подписчиков
+			if err := h.compact(); err != nil {
+				h.log.Error("Durable channels compaction failed: %v", err)
 			}
+		})
+	}
+}
 
-			for client := range clients {
-				select {
-				case client.send <- msg:
-				default:
-					// Если буфер клиента переполнен (он завис), отключаем его,
-					// чтобы не блокировать остальных
-					h.removeClient(client)
-				}
-			}
+// compact переписывает журнал durable каналов всех шардов (шарды стоят)
+func (h *Hub) compact() error {
+	var records []any
+	for _, s := range h.shards {
+		records = append(records, s.durable.records()...)
+	}
+	return h.durable.journal.Rewrite(records)
+}
+
+// Close сохраняет durable каналы
+func (h *Hub) Close() {
+	if h.durable == nil {
+		return
+	}
+	h.pause(func() {
+		if h.closed {
+			return
+		}
+		h.closed = true
+		if err := h.compact(); err != nil {
+			h.log.Error("Durable channels compaction failed: %v", err)
+		}
+		h.durable.journal.Close()
+		for _, s := range h.shards {
+			s.durable = nil
+		}
+	})
+}
+
+// Publish ставит сообщение в очередь шарда канала и не ждет рассылки.
+// Очередь полна — ErrBackpressure.
+func (h *Hub) Publish(msg Message) error {
+	select {
+	case h.shard(msg.Channel).queue <- msg:
+		return nil
+	default:
+		h.rejected.Add(1)
+		return ErrBackpressure
+	}
+}
+
+// Members — текущие участники канала
+func (h *Hub) Members(ch string) []Member {
+	members := []Member{}
+	s := h.shard(ch)
+	s.call(func() {
+		if s.presence != nil {
+			members = s.presence.members(ch)
 		}
+	})
+	return members
+}
+
+// History — сообщения канала после offset since
+func (h *Hub) History(ch, since string, limit int) (Replay, error) {
+	var (
+		replay Replay
+		err    error
+	)
+	s := h.shard(ch)
+	s.call(func() {
+		replay, err = s.replay(ch, since, limit)
+	})
+	return replay, err
+}
+
+// HubStats — нагрузка на Hub
+type HubStats struct {
+	Shards      int    `json:"shards"`
+	Clients     int    `json:"clients"`
+	Queued      []int  `json:"queued"` // Публикаций в очереди каждого шарда
+	QueueSize   int    `json:"queue_size"`
+	Published   uint64 `json:"published"`
+	Rejected    uint64 `json:"rejected"`     // Отклонено: очередь шарда была полна
+	SlowClients uint64 `json:"slow_clients"` // Отключено: буфер клиента был полон
+}
+
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		Shards:      len(h.shards),
+		Queued:      make([]int, len(h.shards)),
+		QueueSize:   h.queueSize,
+		Published:   h.published.Load(),
+		Rejected:    h.rejected.Load(),
+		SlowClients: h.slowClients.Load(),
+	}
+	for i, s := range h.shards {
+		stats.Queued[i] = len(s.queue)
+	}
+	// Клиенты зарегистрированы в каждом шарде
+	s := h.shards[0]
+	s.call(func() { stats.Clients = len(s.clients) })
+	return stats
+}
+
+// interest учитывает подписку (+1) или отписку (-1) в шарде. Кластеру сообщаем,
+// только когда канал (шаблон) появился у узла или пропал совсем.
+func (h *Hub) interest(ch string, delta int) {
+	if h.cluster == nil {
+		return
+	}
+	h.interestMu.Lock()
+	before := h.interests[ch]
+	after := before + delta
+	if after > 0 {
+		h.interests[ch] = after
+	} else {
+		delete(h.interests, ch)
+	}
+	h.interestMu.Unlock()
+
+	if (before == 0) != (after <= 0) {
+		h.cluster.InterestsChanged()
 	}
 }
 
-func (h *Hub) removeClient(client *Client) {
-	delete(h.clients, client)
-	close(client.send) // Закрываем канал, чтобы остановить writePump
+// Interests — каналы и шаблоны, на которые у узла есть подписчики
+func (h *Hub) Interests() []string {
+	h.interestMu.Lock()
+	defer h.interestMu.Unlock()
+	out := make([]string, 0, len(h.interests))
+	for ch := range h.interests {
+		out = append(out, ch)
+	}
+	return out
+}
 
-	// Удаляем из всех подписок
-	for _, ch := range client.channels {
-		delete(h.subscriptions[ch], client)
-		if len(h.subscriptions[ch]) == 0 {
-			delete(h.subscriptions, ch) // Чистим память, если канал пуст
+// register подключает клиента ко всем шардам: каналы тикета — в свои шарды, шаблоны — во все
+func (h *Hub) register(client *Client) {
+	for i, s := range h.shards {
+		var channels []string
+		for _, ch := range client.initial {
+			if IsPattern(ch) || h.index(ch) == i {
+				channels = append(channels, ch)
+			}
 		}
+		s.call(func() { s.register(client, channels) })
+	}
+}
+
+// unregister отключает клиента: закрывает send и снимает подписки во всех шардах
+func (h *Hub) unregister(client *Client) {
+	client.close()
+	h.each(func(s *shard) { s.removeClient(client) })
+}
+
+// submit отправляет команду клиента в шард канала. Шаблоны живут во всех шардах.
+func (h *Hub) submit(req request) {
+	reply := Message{Type: TypeAck, ID: req.id, Channel: req.channel}
+	switch {
+	case req.err != "":
+		reply.Type, reply.Error = TypeError, req.err
+	case req.typ == TypeSubscribe && IsPattern(req.channel):
+		// ack раньше, чем какой-нибудь шард успеет прислать сообщение по шаблону
+		h.send(req.client, reply)
+		h.each(func(s *shard) { s.add(req.client, req.channel) })
+		return
+	case req.typ == TypeUnsubscribe && IsPattern(req.channel):
+		h.each(func(s *shard) { s.remove(req.client, req.channel) })
+	default:
+		h.shard(req.channel).requests <- req
+		return
+	}
+	h.send(req.client, reply)
+}
+
+// encode сериализует сообщение (nil — не сериализуется)
+func (h *Hub) encode(msg Message) *frame {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		h.log.Error("Message for '%s' is not JSON: %v", msg.Channel, err)
+		return nil
+	}
+	return &frame{channel: msg.Channel, offset: msg.Offset, data: data}
+}
+
+// send отправляет одно сообщение клиенту
+func (h *Hub) send(client *Client, msg Message) {
+	h.push(client, h.encode(msg))
+}
+
+// push кладет сообщение в буфер клиента. Если буфер переполнен (клиент завис),
+// отключаем его, чтобы не блокировать остальных.
+func (h *Hub) push(client *Client, f *frame) {
+	if f == nil {
+		return
+	}
+	if !client.push(f) {
+		h.slowClients.Add(1)
+		go h.unregister(client) // Шарды ждут друг друга только из отдельной горутины
 	}
 }
@@ -0,0 +1,35 @@
+package pubsub
+
+import (
+	"sync"
+	"testing"
+
+	"nexus-engine/internal/pkg/logger"
+)
+
+// unregister (после переполнения буфера) обгоняет register: клиент не должен остаться в шардах
+func TestUnregisterDuringRegister(t *testing.T) {
+	h := NewHub(logger.New(logger.LevelError), HubOptions{Shards: 8, QueueSize: 16, Presence: true})
+	go h.Run()
+
+	for i := 0; i < 200; i++ {
+		client := &Client{hub: h, send: make(chan *frame, 256), userID: "u", initial: []string{"room.1", "room.*", "feed"}}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); h.register(client) }()
+		go func() { defer wg.Done(); h.unregister(client) }()
+		wg.Wait()
+	}
+
+	h.each(func(s *shard) {
+		if len(s.clients) != 0 {
+			t.Errorf("shard keeps %d closed clients", len(s.clients))
+		}
+	})
+	if got := h.Interests(); len(got) != 0 {
+		t.Errorf("interests left: %v", got)
+	}
+	if m := h.Members("room.1"); len(m) != 0 {
+		t.Errorf("presence left: %v", m)
+	}
+}
@@ -1,19 +1,51 @@
 package pubsub
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"net/http"
 	"nexus-engine/internal/core"
+	"nexus-engine/internal/pkg/cluster"
 	"nexus-engine/internal/pkg/logger"
+	"runtime"
+	"strings"
+	"time"
 )
 
-var _ core.Module = (*Module)(nil)
+var (
+	_ core.Module           = (*Module)(nil)
+	_ core.ChannelPublisher = (*Module)(nil)
+)
 
 type Module struct {
-	hub       *Hub
-	tickets   *TicketStore
-	log       *logger.Logger
-	ticketTTL *int
+	hub      *Hub
+	cluster  *cluster.Node
+	bus      *core.Bus
+	tickets  *TicketStore
+	sessions *sessionStore
+	policy   *PublishPolicy
+	log      *logger.Logger
+
+	// Флаги CLI
+	ticketTTL      *int
+	maxPublishSize *int
+	publishHook    *string
+	hookTimeout    *int
+	presence       *bool
+	history        *string
+	durable        *string
+	dataDir        *string
+	compact        *int
+	pollTimeout    *int
+	sessionTimeout *int
+	shards         *int
+	queueSize      *int
+	clusterAddr    *string
+	clusterID      *string
+	clusterSeeds   *string
+	clusterSecret  *string
+	clusterGossip  *int
 }
 
 func NewModule() *Module {
@@ -22,30 +54,149 @@ func NewModule() *Module {
 
 func (m *Module) Name() string { return "PubSub" }
 
+// SetBus — другие модули публикуют в каналы через core.TopicPublish
+func (m *Module) SetBus(bus *core.Bus) {
+	m.bus = bus
+}
+
 func (m *Module) RegisterFlags(fs *flag.FlagSet) {
 	m.ticketTTL = fs.Int("ws-ticket-ttl", 15, "Ticket TTL in seconds")
+	m.shards = fs.Int("ws-shards", runtime.NumCPU(), "Number of hub shards (goroutines) that channels are spread across")
+	m.queueSize = fs.Int("ws-publish-queue", 4096, "Max queued publishes per hub shard before publish returns 503")
+	m.presence = fs.Bool("ws-presence", true, "Track users in channels and send join/leave events")
+	m.history = fs.String("ws-history", "", "Channel history retention per pattern for replay on reconnect, e.g. chat.*=100,orders.>=5m,feed=1000/1h")
+
+	m.durable = fs.String("ws-durable", "", "Durable channels with acks and redelivery, retention per pattern, e.g. notify.*=1000/72h")
+	m.dataDir = fs.String("ws-data-dir", "./data", "Directory for durable channels persistence")
+	m.compact = fs.Int("ws-compact-interval", 60, "Interval in seconds to compact the durable channels journal")
+
+	m.pollTimeout = fs.Int("ws-poll-timeout", 25, "How long in seconds a long-polling request waits for messages")
+	m.sessionTimeout = fs.Int("ws-session-timeout", 60, "Drop a long-polling session after this many seconds without polls")
+
+	m.clusterAddr = fs.String("ws-cluster-addr", "", "This node's base URL for other nodes, e.g. http://10.0.0.5:4000 (empty = single node). Only live messages are forwarded: presence, history offsets and durable cursors stay node-local, so pin such clients to one node")
+	m.clusterID = fs.String("ws-cluster-id", "", "Unique node id in the cluster (default: ws-cluster-addr)")
+	m.clusterSeeds = fs.String("ws-cluster-seeds", "", "Comma-separated base URLs of nodes to join the cluster through")
+	m.clusterSecret = fs.String("ws-cluster-secret", "", "Shared secret for node-to-node requests (required with ws-cluster-addr)")
+	m.clusterGossip = fs.Int("ws-cluster-gossip-interval", 1000, "Interval in milliseconds between cluster gossip rounds")
+
+	m.maxPublishSize = fs.Int("ws-max-publish-size", 64*1024, "Max size in bytes of a message published by a client over the socket")
+	m.publishHook = fs.String("ws-publish-hook", "", "URL that validates messages published over the socket (empty = no validation)")
+	m.hookTimeout = fs.Int("ws-publish-hook-timeout", 2000, "Timeout in milliseconds for the publish hook")
 }
 
 func (m *Module) Init(log *logger.Logger) error {
 	m.log = log
-	m.hub = NewHub(log)
+
+	// Без секрета /cluster/ принимает публикации от кого угодно
+	if *m.clusterAddr != "" && *m.clusterSecret == "" {
+		return errors.New("ws-cluster-secret is required with ws-cluster-addr")
+	}
+
+	historyRules, err := ParseHistoryRules(*m.history)
+	if err != nil {
+		return err
+	}
+	durableRules, err := ParseHistoryRules(*m.durable)
+	if err != nil {
+		return err
+	}
+	var store *durable
+	if len(durableRules) > 0 {
+		if store, err = openDurable(*m.dataDir+"/pubsub.journal", durableRules, log); err != nil {
+			return err
+		}
+	}
+
+	if *m.clusterAddr != "" {
+		m.cluster = cluster.New(cluster.Config{
+			ID:             *m.clusterID,
+			Addr:           *m.clusterAddr,
+			Seeds:          strings.Split(*m.clusterSeeds, ","),
+			Secret:         *m.clusterSecret,
+			GossipInterval: time.Duration(max(1, *m.clusterGossip)) * time.Millisecond,
+			QueueSize:      *m.queueSize,
+			Pattern:        IsPattern,
+			Covers:         Covers,
+			Interests:      func() []string { return m.hub.Interests() },
+			Deliver: func(channel string, data json.RawMessage) error {
+				return m.hub.Publish(Message{Channel: channel, Data: data, remote: true})
+			},
+			Log: log,
+		})
+	}
+
+	m.hub = NewHub(log, HubOptions{
+		Shards:          *m.shards,
+		QueueSize:       *m.queueSize,
+		Presence:        *m.presence,
+		History:         historyRules,
+		Durable:         store,
+		CompactInterval: time.Duration(max(1, *m.compact)) * time.Second,
+		Cluster:         m.cluster,
+	})
 	m.tickets = NewTicketStore()
+	m.sessions = newSessionStore()
+
+	m.policy = &PublishPolicy{MaxSize: max(1, *m.maxPublishSize)}
+	if *m.publishHook != "" {
+		m.policy.Hook = NewPublishHook(*m.publishHook, time.Duration(*m.hookTimeout)*time.Millisecond)
+		log.Info("🪝 WS publish hook: %s", *m.publishHook)
+	}
 
 	// Запускаем Hub в отдельной горутине
 	go m.hub.Run()
+	if m.cluster != nil {
+		m.cluster.Start()
+	}
+	go m.expireSessions(time.Duration(max(*m.pollTimeout+1, *m.sessionTimeout)) * time.Second)
+
+	if m.bus != nil {
+		m.bus.SetPublisher(m)
+		m.bus.Subscribe(core.TopicPublish, func(payload any) {
+			if msg, ok := payload.(core.ChannelMessage); ok {
+				if err := m.PublishChannel(msg.Channel, msg.Data); err != nil {
+					m.log.Error("Publish to '%s' dropped: %v", msg.Channel, err)
+				}
+			}
+		})
+	}
 
 	return nil
 }
 
+// PublishChannel — публикация от других модулей через шину (core.ChannelPublisher)
+func (m *Module) PublishChannel(channel string, data any) error {
+	return m.hub.Publish(Message{Channel: channel, Data: data})
+}
+
 func (m *Module) RegisterRoutes(mux *http.ServeMux) {
 	// Internal API (для SDK)
 	mux.HandleFunc("/pubsub/ticket", m.handleCreateTicket)
 	mux.HandleFunc("/pubsub/publish", m.handlePublish)
+	mux.HandleFunc("/pubsub/presence", m.handlePresence)
+	mux.HandleFunc("/pubsub/history", m.handleHistory)
+	mux.HandleFunc("/pubsub/stats", m.handleStats)
+
+	// Node-to-node API (узлы кластера)
+	if m.cluster != nil {
+		mux.Handle("/cluster/", m.cluster.Handler())
+	}
 
 	// Public WebSocket (для Клиентов)
 	mux.HandleFunc("/ws", m.handleWebSocket)
+
+	// Public SSE и long-polling (для клиентов, у которых не открывается WebSocket)
+	mux.HandleFunc("/sse", m.handleSSE)
+	mux.HandleFunc("/poll", m.handlePoll)
+	mux.HandleFunc("/command", m.handleCommand)
 }
 
 func (m *Module) Shutdown() {
 	// Можно добавить graceful shutdown для сокетов, но пока не обязательно
+	if m.cluster != nil {
+		m.cluster.Stop()
+	}
+	if m.hub != nil {
+		m.hub.Close()
+	}
 }
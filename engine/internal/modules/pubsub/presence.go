@@ -0,0 +1,98 @@
+package pubsub
+
+import (
+	"sort"
+	"time"
+)
+
+// События присутствия, которые Hub рассылает в канал (Message.Type = "presence")
+const (
+	PresenceJoin   = "join"
+	PresenceLeave  = "leave"
+	PresenceUpdate = "update"
+)
+
+// Member — пользователь в канале
+type Member struct {
+	UserID      string `json:"user_id"`
+	Meta        any    `json:"meta,omitempty"` // То, что прислал клиент: статус, курсор...
+	JoinedAt    int64  `json:"joined_at"`      // Unix ms
+	Connections int    `json:"connections"`    // Вкладки/устройства пользователя в канале
+}
+
+// PresenceEvent — data сообщения присутствия
+type PresenceEvent struct {
+	Event  string `json:"event"`
+	UserID string `json:"user_id"`
+	Meta   any    `json:"meta,omitempty"`
+}
+
+// presence — кто подписан на какие каналы. Учитываются только точные подписки
+// пользователей с UserID: шаблон ("tenant.42.>") — это наблюдатель, а не участник.
+// У каждого шарда Hub свое (каналы шарда), без локов.
+type presence struct {
+	channels map[string]map[string]*Member
+}
+
+func newPresence() *presence {
+	return &presence{channels: make(map[string]map[string]*Member)}
+}
+
+// join — еще одно соединение пользователя в канале. true, если пользователь только что появился.
+func (p *presence) join(ch, userID string) bool {
+	members, ok := p.channels[ch]
+	if !ok {
+		members = make(map[string]*Member)
+		p.channels[ch] = members
+	}
+	if m, ok := members[userID]; ok {
+		m.Connections++
+		return false
+	}
+	members[userID] = &Member{UserID: userID, JoinedAt: time.Now().UnixMilli(), Connections: 1}
+	return true
+}
+
+// leave — соединение ушло из канала. true, если это было последнее соединение пользователя.
+func (p *presence) leave(ch, userID string) bool {
+	m, ok := p.channels[ch][userID]
+	if !ok {
+		return false
+	}
+	if m.Connections--; m.Connections > 0 {
+		return false
+	}
+	delete(p.channels[ch], userID)
+	if len(p.channels[ch]) == 0 {
+		delete(p.channels, ch)
+	}
+	return true
+}
+
+// update меняет метаданные пользователя (последняя запись побеждает для всех его соединений)
+func (p *presence) update(ch, userID string, meta any) bool {
+	m, ok := p.channels[ch][userID]
+	if !ok {
+		return false
+	}
+	m.Meta = meta
+	return true
+}
+
+func (p *presence) members(ch string) []Member {
+	out := make([]Member, 0, len(p.channels[ch]))
+	for _, m := range p.channels[ch] {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].JoinedAt < out[j].JoinedAt })
+	return out
+}
+
+// tracked — участвует ли подписка клиента в присутствии
+func (s *shard) tracked(client *Client, ch string) bool {
+	return s.presence != nil && client.userID != "" && !IsPattern(ch)
+}
+
+func (s *shard) announce(ch, event, userID string, meta any) {
+	s.publish(Message{Type: TypePresence, Channel: ch, Data: PresenceEvent{Event: event, UserID: userID, Meta: meta}})
+}
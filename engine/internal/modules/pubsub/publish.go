@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PublishPolicy — ограничения на публикацию клиентами через сокет
+type PublishPolicy struct {
+	MaxSize int          // Макс размер data в байтах
+	Hook    *PublishHook // nil — без внешней проверки
+}
+
+// PublishHook — внешняя проверка сообщений, которые клиенты публикуют через сокет.
+// Engine шлет POST {user_id, channel, data}: 2xx — пропустить (если в ответе есть
+// {"data": ...}, публикуется она), иначе — отклонить с текстом ответа как причиной.
+type PublishHook struct {
+	url    string
+	client *http.Client
+}
+
+func NewPublishHook(url string, timeout time.Duration) *PublishHook {
+	return &PublishHook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+type hookRequest struct {
+	UserID  string          `json:"user_id"`
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Check возвращает данные для публикации или причину отказа
+func (h *PublishHook) Check(userID, channel string, data json.RawMessage) (json.RawMessage, error) {
+	body, _ := json.Marshal(hookRequest{UserID: userID, Channel: channel, Data: data})
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New("publish hook unavailable")
+	}
+	defer resp.Body.Close()
+
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reason := strings.TrimSpace(string(reply))
+		if reason == "" {
+			reason = fmt.Sprintf("rejected by publish hook (%d)", resp.StatusCode)
+		}
+		return nil, errors.New(reason)
+	}
+
+	// Хук может заменить данные (например, проставить серверный timestamp)
+	var rewrite struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(reply, &rewrite) == nil && len(rewrite.Data) > 0 {
+		return rewrite.Data, nil
+	}
+	return data, nil
+}
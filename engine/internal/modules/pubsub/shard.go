@@ -0,0 +1,252 @@
+package pubsub
+
+import "fmt"
+
+// shard — часть Hub: каналы с одним хэшем имени и все их состояние (подписки,
+// присутствие, история, durable). Шаблонные подписки есть в каждом шарде: они
+// ловят каналы любого шарда. Состояние принадлежит горутине шарда, без локов.
+type shard struct {
+	hub *Hub
+
+	// Подключенные клиенты (во всех шардах) и их подписки в этом шарде
+	clients map[*Client]*subscriber
+
+	// Подписки (каналы шарда и все шаблоны) в виде дерева сегментов
+	subscriptions *subTrie
+
+	// Присутствие пользователей в каналах (nil — выключено)
+	presence *presence
+
+	// История каналов (nil — ни одного правила)
+	history *history
+
+	// Durable каналы шарда (nil — выключены)
+	durable *durable
+
+	// Каналы управления (Action Channels)
+	queue    chan Message // Публикации из HTTP и шины: ограниченная очередь
+	requests chan request // Команды клиентов (без буфера: команды клиента идут по порядку)
+	calls    chan func()  // Регистрация клиентов, шаблоны, чтение состояния из HTTP
+}
+
+// subscriber — подписки клиента в шарде
+type subscriber struct {
+	channels map[string]bool   // Каналы и шаблоны
+	durable  map[string]uint64 // Durable каналы: offset последнего отправленного
+	inflight map[string]uint64 // Durable каналы: сколько отправлено и не подтверждено (см. charge)
+}
+
+// call выполняет fn в горутине шарда и ждет завершения
+func (s *shard) call(fn func()) {
+	done := make(chan struct{})
+	s.calls <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// run — главный цикл обработки событий шарда (Event Loop)
+func (s *shard) run() {
+	for {
+		select {
+		// 1. Команда клиента. Ответ идет через тот же send, поэтому ack
+		// подписки приходит раньше первого сообщения канала.
+		case req := <-s.requests:
+			s.handle(req)
+
+		// 2. Рассылка сообщения
+		case msg := <-s.queue:
+			s.publish(msg)
+
+		// 3. Регистрация, шаблоны, запросы из HTTP
+		case fn := <-s.calls:
+			fn()
+		}
+	}
+}
+
+// register — клиент подключился; channels — каналы тикета этого шарда.
+// Hub.register обходит шарды по очереди, и unregister (после переполнения буфера)
+// может почистить этот шард раньше: close идет до чистки, поэтому закрытого клиента
+// не регистрируем, иначе он, его подписки и присутствие остались бы навсегда.
+func (s *shard) register(client *Client, channels []string) {
+	if client.isClosed() {
+		return
+	}
+	s.clients[client] = &subscriber{channels: make(map[string]bool), durable: make(map[string]uint64), inflight: make(map[string]uint64)}
+	for _, ch := range channels {
+		s.add(client, ch)
+	}
+	// И досылаем неподтвержденное из durable каналов
+	for ch := range s.clients[client].durable {
+		s.pump(client, ch)
+	}
+}
+
+func (s *shard) handle(req request) {
+	sub := s.clients[req.client]
+	if sub == nil {
+		return // Клиент уже отключен
+	}
+	reply := Message{Type: TypeAck, ID: req.id, Channel: req.channel}
+	switch req.typ {
+	case TypeSubscribe:
+		if req.since != nil {
+			s.resume(req, &reply)
+			return
+		}
+		s.add(req.client, req.channel)
+		s.deliver(req.client, reply)
+		s.pump(req.client, req.channel) // Бэклог durable канала — после ack
+		return
+	case TypeAck:
+		if err := s.ack(req); err != nil {
+			reply.Type, reply.Error = TypeError, err.Error()
+		} else if req.id == "" {
+			return // Подтверждения без id не подтверждаем в ответ
+		}
+	case TypeUnsubscribe:
+		s.remove(req.client, req.channel)
+	case TypePublish:
+		s.publish(Message{Channel: req.channel, Data: req.data})
+	case TypePresence:
+		if !sub.channels[req.channel] || !s.tracked(req.client, req.channel) {
+			reply.Type, reply.Error = TypeError, "not subscribed to the channel"
+			break
+		}
+		s.presence.update(req.channel, req.client.userID, req.data)
+		s.announce(req.channel, PresenceUpdate, req.client.userID, req.data)
+	case TypeMembers:
+		if s.presence == nil {
+			reply.Type, reply.Error = TypeError, "presence is disabled"
+			break
+		}
+		reply.Data = s.presence.members(req.channel)
+	}
+	s.deliver(req.client, reply)
+}
+
+// replay — история канала: из durable хранилища или из обычной истории
+func (s *shard) replay(ch, since string, limit int) (Replay, error) {
+	// Чтение не создает канал: в еще не созданный канал ничего не публиковали
+	if dc, ok := s.findDurable(ch); ok {
+		from, err := parseSince(since)
+		if err != nil {
+			return Replay{}, err
+		}
+		if dc == nil {
+			return Replay{Messages: []Message{}, ResumeInfo: ResumeInfo{Gap: from != nil}}, nil
+		}
+		return dc.replay(ch, from, limit), nil
+	}
+	if s.history == nil {
+		return Replay{}, fmt.Errorf("history is not enabled for channel %q", ch)
+	}
+	return s.history.since(ch, since, limit)
+}
+
+// resume — подписка с досылкой пропущенного: ack (с gap/more/last), затем история, затем живые сообщения.
+// Все в одной горутине шарда, поэтому между историей и живым потоком ничего не теряется.
+// Если клиент уже был подписан, часть сообщений придет повторно: клиент отбрасывает их по offset.
+// Если досылка невозможна (у канала нет истории), подписываем как обычно, с gap: пропущенное потеряно.
+func (s *shard) resume(req request, reply *Message) {
+	// Сколько влезет в буфер клиента (с местом под ack): остальное — следующей подпиской
+	limit := max(1, cap(req.client.send)-len(req.client.send)-1)
+	replay, err := s.replay(req.channel, *req.since, limit)
+	if err != nil {
+		s.hub.log.Debug("Resume of '%s' from %s failed: %v", req.channel, *req.since, err)
+		replay = Replay{ResumeInfo: ResumeInfo{Gap: true}}
+	}
+
+	s.add(req.client, req.channel)
+	reply.Data = replay.ResumeInfo
+	s.deliver(req.client, *reply)
+	for _, msg := range replay.Messages {
+		s.deliver(req.client, msg)
+	}
+	s.pump(req.client, req.channel)
+}
+
+func (s *shard) publish(msg Message) {
+	if msg.Type == "" {
+		s.hub.published.Add(1)
+		if dc := s.durableChannel(msg.Channel); dc != nil {
+			s.durable.store(dc, &msg)
+		} else if s.history != nil {
+			s.history.record(&msg)
+		}
+		// На другие узлы — только свое: присутствие у каждого узла свое, чужое уже разослано
+		if s.hub.cluster != nil && !msg.remote {
+			s.hub.cluster.Broadcast(msg.Channel, msg.Data)
+		}
+	}
+
+	var f *frame // Сериализуем один раз на всех получателей (и только если они есть)
+	s.subscriptions.match(msg.Channel, func(client *Client) {
+		if _, ok := s.clients[client].durable[msg.Channel]; ok && msg.Type == "" {
+			s.pump(client, msg.Channel) // Durable — строго по порядку, с учетом окна
+			return
+		}
+		if f == nil {
+			f = s.hub.encode(msg)
+		}
+		s.hub.push(client, f)
+	})
+}
+
+// deliver отправляет сообщение одному клиенту
+func (s *shard) deliver(client *Client, msg Message) {
+	if s.clients[client] == nil {
+		return // Отключен, пока шла рассылка (например, событием leave)
+	}
+	s.hub.send(client, msg)
+}
+
+func (s *shard) add(client *Client, ch string) {
+	sub := s.clients[client]
+	if sub == nil || sub.channels[ch] {
+		return
+	}
+	sub.channels[ch] = true
+	s.subscriptions.add(ch, client)
+	s.hub.interest(ch, +1)
+	s.hub.log.Debug("Client subscribed to '%s'", ch)
+
+	if s.tracked(client, ch) && s.presence.join(ch, client.userID) {
+		s.announce(ch, PresenceJoin, client.userID, nil)
+	}
+
+	// Durable канал: доставка с курсора пользователя (сам бэклог досылает pump)
+	if dc := s.durableChannel(ch); dc != nil && client.userID != "" {
+		sub.durable[ch] = s.durable.cursor(ch, dc, client.userID)
+	}
+}
+
+func (s *shard) remove(client *Client, ch string) {
+	sub := s.clients[client]
+	if sub == nil || !sub.channels[ch] {
+		return
+	}
+	delete(sub.channels, ch)
+	delete(sub.durable, ch)
+	s.charge(client, sub, ch, 0)
+	s.subscriptions.remove(ch, client)
+	s.hub.interest(ch, -1)
+
+	if s.tracked(client, ch) && s.presence.leave(ch, client.userID) {
+		s.announce(ch, PresenceLeave, client.userID, nil)
+	}
+}
+
+func (s *shard) removeClient(client *Client) {
+	sub := s.clients[client]
+	if sub == nil {
+		return
+	}
+	// Удаляем из всех подписок шарда
+	for ch := range sub.channels {
+		s.remove(client, ch)
+	}
+	delete(s.clients, client)
+}
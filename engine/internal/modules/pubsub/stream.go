@@ -0,0 +1,463 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Комментарий-пинг SSE: прокси рвут молчащие соединения раньше, чем WebSocket pongWait
+const ssePingPeriod = 15 * time.Second
+
+// resumeParam — токен переподключения SSE внутри Last-Event-ID (рядом с offset каналов)
+const resumeParam = "_resume"
+
+// session — клиент без WebSocket (SSE или long-polling). Сообщения идут через
+// тот же Hub и буфер send, команды — отдельными POST /command.
+type session struct {
+	id      string
+	client  *Client
+	polling bool
+	resume  string // SSE: одноразовый токен переподключения (уходит в id событий)
+
+	cmdMu sync.Mutex // Команды по одной: права тикетов и порядок команд как у readPump
+
+	mu      sync.Mutex
+	offsets map[string]string // Канал -> offset последнего отданного сообщения (Last-Event-ID)
+
+	// Long-polling (под poll)
+	poll     sync.Mutex
+	batch    []json.RawMessage // Последняя отданная пачка: повторяется, если ответ не дошел
+	batchSeq uint64
+	lastSeen time.Time
+}
+
+// track запоминает offset сообщения. true — Last-Event-ID изменился.
+func (s *session) track(f *frame) bool {
+	if f.offset == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offsets[f.channel] = f.offset
+	return true
+}
+
+// forget — канал больше не продолжать при переподключении
+func (s *session) forget(ch string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.offsets, ch)
+}
+
+// lastEventID — offset всех каналов одной строкой: "chat.1=1700000000000-42&feed=1700000000000-7"
+// (у SSE — еще и токен переподключения)
+func (s *session) lastEventID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := url.Values{}
+	for ch, offset := range s.offsets {
+		values.Set(ch, offset)
+	}
+	if s.resume != "" {
+		values.Set(resumeParam, s.resume)
+	}
+	return values.Encode()
+}
+
+// wait ждет первое сообщение (не дольше timeout) и забирает все, что уже накопилось.
+// false — Hub закрыл клиента (переполнен буфер), сессию нужно начинать заново.
+func (s *session) wait(ctx context.Context, timeout time.Duration) ([]*frame, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var batch []*frame
+	select {
+	case f, ok := <-s.client.send:
+		if !ok {
+			return batch, false
+		}
+		batch = append(batch, f)
+	case <-timer.C:
+		return batch, true
+	case <-ctx.Done():
+		return batch, true
+	}
+
+	for len(batch) < cap(s.client.send) {
+		select {
+		case f, ok := <-s.client.send:
+			if !ok {
+				return batch, true // Закрытие увидит следующий запрос
+			}
+			batch = append(batch, f)
+		default:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// sessionStore — открытые SSE и long-polling сессии
+type sessionStore struct {
+	sessions map[string]*session
+	parked   map[string]parkedSSE // Токен переподключения -> права оборвавшейся SSE сессии
+	mu       sync.Mutex
+}
+
+// parkedSSE — оборвавшаяся SSE сессия, которую можно продолжить тем же URL
+type parkedSSE struct {
+	ticket    string
+	info      TicketInfo
+	expiresAt time.Time
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*session), parked: make(map[string]parkedSSE)}
+}
+
+func randomID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// park запоминает права SSE сессии на ttl: переподключиться можно один раз, с тем же тикетом в URL
+// и токеном в Last-Event-ID. Протухшие записи чистим здесь же.
+func (ss *sessionStore) park(token, ticket string, info TicketInfo, ttl time.Duration) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	now := time.Now()
+	for t, p := range ss.parked {
+		if now.After(p.expiresAt) {
+			delete(ss.parked, t)
+		}
+	}
+	ss.parked[token] = parkedSSE{ticket: ticket, info: info, expiresAt: now.Add(ttl)}
+}
+
+// unpark забирает права оборвавшейся сессии (токен одноразовый)
+func (ss *sessionStore) unpark(token, ticket string) (TicketInfo, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	p, ok := ss.parked[token]
+	if !ok || p.ticket != ticket {
+		return TicketInfo{}, false
+	}
+	delete(ss.parked, token)
+	return p.info, time.Now().Before(p.expiresAt)
+}
+
+func (ss *sessionStore) add(client *Client, polling bool) *session {
+	s := &session{
+		id:       randomID(),
+		client:   client,
+		polling:  polling,
+		offsets:  make(map[string]string),
+		lastSeen: time.Now(),
+	}
+	ss.mu.Lock()
+	ss.sessions[s.id] = s
+	ss.mu.Unlock()
+	return s
+}
+
+func (ss *sessionStore) get(id string) *session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.sessions[id]
+}
+
+func (ss *sessionStore) remove(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.sessions, id)
+}
+
+// expired забирает long-polling сессии, которые не опрашивали дольше timeout
+func (ss *sessionStore) expired(timeout time.Duration) []*session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var out []*session
+	for id, s := range ss.sessions {
+		if !s.polling || !s.poll.TryLock() {
+			continue // SSE живет, пока открыт запрос; идущий опрос — живой клиент
+		}
+		if time.Since(s.lastSeen) > timeout {
+			delete(ss.sessions, id)
+			out = append(out, s)
+		}
+		s.poll.Unlock()
+	}
+	return out
+}
+
+// expireSessions отключает брошенные long-polling сессии
+func (m *Module) expireSessions(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, s := range m.sessions.expired(timeout) {
+			m.hub.unregister(s.client)
+			m.log.Debug("Poll session expired: User=%s", s.client.userID)
+		}
+	}
+}
+
+// connect регистрирует клиента SSE/long-polling. Каналы из lastEventID подписываются
+// с досылкой после своего offset (как subscribe с since), остальные каналы тикета — как обычно.
+func (m *Module) connect(info TicketInfo, lastEventID string, polling bool) *session {
+	client := m.newClient(info)
+	s := m.sessions.add(client, polling)
+
+	resume := map[string]string{}
+	if values, err := url.ParseQuery(lastEventID); err == nil {
+		for ch := range values {
+			offset := values.Get(ch)
+			if ch == resumeParam {
+				continue
+			}
+			if ValidateChannel(ch, false) != nil || !permits(client.allowed, ch) {
+				continue
+			}
+			if _, err := ParseOffset(offset); err != nil {
+				continue
+			}
+			resume[ch] = offset
+			s.offsets[ch] = offset
+		}
+	}
+
+	client.initial = nil
+	for _, ch := range info.Channels {
+		if _, ok := resume[ch]; !ok {
+			client.initial = append(client.initial, ch)
+		}
+	}
+
+	m.hub.register(client)
+	for ch, offset := range resume {
+		m.hub.submit(request{client: client, typ: TypeSubscribe, channel: ch, since: &offset})
+	}
+	return s
+}
+
+// resumeSSE — права оборвавшейся SSE сессии по токену из Last-Event-ID (переподключение EventSource)
+func (m *Module) resumeSSE(ticket, lastEventID string) (TicketInfo, bool) {
+	values, err := url.ParseQuery(lastEventID)
+	if err != nil || ticket == "" || values.Get(resumeParam) == "" {
+		return TicketInfo{}, false
+	}
+	return m.sessions.unpark(values.Get(resumeParam), ticket)
+}
+
+// ticket проверяет тикет из query (одноразовый, как для /ws)
+func (m *Module) ticket(w http.ResponseWriter, r *http.Request) (TicketInfo, bool) {
+	ticket := r.URL.Query().Get("ticket")
+	if ticket == "" {
+		http.Error(w, "Missing ticket", http.StatusUnauthorized)
+		return TicketInfo{}, false
+	}
+	info, ok := m.tickets.Validate(ticket)
+	if !ok {
+		http.Error(w, "Invalid or expired ticket", http.StatusForbidden)
+		return TicketInfo{}, false
+	}
+	return info, true
+}
+
+// --- PUBLIC SSE / LONG-POLLING ---
+// Для клиентов, у которых прокси не пропускают WebSocket. Сообщения те же, что в сокете.
+
+// GET /sse?ticket=... — поток Server-Sent Events. Первое событие "session" — id для POST /command.
+// В id событий, кроме offset каналов, — одноразовый токен сессии: EventSource переподключается
+// тем же URL с заголовком Last-Event-ID, и по тикету из URL вместе с этим токеном (не дольше
+// ws-ticket-ttl после разрыва) сессия продолжается с места разрыва. Позже — свежий тикет и last_event_id.
+func (m *Module) handleSSE(w http.ResponseWriter, r *http.Request) {
+	allowOrigin(w)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ticket := r.URL.Query().Get("ticket")
+	lastEventID := r.Header.Get("Last-Event-ID")
+	info, ok := m.resumeSSE(ticket, lastEventID)
+	if !ok {
+		if info, ok = m.ticket(w, r); !ok {
+			return
+		}
+	}
+
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	s := m.connect(info, lastEventID, false)
+	s.resume = randomID()
+	defer func() {
+		m.sessions.remove(s.id)
+		m.hub.unregister(s.client)
+		m.sessions.park(s.resume, ticket, info, time.Duration(*m.ticketTTL)*time.Second)
+	}()
+	m.log.Debug("SSE Connected: User=%s Channels=%v", info.UserID, info.Channels)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Чтобы nginx не копил поток
+	hello, _ := json.Marshal(map[string]string{"session": s.id})
+	w.Write([]byte("event: session\nid: " + s.lastEventID() + "\ndata: " + string(hello) + "\n\n"))
+	flusher.Flush()
+
+	ticker := time.NewTicker(ssePingPeriod)
+	defer ticker.Stop()
+
+	var buf bytes.Buffer
+	for {
+		select {
+		case f, ok := <-s.client.send:
+			if !ok {
+				return // Hub отключил клиента
+			}
+			buf.Reset()
+			if s.track(f) {
+				buf.WriteString("id: " + s.lastEventID() + "\n")
+			}
+			buf.WriteString("data: ")
+			buf.Write(f.data)
+			buf.WriteString("\n\n")
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// pollResponse — ответ long-polling
+type pollResponse struct {
+	Session     string            `json:"session"`
+	Seq         uint64            `json:"seq"` // Номер пачки: передайте его в следующем запросе
+	Messages    []json.RawMessage `json:"messages"`
+	LastEventID string            `json:"last_event_id,omitempty"`
+}
+
+// GET /poll?ticket=... — новая сессия, GET /poll?session=...&seq=N — следующие сообщения.
+// Запрос ждет до ws-poll-timeout секунд. seq — номер последней полученной пачки: если ответ
+// потерялся по дороге, клиент передаст прежний номер и получит ту же пачку снова.
+func (m *Module) handlePoll(w http.ResponseWriter, r *http.Request) {
+	allowOrigin(w)
+	q := r.URL.Query()
+
+	var s *session
+	if id := q.Get("session"); id != "" {
+		if s = m.sessions.get(id); s == nil {
+			http.Error(w, "Unknown or expired session", http.StatusNotFound)
+			return
+		}
+	} else {
+		info, ok := m.ticket(w, r)
+		if !ok {
+			return
+		}
+		s = m.connect(info, q.Get("last_event_id"), true)
+		m.log.Debug("Poll session started: User=%s Channels=%v", info.UserID, info.Channels)
+	}
+
+	if !s.poll.TryLock() {
+		http.Error(w, "Another poll is in progress", http.StatusConflict)
+		return
+	}
+	defer s.poll.Unlock()
+	s.lastSeen = time.Now()
+
+	seq, _ := strconv.ParseUint(q.Get("seq"), 10, 64)
+	if seq >= s.batchSeq {
+		// Прошлая пачка дошла: собираем новую
+		batch, open := s.wait(r.Context(), time.Duration(*m.pollTimeout)*time.Second)
+		if !open {
+			m.sessions.remove(s.id)
+			m.hub.unregister(s.client)
+			http.Error(w, "Session closed", http.StatusGone)
+			return
+		}
+		s.batch = make([]json.RawMessage, 0, len(batch))
+		for _, f := range batch {
+			s.track(f)
+			s.batch = append(s.batch, f.data)
+		}
+		s.batchSeq++
+	}
+	s.lastSeen = time.Now()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(pollResponse{
+		Session:     s.id,
+		Seq:         s.batchSeq,
+		Messages:    s.batch,
+		LastEventID: s.lastEventID(),
+	})
+}
+
+// POST /command?session=... — команда клиента SSE/long-polling, как в сокете
+// ({"type":"subscribe","id":"1","channel":"room.1"}). Ответ ack/error придет в поток.
+func (m *Module) handleCommand(w http.ResponseWriter, r *http.Request) {
+	allowOrigin(w)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := m.sessions.get(r.URL.Query().Get("session"))
+	if s == nil {
+		http.Error(w, "Unknown or expired session", http.StatusNotFound)
+		return
+	}
+
+	var cmd command
+	body := http.MaxBytesReader(w, r.Body, int64(maxMessageSize+2*m.policy.MaxSize))
+	if err := json.NewDecoder(body).Decode(&cmd); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.cmdMu.Lock()
+	req := s.client.handle(cmd)
+	if req.err == "" && req.typ == TypeUnsubscribe {
+		s.forget(req.channel)
+	}
+	m.hub.submit(req)
+	s.cmdMu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"ok":true}`))
+}
+
+// allowOrigin — SSE и long-polling открыты для любых origin, как и /ws (доступ дает тикет)
+func allowOrigin(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+}
@@ -9,7 +9,8 @@ import (
 
 type TicketInfo struct {
 	UserID    string
-	Channels  []string
+	Channels  []string // Разрешенные для подписки
+	Publish   []string // Разрешенные для публикации через сокет
 	ExpiresAt int64
 }
 
@@ -25,7 +26,7 @@ func NewTicketStore() *TicketStore {
 }
 
 // Create генерирует случайный токен
-func (ts *TicketStore) Create(userID string, channels []string, ttl time.Duration) string {
+func (ts *TicketStore) Create(userID string, channels, publish []string, ttl time.Duration) string {
 	bytes := make([]byte, 16)
 	rand.Read(bytes)
 	token := hex.EncodeToString(bytes)
@@ -36,6 +37,7 @@ func (ts *TicketStore) Create(userID string, channels []string, ttl time.Duratio
 	ts.tickets[token] = TicketInfo{
 		UserID:    userID,
 		Channels:  channels,
+		Publish:   publish,
 		ExpiresAt: time.Now().Add(ttl).Unix(),
 	}
 
@@ -2,7 +2,9 @@ package pubsub
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,48 +19,103 @@ var upgrader = websocket.Upgrader{
 // --- INTERNAL API ---
 
 func (m *Module) handleCreateTicket(w http.ResponseWriter, r *http.Request) {
-	// Принимаем { userId, channels }
+	// Принимаем { userId, channels, publish }
 	var req struct {
 		UserID   string   `json:"user_id"`
 		Channels []string `json:"channels"`
+		Publish  []string `json:"publish"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad JSON", http.StatusBadRequest)
 		return
 	}
+	for _, ch := range append(req.Channels, req.Publish...) {
+		if err := ValidateChannel(ch, true); err != nil {
+			http.Error(w, fmt.Sprintf("Channel %q: %v", ch, err), http.StatusBadRequest)
+			return
+		}
+	}
+	// Каналы тикета подписываются при подключении: шаблон не должен ловить durable каналы
+	for _, ch := range req.Channels {
+		if err := m.hub.checkPattern(ch); err != nil {
+			http.Error(w, fmt.Sprintf("Channel %q: %v", ch, err), http.StatusBadRequest)
+			return
+		}
+	}
 
 	ttl := time.Duration(*m.ticketTTL) * time.Second
-	token := m.tickets.Create(req.UserID, req.Channels, ttl)
+	token := m.tickets.Create(req.UserID, req.Channels, req.Publish, ttl)
 
 	json.NewEncoder(w).Encode(map[string]string{"ticket": token})
 }
 
 func (m *Module) handlePublish(w http.ResponseWriter, r *http.Request) {
-	var req Message
+	// Только канал и данные: type/id/offset/error выставляет сам Hub, подделать их нельзя
+	var req struct {
+		Channel string `json:"channel"`
+		Data    any    `json:"data"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad JSON", http.StatusBadRequest)
 		return
 	}
+	if err := ValidateChannel(req.Channel, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Отправляем в Hub (неблокирующе)
-	m.hub.broadcast <- req
+	// Отправляем в Hub (неблокирующе): очередь полна — пусть отправитель повторит
+	if err := m.hub.Publish(Message{Channel: req.Channel, Data: req.Data}); err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"ok":true}`))
 }
 
-// --- PUBLIC WEBSOCKET ---
+// GET /pubsub/presence?channel=room.1 — кто сейчас в канале
+func (m *Module) handlePresence(w http.ResponseWriter, r *http.Request) {
+	channel := r.URL.Query().Get("channel")
+	if err := ValidateChannel(channel, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-func (m *Module) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	ticket := r.URL.Query().Get("ticket")
-	if ticket == "" {
-		http.Error(w, "Missing ticket", http.StatusUnauthorized)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.hub.Members(channel))
+}
+
+// GET /pubsub/history?channel=chat.1&since=1700000000000-42&limit=100 — сообщения после offset
+func (m *Module) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if err := ValidateChannel(q.Get("channel"), false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
 
-	info, ok := m.tickets.Validate(ticket)
+	replay, err := m.hub.History(q.Get("channel"), q.Get("since"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replay)
+}
+
+// GET /pubsub/stats — очереди шардов, отклоненные публикации, отключенные медленные клиенты
+func (m *Module) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.hub.Stats())
+}
+
+// --- PUBLIC WEBSOCKET ---
+
+func (m *Module) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	info, ok := m.ticket(w, r)
 	if !ok {
-		http.Error(w, "Invalid or expired ticket", http.StatusForbidden)
 		return
 	}
 
@@ -69,14 +126,9 @@ func (m *Module) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := &Client{
-		hub:      m.hub,
-		conn:     conn,
-		send:     make(chan Message, 256), // Буфер на 256 сообщений
-		channels: info.Channels,
-	}
-
-	m.hub.register <- client
+	client := m.newClient(info)
+	client.conn = conn
+	m.hub.register(client)
 
 	// Запускаем процессы чтения/записи
 	go client.writePump()
@@ -84,3 +136,25 @@ func (m *Module) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	m.log.Debug("WS Connected: User=%s Channels=%v", info.UserID, info.Channels)
 }
+
+// newClient — клиент с правами из тикета (общий для WebSocket, SSE и long-polling)
+func (m *Module) newClient(info TicketInfo) *Client {
+	client := &Client{
+		hub:     m.hub,
+		tickets: m.tickets,
+		policy:  m.policy,
+		send:    make(chan *frame, 256), // Буфер на 256 сообщений
+		userID:  info.UserID,
+		initial: info.Channels,
+		allowed: make(map[string]bool, len(info.Channels)),
+
+		publishable: make(map[string]bool, len(info.Publish)),
+	}
+	for _, ch := range info.Channels {
+		client.allowed[ch] = true
+	}
+	for _, ch := range info.Publish {
+		client.publishable[ch] = true
+	}
+	return client
+}
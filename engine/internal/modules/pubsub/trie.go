@@ -0,0 +1,201 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+)
+
+// Каналы иерархические: сегменты через точку ("tenant.42.orders").
+// В подписках (и в разрешениях тикетов) можно использовать шаблоны:
+// "*" — ровно один любой сегмент ("room.*" ловит "room.1", но не "room.1.typing"),
+// ">" — один или больше сегментов в конце ("tenant.42.>" ловит все внутри tenant.42).
+const (
+	segmentSep    = "."
+	wildcardOne   = "*"
+	wildcardTail  = ">"
+	wildcardChars = "*>"
+)
+
+var ErrBadChannel = errors.New("bad channel name")
+
+// IsPattern — содержит ли имя шаблонные сегменты
+func IsPattern(channel string) bool {
+	return strings.ContainsAny(channel, wildcardChars)
+}
+
+// ValidateChannel проверяет имя канала. Шаблоны разрешены только при подписке:
+// '*' и '>' должны занимать сегмент целиком, '>' — только последним.
+func ValidateChannel(channel string, allowPattern bool) error {
+	if channel == "" {
+		return ErrBadChannel
+	}
+	if !IsPattern(channel) {
+		return nil
+	}
+	if !allowPattern {
+		return errors.New("wildcards are not allowed here")
+	}
+
+	segs := strings.Split(channel, segmentSep)
+	for i, seg := range segs {
+		switch {
+		case seg == wildcardTail && i != len(segs)-1:
+			return errors.New("'>' must be the last segment")
+		case seg != wildcardOne && seg != wildcardTail && strings.ContainsAny(seg, wildcardChars):
+			return errors.New("wildcards must take a whole segment")
+		}
+	}
+	return nil
+}
+
+// Covers — покрывает ли разрешение grant канал или шаблон channel.
+// Шаблон покрывается, только если все, что он ловит, ловит и grant.
+func Covers(grant, channel string) bool {
+	if grant == channel {
+		return true
+	}
+	if !IsPattern(grant) {
+		return false
+	}
+
+	gs := strings.Split(grant, segmentSep)
+	cs := strings.Split(channel, segmentSep)
+	for i, g := range gs {
+		if g == wildcardTail {
+			return len(cs) > i // '>' требует хотя бы один сегмент
+		}
+		if i >= len(cs) {
+			return false
+		}
+		switch {
+		case cs[i] == wildcardTail:
+			return false // '>' шире любого одиночного сегмента
+		case g == wildcardOne:
+		case g != cs[i]:
+			return false
+		}
+	}
+	return len(gs) == len(cs)
+}
+
+// Overlaps — есть ли канал, который ловят оба шаблона (или канала) a и b
+func Overlaps(a, b string) bool {
+	as := strings.Split(a, segmentSep)
+	bs := strings.Split(b, segmentSep)
+	for i := 0; ; i++ {
+		switch {
+		case i == len(as) || i == len(bs):
+			return len(as) == len(bs)
+		case as[i] == wildcardTail || bs[i] == wildcardTail:
+			return true // '>' ловит любой непустой хвост
+		case as[i] != bs[i] && as[i] != wildcardOne && bs[i] != wildcardOne:
+			return false
+		}
+	}
+}
+
+// subTrie — подписки по сегментам канала. Публикация проходит только по веткам,
+// совпадающим с каналом, а не перебирает все шаблоны.
+type subTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	clients  map[*Client]bool // Подписки, заканчивающиеся на этом узле
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[string]*trieNode), clients: make(map[*Client]bool)}
+}
+
+func newSubTrie() *subTrie {
+	return &subTrie{root: newTrieNode()}
+}
+
+func (t *subTrie) add(pattern string, client *Client) {
+	n := t.root
+	for _, seg := range strings.Split(pattern, segmentSep) {
+		next, ok := n.children[seg]
+		if !ok {
+			next = newTrieNode()
+			n.children[seg] = next
+		}
+		n = next
+	}
+	n.clients[client] = true
+}
+
+// remove удаляет подписку и чистит опустевшие ветки
+func (t *subTrie) remove(pattern string, client *Client) {
+	segs := strings.Split(pattern, segmentSep)
+	path := make([]*trieNode, 0, len(segs)+1)
+	n := t.root
+	path = append(path, n)
+	for _, seg := range segs {
+		next, ok := n.children[seg]
+		if !ok {
+			return
+		}
+		n = next
+		path = append(path, n)
+	}
+	delete(n.clients, client)
+
+	for i := len(segs) - 1; i >= 0; i-- {
+		node := path[i+1]
+		if len(node.clients) > 0 || len(node.children) > 0 {
+			break
+		}
+		delete(path[i].children, segs[i])
+	}
+}
+
+// match вызывает fn для каждого подписчика канала ровно один раз
+func (t *subTrie) match(channel string, fn func(*Client)) {
+	segs := strings.Split(channel, segmentSep)
+
+	// Частый случай — точная подписка без шаблонов: обходимся без дедупликации
+	var seen map[*Client]bool
+	visit := func(clients map[*Client]bool) {
+		for c := range clients {
+			if seen != nil {
+				if seen[c] {
+					continue
+				}
+				seen[c] = true
+			}
+			fn(c)
+		}
+	}
+
+	var nodes []*trieNode
+	t.collect(t.root, segs, &nodes)
+	if len(nodes) > 1 {
+		seen = make(map[*Client]bool)
+	}
+	for _, n := range nodes {
+		visit(n.clients)
+	}
+}
+
+// collect собирает узлы, подписки которых совпадают с оставшимися сегментами
+func (t *subTrie) collect(n *trieNode, segs []string, out *[]*trieNode) {
+	if len(segs) == 0 {
+		if len(n.clients) > 0 {
+			*out = append(*out, n)
+		}
+		return
+	}
+	if tail, ok := n.children[wildcardTail]; ok && len(tail.clients) > 0 {
+		*out = append(*out, tail)
+	}
+	if next, ok := n.children[segs[0]]; ok {
+		t.collect(next, segs[1:], out)
+	}
+	if segs[0] != wildcardOne {
+		if next, ok := n.children[wildcardOne]; ok {
+			t.collect(next, segs[1:], out)
+		}
+	}
+}
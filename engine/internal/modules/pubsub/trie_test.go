@@ -0,0 +1,169 @@
+package pubsub
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestValidateChannel(t *testing.T) {
+	tests := []struct {
+		channel      string
+		allowPattern bool
+		ok           bool
+	}{
+		{"room.1", false, true},
+		{"", false, false},
+		{"room.*", false, false},
+		{"room.*", true, true},
+		{"tenant.>", true, true},
+		{">", true, true},
+		{"a.>.b", true, false},
+		{"room.a*", true, false},
+		{"room.>x", true, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateChannel(tt.channel, tt.allowPattern); (err == nil) != tt.ok {
+			t.Errorf("ValidateChannel(%q, %v) = %v", tt.channel, tt.allowPattern, err)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		grant, channel string
+		want           bool
+	}{
+		{"room.1", "room.1", true},
+		{"room.1", "room.2", false},
+		{"room.*", "room.1", true},
+		{"room.*", "room", false},
+		{"room.*", "room.1.typing", false},
+		{"room.>", "room.1", true},
+		{"room.>", "room.1.typing", true},
+		{"room.>", "room", false}, // '>' требует хотя бы один сегмент
+		{">", "anything.at.all", true},
+		{"*.orders", "eu.orders", true},
+		{"*.orders", "eu.users", false},
+
+		// Шаблон покрывается, только если grant ловит все, что ловит шаблон
+		{"room.*", "room.*", true},
+		{"room.>", "room.*", true},
+		{"room.>", "room.*.typing", true},
+		{"room.>", "room.>", true},
+		{"room.*", "room.>", false},
+		{"room.1", "room.*", false},
+		{"room.*.typing", "room.>", false},
+		{"*.*", "room.>", false},
+		{"tenant.*.>", "tenant.42.>", true},
+		{"tenant.42.>", "tenant.*.>", false},
+	}
+	for _, tt := range tests {
+		if got := Covers(tt.grant, tt.channel); got != tt.want {
+			t.Errorf("Covers(%q, %q) = %v, want %v", tt.grant, tt.channel, got, tt.want)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"room.1", "room.1", true},
+		{"room.1", "room.2", false},
+		{"room.*", "room.1", true},
+		{"room.*", "*.1", true},
+		{"room.*", "room.1.typing", false},
+		{"room.*", "room", false},
+		{"room.>", "room.1.typing", true},
+		{"room.>", "room", false},
+		{"room.>", "*.*", true},
+		{"room.>", "chat.>", false},
+		{"*.typing", "room.>", true},
+		{"a.*.c", "a.b.*", true},
+		{"a.*.c", "a.b.d", false},
+		{">", "x", true},
+	}
+	for _, tt := range tests {
+		// Отношение симметрично
+		if got := Overlaps(tt.a, tt.b); got != tt.want {
+			t.Errorf("Overlaps(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := Overlaps(tt.b, tt.a); got != tt.want {
+			t.Errorf("Overlaps(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSubTrieMatch(t *testing.T) {
+	a, b, c, d := &Client{userID: "a"}, &Client{userID: "b"}, &Client{userID: "c"}, &Client{userID: "d"}
+	subs := []struct {
+		client  *Client
+		pattern string
+	}{
+		{a, "room.1"},
+		{a, "room.*"}, // Пересекается с room.1 у того же клиента: доставка одна
+		{a, "room.>"},
+		{b, "room.*.typing"},
+		{b, ">"},
+		{c, "tenant.42.>"},
+		{c, "*.42.orders"},
+		{d, "room"},
+	}
+
+	tests := []struct {
+		channel string
+		want    []string
+	}{
+		{"room.1", []string{"a", "b"}},
+		{"room.2", []string{"a", "b"}},
+		{"room", []string{"b", "d"}},
+		{"room.1.typing", []string{"a", "b"}},
+		{"tenant.42.orders", []string{"b", "c"}},
+		{"tenant.42", []string{"b"}},
+		{"eu.42.orders", []string{"b", "c"}},
+		{"eu.43.orders", []string{"b"}},
+		{"x", []string{"b"}},
+	}
+
+	trie := newSubTrie()
+	for _, s := range subs {
+		trie.add(s.pattern, s.client)
+	}
+	for _, tt := range tests {
+		var got []string
+		trie.match(tt.channel, func(cl *Client) { got = append(got, cl.userID) })
+		sort.Strings(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("match(%q) = %v, want %v", tt.channel, got, tt.want)
+		}
+
+		// Trie должен давать то же, что и перебор шаблонов через Covers
+		brute := map[string]bool{}
+		for _, s := range subs {
+			if Covers(s.pattern, tt.channel) {
+				brute[s.client.userID] = true
+			}
+		}
+		if len(brute) != len(got) {
+			t.Errorf("match(%q) = %v, Covers gives %v", tt.channel, got, brute)
+		}
+	}
+
+	// remove: подписки уходят, пустые ветки чистятся
+	trie.remove("room.*", a)
+	trie.remove("room.>", a)
+	trie.remove("no.such.pattern", a) // Нет такой подписки — ничего не ломается
+	var got []string
+	trie.match("room.2", func(cl *Client) { got = append(got, cl.userID) })
+	if !slices.Equal(got, []string{"b"}) {
+		t.Errorf("after remove match(room.2) = %v, want [b]", got)
+	}
+	for _, s := range subs {
+		trie.remove(s.pattern, s.client)
+	}
+	if n := len(trie.root.children); n != 0 {
+		t.Errorf("%d branches left after removing all subscriptions", n)
+	}
+}
@@ -0,0 +1,107 @@
+package queue
+
+import "container/heap"
+
+// Состояния задачи
+const (
+	StateReady    = "ready"    // Ждет воркера
+	StateDelayed  = "delayed"  // Отложена (delay или backoff после ошибки)
+	StateInflight = "inflight" // Выдана воркеру, ждем ack до VisibleAt
+	StateDead     = "dead"     // Исчерпала попытки (dead-letter queue)
+)
+
+// Job — задача в очереди
+type Job struct {
+	ID          string `json:"id"`
+	Queue       string `json:"queue"`
+	Payload     any    `json:"payload"`
+	Priority    int    `json:"priority,omitempty"` // Больше — раньше
+	State       string `json:"state"`
+	Attempts    int    `json:"attempts"` // Сколько раз выдавали воркеру
+	MaxAttempts int    `json:"max_attempts"`
+	BackoffMs   int64  `json:"backoff_ms"` // База экспоненциальной задержки между попытками
+
+	RunAt     int64  `json:"run_at,omitempty"`     // delayed: когда станет ready (unix ms)
+	VisibleAt int64  `json:"visible_at,omitempty"` // inflight: когда аренда истечет и задачу выдадут снова
+	Receipt   string `json:"receipt,omitempty"`    // inflight: токен аренды для ack/nack/extend
+	LastError string `json:"last_error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	DiedAt    int64  `json:"died_at,omitempty"`
+
+	seq   uint64 // Порядок постановки (FIFO внутри приоритета)
+	index int    // Позиция в текущей куче (-1 — не в куче)
+}
+
+// readyHeap — сначала больший приоритет, затем раньше поставленные
+type readyHeap []*Job
+
+func (h readyHeap) Len() int { return len(h) }
+func (h readyHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h readyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *readyHeap) Push(x any) {
+	job := x.(*Job)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+func (h *readyHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	job.index = -1
+	return job
+}
+
+// timeHeap — по времени: RunAt для отложенных, VisibleAt для выданных
+type timeHeap struct {
+	jobs []*Job
+	at   func(*Job) int64
+}
+
+func (h *timeHeap) Len() int { return len(h.jobs) }
+func (h *timeHeap) Less(i, j int) bool {
+	ai, aj := h.at(h.jobs[i]), h.at(h.jobs[j])
+	if ai != aj {
+		return ai < aj
+	}
+	return h.jobs[i].seq < h.jobs[j].seq
+}
+func (h *timeHeap) Swap(i, j int) {
+	h.jobs[i], h.jobs[j] = h.jobs[j], h.jobs[i]
+	h.jobs[i].index, h.jobs[j].index = i, j
+}
+func (h *timeHeap) Push(x any) {
+	job := x.(*Job)
+	job.index = len(h.jobs)
+	h.jobs = append(h.jobs, job)
+}
+func (h *timeHeap) Pop() any {
+	old := h.jobs
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	h.jobs = old[:len(old)-1]
+	job.index = -1
+	return job
+}
+
+// peek — ближайшая по времени задача (nil — куча пуста)
+func (h *timeHeap) peek() *Job {
+	if len(h.jobs) == 0 {
+		return nil
+	}
+	return h.jobs[0]
+}
+
+func (h *timeHeap) remove(job *Job) {
+	if job.index >= 0 && job.index < len(h.jobs) && h.jobs[job.index] == job {
+		heap.Remove(h, job.index)
+	}
+}
@@ -0,0 +1,547 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-engine/internal/pkg/journal"
+	"nexus-engine/internal/pkg/logger"
+)
+
+var (
+	ErrNotFound   = errors.New("job not found")
+	ErrLeaseLost  = errors.New("lease expired or receipt is invalid")
+	ErrBadRequest = errors.New("bad request")
+
+	// ErrQueueNotFound — в очередь еще ничего не ставили (очереди создаются только через Enqueue)
+	ErrQueueNotFound = errors.New("queue not found")
+)
+
+// Options — настройки, передаваемые извне (из флагов CLI)
+type Options struct {
+	PersistPath     string
+	Visibility      time.Duration // Аренда по умолчанию
+	MaxAttempts     int
+	Backoff         time.Duration // База экспоненциальной задержки по умолчанию
+	MaxBackoff      time.Duration
+	TickInterval    time.Duration // Как часто проверять отложенные задачи и истекшие аренды
+	CompactInterval time.Duration
+	Logger          *logger.Logger
+}
+
+// EnqueueRequest — параметры новой задачи
+type EnqueueRequest struct {
+	Queue       string `json:"queue"`
+	Payload     any    `json:"payload"`
+	Priority    int    `json:"priority"`
+	DelayMs     int64  `json:"delay_ms"`     // Выполнить не раньше чем через
+	RunAt       int64  `json:"run_at"`       // Или не раньше момента (unix ms)
+	MaxAttempts int    `json:"max_attempts"` // 0 — по умолчанию
+	BackoffMs   int64  `json:"backoff_ms"`   // 0 — по умолчанию
+}
+
+// Stats — размеры очереди по состояниям
+type Stats struct {
+	Ready    int `json:"ready"`
+	Delayed  int `json:"delayed"`
+	Inflight int `json:"inflight"`
+	Dead     int `json:"dead"`
+}
+
+// record — запись журнала: актуальное состояние задачи либо ее удаление
+type record struct {
+	Job   *Job   `json:"job,omitempty"`
+	Del   string `json:"del,omitempty"`
+	Queue string `json:"queue,omitempty"`
+}
+
+// Manager — все очереди плюс журнал на диске
+type Manager struct {
+	mu      sync.RWMutex
+	queues  map[string]*queue
+	created chan struct{} // Закрывается при создании очереди (будит Dequeue, ждущих несуществующую очередь)
+
+	// Запись в журнал держит RLock, компакция — Lock (как snapshotMu в KV)
+	persistMu sync.RWMutex
+	journal   *journal.Journal
+	seq       atomic.Uint64
+
+	opts Options
+	log  *logger.Logger
+	stop chan struct{}
+}
+
+func NewManager(opts Options) (*Manager, error) {
+	m := &Manager{
+		queues:  make(map[string]*queue),
+		created: make(chan struct{}),
+		opts:    opts,
+		log:     opts.Logger,
+		stop:    make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.PersistPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	j, err := journal.Open(opts.PersistPath)
+	if err != nil {
+		return nil, err
+	}
+	m.journal = j
+	m.log.Info("💾 Queue persistence enabled: %s", opts.PersistPath)
+
+	// Сразу сжимаем журнал до текущего состояния
+	if err := m.Compact(); err != nil {
+		m.log.Error("Queue compaction failed: %v", err)
+	}
+
+	m.startWorkers()
+	return m, nil
+}
+
+// load восстанавливает задачи из журнала: побеждает последняя запись по задаче
+func (m *Manager) load() error {
+	jobs := make(map[string]*Job)
+	err := journal.Replay(m.opts.PersistPath, func(raw json.RawMessage) error {
+		var r record
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil // Пропускаем мусор
+		}
+		switch {
+		case r.Job != nil:
+			jobs[r.Job.Queue+"\x00"+r.Job.ID] = r.Job
+		case r.Del != "":
+			delete(jobs, r.Queue+"\x00"+r.Del)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		seq, err := strconv.ParseUint(job.ID, 10, 64)
+		if err != nil {
+			continue
+		}
+		job.seq = seq
+		if seq > m.seq.Load() {
+			m.seq.Store(seq)
+		}
+		m.queue(job.Queue, true).place(job)
+	}
+	if len(jobs) > 0 {
+		m.log.Info("📦 Restored %d queued jobs", len(jobs))
+	}
+	return nil
+}
+
+// queue возвращает очередь по имени (create — создать, если нет)
+func (m *Manager) queue(name string, create bool) *queue {
+	m.mu.RLock()
+	q, ok := m.queues[name]
+	m.mu.RUnlock()
+	if ok || !create {
+		return q
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if q, ok = m.queues[name]; !ok {
+		q = newQueue(name, m)
+		m.queues[name] = q
+		close(m.created)
+		m.created = make(chan struct{})
+	}
+	return q
+}
+
+// onCreate — канал, который закроется при создании следующей очереди
+func (m *Manager) onCreate() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.created
+}
+
+// withQueue выполняет fn под локом очереди. Создает очередь только create (Enqueue),
+// иначе чтения и опечатки в имени плодили бы пустые очереди навсегда.
+func (m *Manager) withQueue(name string, create bool, fn func(q *queue) error) error {
+	if name == "" {
+		return fmt.Errorf("%w: missing queue", ErrBadRequest)
+	}
+	m.persistMu.RLock()
+	defer m.persistMu.RUnlock()
+
+	q := m.queue(name, create)
+	if q == nil {
+		return ErrQueueNotFound
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return fn(q)
+}
+
+func (m *Manager) persist(job *Job) {
+	if m.journal == nil {
+		return // Восстановление из журнала
+	}
+	if err := m.journal.Append(record{Job: job}); err != nil {
+		m.log.Error("Queue journal error: %v", err)
+	}
+}
+
+func (m *Manager) forget(job *Job) {
+	if m.journal == nil {
+		return
+	}
+	if err := m.journal.Append(record{Del: job.ID, Queue: job.Queue}); err != nil {
+		m.log.Error("Queue journal error: %v", err)
+	}
+}
+
+// backoff — задержка перед следующей попыткой: base * 2^(attempts-1), не больше MaxBackoff
+func (m *Manager) backoff(job *Job) int64 {
+	d := job.BackoffMs
+	for i := 1; i < job.Attempts && d < m.opts.MaxBackoff.Milliseconds(); i++ {
+		d *= 2
+	}
+	return min(d, m.opts.MaxBackoff.Milliseconds())
+}
+
+func (m *Manager) Enqueue(req EnqueueRequest) (Job, error) {
+	if req.Payload == nil {
+		return Job{}, fmt.Errorf("%w: missing payload", ErrBadRequest)
+	}
+	if req.MaxAttempts < 0 || req.BackoffMs < 0 || req.DelayMs < 0 {
+		return Job{}, fmt.Errorf("%w: negative max_attempts, backoff_ms or delay_ms", ErrBadRequest)
+	}
+
+	now := time.Now().UnixMilli()
+	seq := m.seq.Add(1)
+	job := &Job{
+		ID:          strconv.FormatUint(seq, 10),
+		Queue:       req.Queue,
+		Payload:     req.Payload,
+		Priority:    req.Priority,
+		State:       StateReady,
+		MaxAttempts: req.MaxAttempts,
+		BackoffMs:   req.BackoffMs,
+		CreatedAt:   now,
+		seq:         seq,
+		index:       -1,
+	}
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = m.opts.MaxAttempts
+	}
+	if job.BackoffMs == 0 {
+		job.BackoffMs = m.opts.Backoff.Milliseconds()
+	}
+
+	runAt := max(req.RunAt, now+req.DelayMs)
+	if runAt > now {
+		job.State, job.RunAt = StateDelayed, runAt
+	}
+
+	var out Job
+	err := m.withQueue(req.Queue, true, func(q *queue) error {
+		q.place(job)
+		m.persist(job)
+		if job.State == StateReady {
+			q.signal()
+		}
+		out = *job
+		return nil
+	})
+	if err == nil {
+		m.log.Debug("📥 Enqueued job %s/%s (%s)", req.Queue, job.ID, job.State)
+	}
+	return out, err
+}
+
+// Dequeue выдает до count задач с арендой visibility. Если задач нет и wait > 0 — ждет их (long-poll).
+func (m *Manager) Dequeue(ctx context.Context, name string, count int, visibility, wait time.Duration) ([]Job, error) {
+	if count <= 0 {
+		count = 1
+	}
+	if visibility <= 0 {
+		visibility = m.opts.Visibility
+	}
+	deadline := time.Now().Add(wait)
+
+	for {
+		var jobs []Job
+		var wake <-chan struct{}
+		var next int64
+
+		// Канал берем до попытки: очередь, созданная между попыткой и ожиданием, закроет именно его
+		created := m.onCreate()
+		err := m.withQueue(name, false, func(q *queue) error {
+			now := time.Now().UnixMilli()
+			q.promote(now)
+			jobs = q.lease(count, visibility.Milliseconds(), now)
+			wake, next = q.wake, q.nextDue()
+			return nil
+		})
+		if errors.Is(err, ErrQueueNotFound) {
+			// Очереди еще нет — для воркера это просто пустая очередь: ждем первого Enqueue
+			err, jobs, wake = nil, nil, created
+		}
+		if err != nil || len(jobs) > 0 {
+			return jobs, err
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return []Job{}, nil
+		}
+		// Просыпаемся к ближайшей отложенной задаче или истекшей аренде
+		if next > 0 {
+			left = min(left, time.Until(time.UnixMilli(next)))
+		}
+
+		timer := time.NewTimer(max(left, time.Millisecond))
+		select {
+		case <-wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// Ack — задача выполнена, удаляем ее
+func (m *Manager) Ack(name, id, receipt string) error {
+	return m.withQueue(name, false, func(q *queue) error {
+		job, err := q.leased(id, receipt)
+		if err != nil {
+			return err
+		}
+		q.remove(job)
+		return nil
+	})
+}
+
+// Nack — попытка не удалась: повтор с backoff (или через delayMs, если >= 0) либо DLQ
+func (m *Manager) Nack(name, id, receipt, reason string, delayMs int64) error {
+	return m.withQueue(name, false, func(q *queue) error {
+		job, err := q.leased(id, receipt)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			reason = "nack"
+		}
+		q.fail(job, reason, delayMs, time.Now().UnixMilli())
+		return nil
+	})
+}
+
+// Extend продлевает аренду долгой задачи (heartbeat воркера)
+func (m *Manager) Extend(name, id, receipt string, visibility time.Duration) (Job, error) {
+	if visibility <= 0 {
+		visibility = m.opts.Visibility
+	}
+	var out Job
+	err := m.withQueue(name, false, func(q *queue) error {
+		job, err := q.leased(id, receipt)
+		if err != nil {
+			return err
+		}
+		q.inflight.remove(job)
+		job.VisibleAt = time.Now().UnixMilli() + visibility.Milliseconds()
+		q.place(job)
+		m.persist(job)
+		out = *job
+		return nil
+	})
+	return out, err
+}
+
+func (m *Manager) Get(name, id string) (Job, error) {
+	var out Job
+	err := m.withQueue(name, false, func(q *queue) error {
+		job, ok := q.jobs[id]
+		if !ok {
+			return ErrNotFound
+		}
+		out = *job
+		return nil
+	})
+	return out, err
+}
+
+// Stats — по очереди name или по всем (name == "")
+func (m *Manager) Stats(name string) (map[string]Stats, error) {
+	out := make(map[string]Stats)
+	if name != "" {
+		err := m.withQueue(name, false, func(q *queue) error {
+			out[name] = q.stats()
+			return nil
+		})
+		if errors.Is(err, ErrQueueNotFound) {
+			return out, nil // Нет очереди — нечего и показывать
+		}
+		return out, err
+	}
+
+	for _, q := range m.all() {
+		q.mu.Lock()
+		out[q.name] = q.stats()
+		q.mu.Unlock()
+	}
+	return out, nil
+}
+
+// Dead возвращает до limit задач из DLQ
+func (m *Manager) Dead(name string, limit int) ([]Job, error) {
+	var out []Job
+	err := m.withQueue(name, false, func(q *queue) error {
+		out = []Job{}
+		for _, job := range q.deadJobs() {
+			if limit > 0 && len(out) >= limit {
+				break
+			}
+			out = append(out, *job)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrQueueNotFound) {
+		return []Job{}, nil
+	}
+	return out, err
+}
+
+// Requeue возвращает задачи из DLQ в очередь с обнуленными попытками (ids пусто — все)
+func (m *Manager) Requeue(name string, ids []string) (int, error) {
+	n := 0
+	err := m.withQueue(name, false, func(q *queue) error {
+		for _, job := range m.pick(q, ids) {
+			job.Attempts, job.DiedAt = 0, 0
+			q.move(job, StateReady)
+			n++
+		}
+		return nil
+	})
+	if errors.Is(err, ErrQueueNotFound) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// Purge удаляет задачи из DLQ (ids пусто — все)
+func (m *Manager) Purge(name string, ids []string) (int, error) {
+	n := 0
+	err := m.withQueue(name, false, func(q *queue) error {
+		for _, job := range m.pick(q, ids) {
+			q.remove(job)
+			n++
+		}
+		return nil
+	})
+	if errors.Is(err, ErrQueueNotFound) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// pick — задачи DLQ по списку id (пустой — все)
+func (m *Manager) pick(q *queue, ids []string) []*Job {
+	if len(ids) == 0 {
+		return q.deadJobs()
+	}
+	var out []*Job
+	for _, id := range ids {
+		if job, ok := q.jobs[id]; ok && job.State == StateDead {
+			out = append(out, job)
+		}
+	}
+	return out
+}
+
+func (m *Manager) all() []*queue {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]*queue, 0, len(m.queues))
+	for _, q := range m.queues {
+		out = append(out, q)
+	}
+	return out
+}
+
+func (m *Manager) startWorkers() {
+	go func() {
+		ticker := time.NewTicker(m.opts.TickInterval)
+		defer ticker.Stop()
+
+		var compactC <-chan time.Time
+		if m.opts.CompactInterval > 0 {
+			compactTicker := time.NewTicker(m.opts.CompactInterval)
+			defer compactTicker.Stop()
+			compactC = compactTicker.C
+		}
+
+		for {
+			select {
+			case <-ticker.C:
+				m.tick()
+			case <-compactC:
+				if err := m.Compact(); err != nil {
+					m.log.Error("Queue compaction failed: %v", err)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// tick двигает отложенные задачи и истекшие аренды даже если никто не делает dequeue
+func (m *Manager) tick() {
+	now := time.Now().UnixMilli()
+	for _, q := range m.all() {
+		m.persistMu.RLock()
+		q.mu.Lock()
+		q.promote(now)
+		q.mu.Unlock()
+		m.persistMu.RUnlock()
+	}
+}
+
+// Compact переписывает журнал текущими задачами
+func (m *Manager) Compact() error {
+	m.persistMu.Lock()
+	defer m.persistMu.Unlock()
+
+	var records []any
+	for _, q := range m.all() {
+		q.mu.Lock()
+		for _, job := range q.jobs {
+			cp := *job
+			records = append(records, record{Job: &cp})
+		}
+		q.mu.Unlock()
+	}
+	return m.journal.Rewrite(records)
+}
+
+func (m *Manager) Close() error {
+	close(m.stop)
+	if err := m.Compact(); err != nil {
+		m.log.Error("Queue compaction failed: %v", err)
+	}
+	return m.journal.Close()
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"nexus-engine/internal/core"
+	"nexus-engine/internal/pkg/logger"
+)
+
+// Убеждаемся, что Module реализует интерфейс core.Module
+var _ core.Module = (*Module)(nil)
+
+type Module struct {
+	manager *Manager
+	log     *logger.Logger
+
+	// Флаги CLI
+	fDataDir         *string
+	fVisibility      *int
+	fMaxAttempts     *int
+	fBackoff         *int
+	fMaxBackoff      *int
+	fCompactInterval *int
+}
+
+func NewModule() *Module {
+	return &Module{}
+}
+
+func (m *Module) Name() string {
+	return "Queue"
+}
+
+func (m *Module) RegisterFlags(fs *flag.FlagSet) {
+	m.fDataDir = fs.String("queue-data-dir", "./data", "Directory for queue persistence")
+
+	m.fVisibility = fs.Int("queue-visibility-timeout", 30, "Default lease in seconds before an unacknowledged job is redelivered")
+	m.fMaxAttempts = fs.Int("queue-max-attempts", 5, "Default attempts before a job goes to the dead-letter queue")
+	m.fBackoff = fs.Int("queue-backoff", 1000, "Default base retry delay in milliseconds (doubles each attempt)")
+	m.fMaxBackoff = fs.Int("queue-max-backoff", 3600, "Max retry delay in seconds")
+	m.fCompactInterval = fs.Int("queue-compact-interval", 60, "Interval in seconds to compact the queue journal")
+}
+
+func (m *Module) Init(log *logger.Logger) error {
+	m.log = log
+
+	var err error
+	m.manager, err = NewManager(Options{
+		PersistPath:     *m.fDataDir + "/queue.journal",
+		Visibility:      time.Duration(*m.fVisibility) * time.Second,
+		MaxAttempts:     max(1, *m.fMaxAttempts),
+		Backoff:         time.Duration(*m.fBackoff) * time.Millisecond,
+		MaxBackoff:      time.Duration(*m.fMaxBackoff) * time.Second,
+		TickInterval:    time.Second,
+		CompactInterval: time.Duration(*m.fCompactInterval) * time.Second,
+		Logger:          log,
+	})
+	return err
+}
+
+func (m *Module) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/queue/enqueue", m.handleEnqueue)
+	mux.HandleFunc("/queue/dequeue", m.handleDequeue)
+	mux.HandleFunc("/queue/ack", m.handleAck)
+	mux.HandleFunc("/queue/nack", m.handleNack)
+	mux.HandleFunc("/queue/extend", m.handleExtend)
+	mux.HandleFunc("/queue/job", m.handleJob)
+	mux.HandleFunc("/queue/stats", m.handleStats)
+	mux.HandleFunc("/queue/dead", m.handleDead)
+	mux.HandleFunc("/queue/dead/requeue", m.handleRequeue)
+	mux.HandleFunc("/queue/dead/purge", m.handlePurge)
+}
+
+func (m *Module) Shutdown() {
+	if m.manager != nil {
+		m.log.Info("Stopping Queue...")
+		m.manager.Close()
+	}
+}
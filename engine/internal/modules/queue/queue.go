@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"container/heap"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// queue — одна именованная очередь. Все методы вызываются под q.mu (см. Manager.withQueue).
+type queue struct {
+	mu   sync.Mutex
+	name string
+	m    *Manager
+
+	jobs     map[string]*Job
+	ready    readyHeap
+	delayed  timeHeap
+	inflight timeHeap
+	dead     int
+
+	wake chan struct{} // Закрывается, когда появляются ready задачи
+}
+
+func newQueue(name string, m *Manager) *queue {
+	return &queue{
+		name:     name,
+		m:        m,
+		jobs:     make(map[string]*Job),
+		delayed:  timeHeap{at: func(j *Job) int64 { return j.RunAt }},
+		inflight: timeHeap{at: func(j *Job) int64 { return j.VisibleAt }},
+		wake:     make(chan struct{}),
+	}
+}
+
+// place кладет задачу в структуру, соответствующую ее состоянию
+func (q *queue) place(job *Job) {
+	q.jobs[job.ID] = job
+	job.index = -1
+	switch job.State {
+	case StateReady:
+		heap.Push(&q.ready, job)
+	case StateDelayed:
+		heap.Push(&q.delayed, job)
+	case StateInflight:
+		heap.Push(&q.inflight, job)
+	case StateDead:
+		q.dead++
+	}
+}
+
+// take вынимает задачу из текущей структуры (но не из jobs)
+func (q *queue) take(job *Job) {
+	switch job.State {
+	case StateReady:
+		if job.index >= 0 {
+			heap.Remove(&q.ready, job.index)
+		}
+	case StateDelayed:
+		q.delayed.remove(job)
+	case StateInflight:
+		q.inflight.remove(job)
+	case StateDead:
+		q.dead--
+	}
+}
+
+// move переводит задачу в новое состояние и записывает его в журнал
+func (q *queue) move(job *Job, state string) {
+	q.take(job)
+	job.State = state
+	if state != StateInflight {
+		job.VisibleAt, job.Receipt = 0, ""
+	}
+	if state != StateDelayed {
+		job.RunAt = 0
+	}
+	q.place(job)
+	q.m.persist(job)
+
+	if state == StateReady {
+		q.signal()
+	}
+}
+
+func (q *queue) remove(job *Job) {
+	q.take(job)
+	delete(q.jobs, job.ID)
+	q.m.forget(job)
+}
+
+// signal будит ждущих dequeue
+func (q *queue) signal() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// promote переводит созревшие отложенные задачи в ready, а выданные с истекшей арендой — в retry/DLQ
+func (q *queue) promote(now int64) {
+	for job := q.delayed.peek(); job != nil && job.RunAt <= now; job = q.delayed.peek() {
+		q.move(job, StateReady)
+	}
+	for job := q.inflight.peek(); job != nil && job.VisibleAt <= now; job = q.inflight.peek() {
+		q.fail(job, "visibility timeout expired", -1, now)
+	}
+}
+
+// fail — неудачная попытка: повтор с экспоненциальной задержкой или DLQ, если попытки кончились.
+// delayMs < 0 — задержку считаем сами.
+func (q *queue) fail(job *Job, reason string, delayMs int64, now int64) {
+	job.LastError = reason
+	if job.Attempts >= job.MaxAttempts {
+		job.DiedAt = now
+		q.move(job, StateDead)
+		q.m.log.Debug("💀 Job %s/%s moved to dead-letter queue after %d attempts: %s", q.name, job.ID, job.Attempts, reason)
+		return
+	}
+
+	if delayMs < 0 {
+		delayMs = q.m.backoff(job)
+	}
+	if delayMs == 0 {
+		q.move(job, StateReady)
+		return
+	}
+	job.RunAt = now + delayMs
+	q.move(job, StateDelayed)
+}
+
+// lease выдает до count ready задач с арендой на visibilityMs
+func (q *queue) lease(count int, visibilityMs, now int64) []Job {
+	var out []Job
+	for len(out) < count && q.ready.Len() > 0 {
+		job := q.ready[0]
+		job.Attempts++
+		job.VisibleAt = now + visibilityMs
+		job.Receipt = job.ID + "." + strconv.Itoa(job.Attempts)
+		q.move(job, StateInflight)
+		out = append(out, *job)
+	}
+	return out
+}
+
+// nextDue — ближайший момент, когда что-то изменится само (0 — ничего не запланировано)
+func (q *queue) nextDue() int64 {
+	var next int64
+	if job := q.delayed.peek(); job != nil {
+		next = job.RunAt
+	}
+	if job := q.inflight.peek(); job != nil && (next == 0 || job.VisibleAt < next) {
+		next = job.VisibleAt
+	}
+	return next
+}
+
+// leased находит выданную задачу по токену аренды
+func (q *queue) leased(id, receipt string) (*Job, error) {
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.State != StateInflight || job.Receipt != receipt {
+		return nil, ErrLeaseLost
+	}
+	return job, nil
+}
+
+func (q *queue) stats() Stats {
+	return Stats{
+		Ready:    q.ready.Len(),
+		Delayed:  q.delayed.Len(),
+		Inflight: q.inflight.Len(),
+		Dead:     q.dead,
+	}
+}
+
+// deadJobs — содержимое DLQ, старые первыми
+func (q *queue) deadJobs() []*Job {
+	var out []*Job
+	for _, job := range q.jobs {
+		if job.State == StateDead {
+			out = append(out, job)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DiedAt != out[j].DiedAt {
+			return out[i].DiedAt < out[j].DiedAt
+		}
+		return out[i].seq < out[j].seq
+	})
+	return out
+}
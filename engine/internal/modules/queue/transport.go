@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// leaseRequest — общий запрос для dequeue/ack/nack/extend
+type leaseRequest struct {
+	Queue        string `json:"queue"`
+	ID           string `json:"id"`
+	Receipt      string `json:"receipt"`
+	Count        int    `json:"count"`         // dequeue
+	VisibilityMs int64  `json:"visibility_ms"` // dequeue, extend (0 — по умолчанию)
+	WaitMs       int64  `json:"wait_ms"`       // dequeue: long-poll
+	Error        string `json:"error"`         // nack: причина
+	DelayMs      *int64 `json:"delay_ms"`      // nack: своя задержка вместо backoff
+}
+
+type idsRequest struct {
+	Queue string   `json:"queue"`
+	IDs   []string `json:"ids"` // Пусто — все
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrQueueNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrLeaseLost):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrBadRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal Error", http.StatusInternalServerError)
+	}
+}
+
+func (m *Module) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req EnqueueRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	job, err := m.manager.Enqueue(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, job)
+}
+
+func (m *Module) handleDequeue(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	jobs, err := m.manager.Dequeue(r.Context(), req.Queue, req.Count,
+		time.Duration(req.VisibilityMs)*time.Millisecond,
+		time.Duration(req.WaitMs)*time.Millisecond)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return // Клиент ушел, пока ждал
+		}
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+func (m *Module) handleAck(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := m.manager.Ack(req.Queue, req.ID, req.Receipt); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"success\":true}")
+}
+
+func (m *Module) handleNack(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	delay := int64(-1)
+	if req.DelayMs != nil {
+		delay = max(0, *req.DelayMs)
+	}
+	if err := m.manager.Nack(req.Queue, req.ID, req.Receipt, req.Error, delay); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"success\":true}")
+}
+
+func (m *Module) handleExtend(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	job, err := m.manager.Extend(req.Queue, req.ID, req.Receipt, time.Duration(req.VisibilityMs)*time.Millisecond)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, job)
+}
+
+func (m *Module) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, err := m.manager.Get(r.URL.Query().Get("queue"), r.URL.Query().Get("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, job)
+}
+
+func (m *Module) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := m.manager.Stats(r.URL.Query().Get("queue"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+// GET /queue/dead?queue=emails&limit=100 — содержимое dead-letter queue
+func (m *Module) handleDead(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	jobs, err := m.manager.Dead(r.URL.Query().Get("queue"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+func (m *Module) handleRequeue(w http.ResponseWriter, r *http.Request) {
+	var req idsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	n, err := m.manager.Requeue(req.Queue, req.IDs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int{"requeued": n})
+}
+
+func (m *Module) handlePurge(w http.ResponseWriter, r *http.Request) {
+	var req idsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	n, err := m.manager.Purge(req.Queue, req.IDs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int{"purged": n})
+}
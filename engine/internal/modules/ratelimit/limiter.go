@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Алгоритмы
+const (
+	AlgoTokenBucket   = "token_bucket"
+	AlgoSlidingWindow = "sliding_window"
+	AlgoGCRA          = "gcra"
+)
+
+// Предел на размер лога sliding window (храним по метке на каждый запрос)
+const maxWindowLimit = 10000
+
+var ErrBadLimit = errors.New("bad limit definition")
+
+// Limit — именованное правило: не больше Limit запросов за Period
+type Limit struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`       // token_bucket, sliding_window, gcra
+	Limit     int    `json:"limit"`           // Запросов за период
+	PeriodMs  int64  `json:"period_ms"`       // Длина периода
+	Burst     int    `json:"burst,omitempty"` // token_bucket/gcra: сколько можно сразу (по умолчанию = limit)
+}
+
+func (l *Limit) validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("%w: empty name", ErrBadLimit)
+	}
+	if l.Limit <= 0 || l.PeriodMs <= 0 {
+		return fmt.Errorf("%w: limit and period_ms must be positive", ErrBadLimit)
+	}
+	switch l.Algorithm {
+	case AlgoTokenBucket, AlgoGCRA:
+	case AlgoSlidingWindow:
+		if l.Limit > maxWindowLimit {
+			return fmt.Errorf("%w: sliding_window limit must be <= %d", ErrBadLimit, maxWindowLimit)
+		}
+	default:
+		return fmt.Errorf("%w: unknown algorithm %q", ErrBadLimit, l.Algorithm)
+	}
+	if l.Burst <= 0 {
+		l.Burst = l.Limit
+	}
+	return nil
+}
+
+func (l *Limit) period() int64 { return l.PeriodMs * int64(time.Millisecond) }
+
+// Result — ответ на check-and-consume
+type Result struct {
+	Allowed    bool  `json:"allowed"`
+	Limit      int   `json:"limit"`
+	Remaining  int   `json:"remaining"`
+	RetryAfter int64 `json:"retry_after_ms"` // Когда повторить (0 — если разрешено)
+	ResetAfter int64 `json:"reset_after_ms"` // Когда квота восстановится полностью
+}
+
+// state — состояние одного ключа. Используются поля своего алгоритма.
+type state struct {
+	Algo    string  `json:"algo"`
+	Tokens  float64 `json:"tokens,omitempty"` // token_bucket
+	Last    int64   `json:"last,omitempty"`   // token_bucket: время последнего пополнения
+	Log     []int64 `json:"log,omitempty"`    // sliding_window: метки запросов
+	TAT     int64   `json:"tat,omitempty"`    // gcra: theoretical arrival time
+	Expires int64   `json:"exp"`              // Когда состояние станет "как новое" и его можно выкинуть
+}
+
+// consume применяет cost к состоянию (st изменяется на месте). now — UnixNano.
+func consume(l *Limit, st *state, now int64, cost int) Result {
+	switch l.Algorithm {
+	case AlgoTokenBucket:
+		return tokenBucket(l, st, now, cost)
+	case AlgoSlidingWindow:
+		return slidingWindow(l, st, now, cost)
+	default:
+		return gcra(l, st, now, cost)
+	}
+}
+
+func ms(ns float64) int64 {
+	if ns <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(ns / float64(time.Millisecond)))
+}
+
+// tokenBucket: емкость Burst, пополнение Limit токенов за период
+func tokenBucket(l *Limit, st *state, now int64, cost int) Result {
+	capacity := float64(l.Burst)
+	rate := float64(l.Limit) / float64(l.period()) // токенов в наносекунду
+
+	if st.Last == 0 {
+		st.Tokens = capacity
+	} else if elapsed := now - st.Last; elapsed > 0 {
+		st.Tokens = math.Min(capacity, st.Tokens+float64(elapsed)*rate)
+	}
+	st.Last = now
+
+	res := Result{Limit: l.Limit}
+	if st.Tokens >= float64(cost) {
+		st.Tokens -= float64(cost)
+		res.Allowed = true
+	} else if float64(cost) > capacity {
+		res.RetryAfter = -1 // Не пройдет никогда
+	} else {
+		res.RetryAfter = ms((float64(cost) - st.Tokens) / rate)
+	}
+
+	res.Remaining = int(math.Floor(st.Tokens))
+	full := (capacity - st.Tokens) / rate
+	res.ResetAfter = ms(full)
+	st.Expires = now + int64(full)
+	return res
+}
+
+// slidingWindow: точный лог запросов за последний период
+func slidingWindow(l *Limit, st *state, now int64, cost int) Result {
+	period := l.period()
+
+	// Выкидываем метки, вышедшие из окна
+	cut := sort.Search(len(st.Log), func(i int) bool { return st.Log[i] > now-period })
+	st.Log = st.Log[cut:]
+
+	res := Result{Limit: l.Limit}
+	switch {
+	case len(st.Log)+cost <= l.Limit:
+		for i := 0; i < cost; i++ {
+			st.Log = append(st.Log, now)
+		}
+		res.Allowed = true
+	case cost > l.Limit:
+		res.RetryAfter = -1
+	default:
+		// Нужно, чтобы из окна ушло столько меток, чтобы поместился cost
+		need := len(st.Log) + cost - l.Limit
+		res.RetryAfter = ms(float64(st.Log[need-1] + period - now))
+	}
+
+	res.Remaining = l.Limit - len(st.Log)
+	if n := len(st.Log); n > 0 {
+		res.ResetAfter = ms(float64(st.Log[n-1] + period - now))
+		st.Expires = st.Log[n-1] + period
+	} else {
+		st.Expires = now
+	}
+	return res
+}
+
+// gcra: Generic Cell Rate Algorithm — равномерный поток с допуском на Burst
+func gcra(l *Limit, st *state, now int64, cost int) Result {
+	interval := float64(l.period()) / float64(l.Limit) // "стоимость" одного запроса во времени
+	tolerance := interval * float64(l.Burst)
+
+	tat := max(st.TAT, now)
+	newTAT := float64(tat) + interval*float64(cost)
+	allowAt := newTAT - tolerance
+
+	res := Result{Limit: l.Limit}
+	if cost > l.Burst {
+		res.RetryAfter = -1
+	} else if float64(now) < allowAt {
+		res.RetryAfter = ms(allowAt - float64(now))
+	} else {
+		st.TAT = int64(newTAT)
+		res.Allowed = true
+	}
+
+	cur := float64(max(st.TAT, now))
+	res.Remaining = max(0, int(math.Floor((float64(now)-(cur-tolerance))/interval)))
+	res.ResetAfter = ms(cur - float64(now))
+	st.Expires = int64(cur)
+	return res
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"nexus-engine/internal/core"
+	"nexus-engine/internal/pkg/logger"
+)
+
+// Убеждаемся, что Module реализует интерфейс core.Module
+var _ core.Module = (*Module)(nil)
+
+type Module struct {
+	store *Store
+	log   *logger.Logger
+	stop  chan struct{}
+
+	// Флаги CLI
+	fLimits          *string
+	fPersist         *bool
+	fDataDir         *string
+	fCleanupInterval *int
+	fCompactInterval *int
+}
+
+func NewModule() *Module {
+	return &Module{}
+}
+
+func (m *Module) Name() string {
+	return "RateLimit"
+}
+
+func (m *Module) RegisterFlags(fs *flag.FlagSet) {
+	m.fLimits = fs.String("ratelimit-limits", "", "Path to JSON file with predefined limits")
+	m.fPersist = fs.Bool("ratelimit-persist", false, "Persist limiter state to disk (survives restarts)")
+	m.fDataDir = fs.String("ratelimit-data-dir", "./data", "Directory for rate limiter persistence")
+
+	// Интервалы в секундах
+	m.fCleanupInterval = fs.Int("ratelimit-cleanup-interval", 10, "Interval in seconds to drop idle limiter state")
+	m.fCompactInterval = fs.Int("ratelimit-compact-interval", 60, "Interval in seconds to compact the limiter journal")
+}
+
+func (m *Module) Init(log *logger.Logger) error {
+	m.log = log
+	m.store = NewStore(log)
+	m.stop = make(chan struct{})
+
+	if *m.fPersist {
+		if err := os.MkdirAll(*m.fDataDir, 0755); err != nil {
+			return err
+		}
+		if err := m.store.EnablePersistence(*m.fDataDir + "/ratelimit.journal"); err != nil {
+			return err
+		}
+	}
+
+	// Лимиты из файла перекрывают сохраненные через API
+	if *m.fLimits != "" {
+		data, err := os.ReadFile(*m.fLimits)
+		if err != nil {
+			return err
+		}
+		var limits []Limit
+		if err := json.Unmarshal(data, &limits); err != nil {
+			return fmt.Errorf("bad limits config %s: %w", *m.fLimits, err)
+		}
+		for _, l := range limits {
+			if err := m.store.SetLimit(l); err != nil {
+				return fmt.Errorf("limit %q: %w", l.Name, err)
+			}
+		}
+		log.Info("🚦 Loaded %d rate limits from %s", len(limits), *m.fLimits)
+	}
+
+	m.startWorkers()
+	return nil
+}
+
+func (m *Module) startWorkers() {
+	cleanup := time.Duration(*m.fCleanupInterval) * time.Second
+	if cleanup <= 0 {
+		cleanup = 10 * time.Second
+	}
+	compact := time.Duration(*m.fCompactInterval) * time.Second
+
+	go func() {
+		cleanupTicker := time.NewTicker(cleanup)
+		defer cleanupTicker.Stop()
+
+		// Без персистентности компактить нечего — тикер просто не сработает
+		var compactC <-chan time.Time
+		if *m.fPersist && compact > 0 {
+			compactTicker := time.NewTicker(compact)
+			defer compactTicker.Stop()
+			compactC = compactTicker.C
+		}
+
+		for {
+			select {
+			case <-cleanupTicker.C:
+				m.store.Cleanup()
+			case <-compactC:
+				if err := m.store.Compact(); err != nil {
+					m.log.Error("Rate limit compaction failed: %v", err)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Module) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/ratelimit/limits", m.handleLimits)
+	mux.HandleFunc("/ratelimit/check", m.handleCheck)
+}
+
+func (m *Module) Shutdown() {
+	if m.store != nil {
+		m.log.Info("Stopping Rate Limiter...")
+		close(m.stop)
+		m.store.Close()
+	}
+}
@@ -0,0 +1,254 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"nexus-engine/internal/pkg/journal"
+	"nexus-engine/internal/pkg/logger"
+)
+
+const ShardCount = 32
+
+var ErrUnknownLimit = errors.New("unknown limit")
+
+type shard struct {
+	mu     sync.Mutex
+	states map[string]*state // limit + "\x00" + key
+}
+
+// record — запись журнала: последнее состояние ключа, попадание в sliding window
+// либо определение лимита
+type record struct {
+	Key   string `json:"k,omitempty"`
+	State *state `json:"s,omitempty"`
+	Hit   *hit   `json:"h,omitempty"`
+	Limit *Limit `json:"l,omitempty"`
+}
+
+// hit — принятый запрос sliding window. Лог окна целиком в журнал не пишем (до limit
+// меток на каждый запрос): при replay он собирается из попаданий.
+type hit struct {
+	At   int64 `json:"at"`
+	Cost int   `json:"n"`
+	Exp  int64 `json:"exp"` // At + период окна
+}
+
+// replayHit добавляет попадание к логу окна, выкидывая метки, вышедшие из окна
+func replayHit(st *state, h *hit) {
+	cut := sort.Search(len(st.Log), func(i int) bool { return st.Log[i] > h.At-(h.Exp-h.At) })
+	st.Log = st.Log[cut:]
+	for i := 0; i < h.Cost && len(st.Log) < maxWindowLimit; i++ {
+		st.Log = append(st.Log, h.At)
+	}
+	st.Expires = h.Exp
+}
+
+// Store — состояния лимитов в шардированной памяти, опционально с журналом на диске
+type Store struct {
+	shards [ShardCount]*shard
+	log    *logger.Logger
+
+	limitsMu sync.RWMutex
+	limits   map[string]*Limit
+
+	// Запись в журнал держит RLock, компакция — Lock: иначе записи между сбором состояния
+	// и Rewrite пропали бы после рестарта (как persistMu в очередях)
+	persistMu sync.RWMutex
+	journal   *journal.Journal // nil — без персистентности
+}
+
+func NewStore(log *logger.Logger) *Store {
+	s := &Store{log: log, limits: make(map[string]*Limit)}
+	for i := range s.shards {
+		s.shards[i] = &shard{states: make(map[string]*state)}
+	}
+	return s
+}
+
+// EnablePersistence поднимает состояния из журнала и начинает в него писать
+func (s *Store) EnablePersistence(path string) error {
+	now := time.Now().UnixNano()
+	restored := 0
+	err := journal.Replay(path, func(raw json.RawMessage) error {
+		var r record
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil // Пропускаем мусор
+		}
+		if r.Limit != nil {
+			if r.Limit.validate() == nil {
+				s.limits[r.Limit.Name] = r.Limit
+			}
+			return nil
+		}
+		sh := s.shardFor(r.Key)
+		switch {
+		case r.Hit != nil:
+			if r.Hit.Exp <= now {
+				delete(sh.states, r.Key)
+				return nil
+			}
+			st, ok := sh.states[r.Key]
+			if !ok || st.Algo != AlgoSlidingWindow {
+				st = &state{Algo: AlgoSlidingWindow}
+				sh.states[r.Key] = st
+				restored++
+			}
+			replayHit(st, r.Hit)
+		case r.State != nil:
+			if r.State.Expires <= now {
+				delete(sh.states, r.Key)
+				return nil
+			}
+			if _, ok := sh.states[r.Key]; !ok {
+				restored++
+			}
+			sh.states[r.Key] = r.State
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.journal, err = journal.Open(path); err != nil {
+		return err
+	}
+	s.log.Info("💾 Rate limit persistence enabled: %s (%d states restored)", path, restored)
+	return s.Compact()
+}
+
+func (s *Store) shardFor(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%ShardCount]
+}
+
+// SetLimit добавляет или заменяет правило
+func (s *Store) SetLimit(l Limit) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	s.persistMu.RLock()
+	defer s.persistMu.RUnlock()
+
+	s.limitsMu.Lock()
+	s.limits[l.Name] = &l
+	s.limitsMu.Unlock()
+
+	if s.journal != nil {
+		return s.journal.Append(record{Limit: &l})
+	}
+	return nil
+}
+
+// Limits возвращает все правила по имени
+func (s *Store) Limits() []Limit {
+	s.limitsMu.RLock()
+	defer s.limitsMu.RUnlock()
+
+	out := make([]Limit, 0, len(s.limits))
+	for _, l := range s.limits {
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Check атомарно проверяет и списывает cost из квоты ключа по правилу name
+func (s *Store) Check(name, key string, cost int) (Result, error) {
+	s.limitsMu.RLock()
+	l, ok := s.limits[name]
+	s.limitsMu.RUnlock()
+	if !ok {
+		return Result{}, ErrUnknownLimit
+	}
+	if cost <= 0 {
+		cost = 1
+	}
+
+	id := name + "\x00" + key
+	sh := s.shardFor(id)
+	now := time.Now().UnixNano()
+
+	s.persistMu.RLock()
+	defer s.persistMu.RUnlock()
+
+	sh.mu.Lock()
+	st, ok := sh.states[id]
+	// Правило поменяли на другой алгоритм — старое состояние бессмысленно
+	if !ok || st.Algo != l.Algorithm {
+		st = &state{Algo: l.Algorithm}
+		sh.states[id] = st
+	}
+	res := consume(l, st, now, cost)
+
+	if s.journal != nil && res.Allowed {
+		rec := record{Key: id, State: st}
+		if st.Algo == AlgoSlidingWindow {
+			rec = record{Key: id, Hit: &hit{At: now, Cost: cost, Exp: st.Expires}}
+		}
+		if err := s.journal.Append(rec); err != nil {
+			s.log.Error("Rate limit journal error: %v", err)
+		}
+	}
+	sh.mu.Unlock()
+
+	return res, nil
+}
+
+// Cleanup удаляет состояния, которые уже полностью восстановились
+func (s *Store) Cleanup() {
+	now := time.Now().UnixNano()
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for id, st := range sh.states {
+			if st.Expires <= now {
+				delete(sh.states, id)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Compact переписывает журнал текущими живыми состояниями
+func (s *Store) Compact() error {
+	if s.journal == nil {
+		return nil
+	}
+
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	now := time.Now().UnixNano()
+	var records []any
+	for _, l := range s.Limits() {
+		records = append(records, record{Limit: &l})
+	}
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for id, st := range sh.states {
+			if st.Expires > now {
+				cp := *st
+				cp.Log = append([]int64(nil), st.Log...)
+				records = append(records, record{Key: id, State: &cp})
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return s.journal.Rewrite(records)
+}
+
+func (s *Store) Close() error {
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.Compact(); err != nil {
+		s.log.Error("Rate limit compaction failed: %v", err)
+	}
+	return s.journal.Close()
+}
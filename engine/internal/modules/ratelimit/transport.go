@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type checkRequest struct {
+	Limit string `json:"limit"`
+	Key   string `json:"key"`
+	Cost  int    `json:"cost"` // По умолчанию 1
+}
+
+// GET — список лимитов, POST — создать или заменить лимит
+func (m *Module) handleLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.store.Limits())
+
+	case http.MethodPost:
+		var l Limit
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, "Bad JSON", http.StatusBadRequest)
+			return
+		}
+		if err := m.store.SetLimit(l); err != nil {
+			if errors.Is(err, ErrBadLimit) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Internal Error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "{\"success\":true}")
+
+	default:
+		http.Error(w, "Only GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// Check-and-consume. Отказ — это не ошибка: 200 с allowed=false и Retry-After,
+// чтобы клиент мог отличить "лимит исчерпан" от проблем с самим сервисом.
+func (m *Module) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req checkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Limit == "" || req.Key == "" {
+		http.Error(w, "Missing limit or key", http.StatusBadRequest)
+		return
+	}
+	if req.Cost < 0 {
+		http.Error(w, "Negative cost", http.StatusBadRequest)
+		return
+	}
+
+	res, err := m.store.Check(req.Limit, req.Key, req.Cost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	if !res.Allowed && res.RetryAfter > 0 {
+		// Retry-After в секундах, округляем вверх
+		w.Header().Set("Retry-After", strconv.FormatInt((res.RetryAfter+999)/1000, 10))
+	}
+	json.NewEncoder(w).Encode(res)
+}
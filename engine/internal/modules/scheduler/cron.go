@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron — разобранное выражение из 5 полей: минута час день-месяца месяц день-недели.
+// Поддерживаются *, списки (1,15), диапазоны (1-5), шаги (*/10, 8-18/2), имена (JAN, MON)
+// и сокращения @hourly, @daily, @weekly, @monthly, @yearly, @every <duration>.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // Битовые маски допустимых значений
+	domStar, dowStar              bool
+	every                         time.Duration
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	fieldMinute = cronField{0, 59, nil}
+	fieldHour   = cronField{0, 23, nil}
+	fieldDom    = cronField{1, 31, nil}
+	fieldMonth  = cronField{1, 12, map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	fieldDow = cronField{0, 7, map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if rest, ok := strings.CutPrefix(expr, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("bad @every duration %q (min 1s)", rest)
+		}
+		return &Cron{every: d}, nil
+	}
+	if alias, ok := cronAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	c := &Cron{domStar: fields[2] == "*" || fields[2] == "?", dowStar: fields[4] == "*" || fields[4] == "?"}
+	var err error
+	if c.minute, err = parseField(fields[0], fieldMinute); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseField(fields[1], fieldHour); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseField(fields[2], fieldDom); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseField(fields[3], fieldMonth); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseField(fields[4], fieldDow); err != nil {
+		return nil, err
+	}
+	// 7 — тоже воскресенье
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func parseField(expr string, f cronField) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" && rangePart != "?" {
+			a, b, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(b); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max // "5/15" — с 5 до конца с шагом 15
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("bad cron range %q", part)
+		}
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad cron step %q", part)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("bad cron value %q (expected %d-%d)", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func has(mask uint64, v int) bool { return mask&(1<<v) != 0 }
+
+// dayMatches: если ограничены оба поля дня — достаточно любого (как в классическом cron)
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next — первый момент срабатывания строго после t (в часовом поясе t).
+// Нулевое время — выражение не сработает никогда (например, 30 февраля).
+// Переход на летнее время: несуществующее местное время пропускается,
+// повторяющийся час срабатывает один раз (по первому проходу).
+func (c *Cron) Next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Truncate(time.Second).Add(c.every)
+	}
+
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(c.month, int(t.Month())) {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !has(c.hour, t.Hour()) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if !has(c.minute, t.Minute()) {
+			// Прыгаем сразу к следующей допустимой минуте часа
+			rest := c.minute >> (t.Minute() + 1)
+			if rest == 0 {
+				t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(rest)+1) * time.Minute)
+			}
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// forward — следующий кандидат next, если он действительно позже t. Для местного времени
+// в "дыре" перехода на летнее время time.Date возвращает момент до перехода, и поиск бы
+// зациклился: тогда идем к началу следующего часа по абсолютному времени.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	scl, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	// В 2026 году Нью-Йорк переходит на летнее время 8 марта (02:00 -> 03:00)
+	// и обратно 1 ноября (01:00-02:00 проходит дважды), Сантьяго — 6 сентября в полночь (00:00 -> 01:00)
+	at := func(loc *time.Location, s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	utc := func(s string) time.Time { return at(time.UTC, s) }
+	edt := time.FixedZone("EDT", -4*3600)
+	est := time.FixedZone("EST", -5*3600)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time // Нулевое — не сработает никогда
+	}{
+		{"every minute", "* * * * *", utc("2026-01-01 10:00"), utc("2026-01-01 10:01")},
+		{"strictly after", "0 10 * * *", utc("2026-01-01 10:00"), utc("2026-01-02 10:00")},
+		{"seconds are dropped", "* * * * *", utc("2026-01-01 10:00").Add(59 * time.Second), utc("2026-01-01 10:01")},
+		{"step jumps within hour", "*/20 * * * *", utc("2026-01-01 10:41"), utc("2026-01-01 11:00")},
+		{"range with step", "0 8-18/4 * * *", utc("2026-01-01 12:01"), utc("2026-01-01 16:00")},
+		{"names", "0 0 * FEB MON", utc("2026-01-01 00:00"), utc("2026-02-02 00:00")},
+		{"sunday as 7", "0 0 * * 7", utc("2026-01-01 00:00"), utc("2026-01-04 00:00")},
+		{"month rollover", "0 0 1 * *", utc("2026-12-15 00:00"), utc("2027-01-01 00:00")},
+		{"leap day", "0 0 29 2 *", utc("2026-01-01 00:00"), utc("2028-02-29 00:00")},
+		{"never", "0 0 30 2 *", utc("2026-01-01 00:00"), time.Time{}},
+
+		// dom и dow ограничены оба — достаточно любого (1-е число ИЛИ пятница)
+		{"dom or dow: dow first", "0 0 1 * FRI", utc("2026-01-01 00:00"), utc("2026-01-02 00:00")},
+		{"dom or dow: dom first", "0 0 1 * FRI", utc("2026-01-31 00:00"), utc("2026-02-01 00:00")},
+		// Одно из полей "*" — работает только другое
+		{"dom only", "0 0 13 * *", utc("2026-01-01 00:00"), utc("2026-01-13 00:00")},
+		{"dow only", "0 0 * * FRI", utc("2026-01-03 00:00"), utc("2026-01-09 00:00")},
+		{"dom with ? dow", "0 0 13 * ?", utc("2026-01-01 00:00"), utc("2026-01-13 00:00")},
+		// Пятница 13-е: нужен AND, а cron дает OR — срабатывает в каждую пятницу и 13-е
+		{"friday 13 is or", "0 0 13 * 5", utc("2026-02-01 00:00"), utc("2026-02-06 00:00")},
+
+		// Весной 02:30 не существует: в этот день пропускается
+		{"dst gap skipped", "30 2 * * *", at(ny, "2026-03-08 00:00"), at(ny, "2026-03-09 02:30")},
+		{"dst gap hourly", "0 * * * *", at(ny, "2026-03-08 01:30"), at(ny, "2026-03-08 03:00")},
+		{"dst gap daily after", "0 3 * * *", at(ny, "2026-03-08 00:00"), at(ny, "2026-03-08 03:00")},
+		{"dst gap at midnight", "0 0 * * *", at(scl, "2026-09-05 00:00"), at(scl, "2026-09-07 00:00")},
+		{"dst gap at midnight hourly", "0 * * * *", at(scl, "2026-09-05 23:00"), at(scl, "2026-09-06 01:00")},
+		// Осенью 01:30 бывает дважды: срабатывает один раз, в первый
+		{"dst repeat first", "30 1 * * *", at(ny, "2026-11-01 00:00"), time.Date(2026, 11, 1, 1, 30, 0, 0, edt)},
+		{"dst repeat once", "30 1 * * *", at(ny, "2026-11-01 01:30"), at(ny, "2026-11-02 01:30")},
+		{"dst repeat hourly", "0 * * * *", at(ny, "2026-11-01 01:00"), time.Date(2026, 11, 1, 2, 0, 0, 0, est)},
+
+		{"every", "@every 90s", utc("2026-01-01 10:00").Add(1500 * time.Millisecond), utc("2026-01-01 10:01").Add(31 * time.Second)},
+		{"alias", "@weekly", utc("2026-01-01 00:00"), utc("2026-01-04 00:00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Fatalf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Fatalf("location %s, want %s", got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * XYZ *",
+		"@every 500ms",
+		"@every soon",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) accepted", expr)
+		}
+	}
+}
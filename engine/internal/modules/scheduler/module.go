@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"nexus-engine/internal/core"
+	"nexus-engine/internal/pkg/logger"
+)
+
+// Убеждаемся, что Module реализует интерфейс core.Module
+var _ core.Module = (*Module)(nil)
+
+type Module struct {
+	scheduler *Scheduler
+	bus       *core.Bus
+	client    *http.Client
+	timeout   time.Duration // Таймаут webhook по умолчанию
+	log       *logger.Logger
+
+	// Флаги CLI
+	fDataDir         *string
+	fHistory         *int
+	fTimeout         *int
+	fCompactInterval *int
+}
+
+func NewModule() *Module {
+	return &Module{}
+}
+
+func (m *Module) Name() string {
+	return "Scheduler"
+}
+
+// SetBus — расписания с channel публикуют в PubSub через шину
+func (m *Module) SetBus(bus *core.Bus) {
+	m.bus = bus
+}
+
+func (m *Module) RegisterFlags(fs *flag.FlagSet) {
+	m.fDataDir = fs.String("scheduler-data-dir", "./data", "Directory for scheduler persistence")
+
+	m.fHistory = fs.Int("scheduler-history", 20, "Runs to keep in history per schedule")
+	m.fTimeout = fs.Int("scheduler-timeout", 30, "Default webhook timeout in seconds")
+	m.fCompactInterval = fs.Int("scheduler-compact-interval", 300, "Interval in seconds to compact the scheduler journal")
+}
+
+func (m *Module) Init(log *logger.Logger) error {
+	m.log = log
+	m.client = &http.Client{}
+	m.timeout = time.Duration(*m.fTimeout) * time.Second
+
+	var err error
+	m.scheduler, err = NewScheduler(Options{
+		PersistPath:     *m.fDataDir + "/scheduler.journal",
+		HistorySize:     max(1, *m.fHistory),
+		CompactInterval: time.Duration(max(1, *m.fCompactInterval)) * time.Second,
+		Logger:          log,
+	}, m.fire)
+	return err
+}
+
+func (m *Module) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/scheduler/schedules", m.handleSchedules)
+	mux.HandleFunc("/scheduler/delete", m.handleDelete)
+	mux.HandleFunc("/scheduler/trigger", m.handleTrigger)
+	mux.HandleFunc("/scheduler/runs", m.handleRuns)
+}
+
+func (m *Module) Shutdown() {
+	if m.scheduler != nil {
+		m.log.Info("Stopping Scheduler...")
+		m.scheduler.Close()
+	}
+}
@@ -0,0 +1,588 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-engine/internal/modules/pubsub"
+	"nexus-engine/internal/pkg/journal"
+	"nexus-engine/internal/pkg/logger"
+)
+
+var (
+	ErrNotFound   = errors.New("schedule not found")
+	ErrBadRequest = errors.New("bad schedule")
+)
+
+// Политики пропущенных запусков (сервер лежал, когда schedule должен был сработать)
+const (
+	MissedSkip = "skip" // Ничего не делаем, ждем следующего по расписанию
+	MissedOnce = "once" // Один запуск за все пропущенные
+	MissedAll  = "all"  // Догоняем каждый пропущенный (не больше maxCatchUp)
+)
+
+const maxCatchUp = 100
+
+// Статусы запусков
+const (
+	RunRunning     = "running"
+	RunOK          = "ok"
+	RunFailed      = "failed"
+	RunSkipped     = "skipped"     // Предыдущий запуск еще не закончился
+	RunMissed      = "missed"      // Пропущен из-за простоя (политика skip)
+	RunInterrupted = "interrupted" // Сервер упал во время запуска: повторять не будем (at-most-once)
+)
+
+// Schedule — зарегистрированное расписание. Имя уникально: регистрация с тем же именем
+// с каждого инстанса приложения — идемпотентна, срабатывание будет одно.
+type Schedule struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron,omitempty"`     // Cron выражение
+	At       int64  `json:"at,omitempty"`       // Или разовый запуск (unix ms)
+	Timezone string `json:"timezone,omitempty"` // IANA, по умолчанию UTC
+
+	// Цель: webhook или канал PubSub
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"` // По умолчанию POST
+	Headers map[string]string `json:"headers,omitempty"`
+	Channel string            `json:"channel,omitempty"`
+	Payload any               `json:"payload,omitempty"`
+
+	Missed    string `json:"missed,omitempty"`     // skip (по умолчанию), once, all
+	TimeoutMs int64  `json:"timeout_ms,omitempty"` // Таймаут webhook
+	Paused    bool   `json:"paused,omitempty"`
+
+	NextRun   int64 `json:"next_run"` // 0 — больше не сработает
+	LastRun   int64 `json:"last_run,omitempty"`
+	CreatedAt int64 `json:"created_at"`
+
+	cron *Cron
+	loc  *time.Location
+}
+
+// Run — один запуск расписания
+type Run struct {
+	ID          string `json:"id"`
+	Schedule    string `json:"schedule"`
+	ScheduledAt int64  `json:"scheduled_at"` // Когда должен был сработать
+	StartedAt   int64  `json:"started_at"`
+	FinishedAt  int64  `json:"finished_at,omitempty"`
+	Status      string `json:"status"`
+	HTTPStatus  int    `json:"http_status,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// record — запись журнала: состояние расписания, удаление или запуск
+type record struct {
+	Schedule *Schedule `json:"schedule,omitempty"`
+	Del      string    `json:"del,omitempty"`
+	Run      *Run      `json:"run,omitempty"`
+}
+
+// Options — настройки, передаваемые извне (из флагов CLI)
+type Options struct {
+	PersistPath     string
+	HistorySize     int
+	CompactInterval time.Duration
+	Logger          *logger.Logger
+}
+
+// Scheduler — расписания, их история и цикл запуска
+type Scheduler struct {
+	mu        sync.Mutex
+	schedules map[string]*Schedule
+	history   map[string][]Run // Последние запуски, старые первыми
+	running   map[string]bool  // Расписания, у которых идет запуск
+
+	// Запись в журнал держит RLock, компакция — Lock
+	persistMu sync.RWMutex
+	journal   *journal.Journal
+	runSeq    atomic.Uint64
+
+	fire func(s Schedule, run Run) Run // Выполняет запуск (webhook/PubSub)
+	opts Options
+	log  *logger.Logger
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewScheduler(opts Options, fire func(s Schedule, run Run) Run) (*Scheduler, error) {
+	sc := &Scheduler{
+		schedules: make(map[string]*Schedule),
+		history:   make(map[string][]Run),
+		running:   make(map[string]bool),
+		fire:      fire,
+		opts:      opts,
+		log:       opts.Logger,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.PersistPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := sc.load(); err != nil {
+		return nil, err
+	}
+
+	j, err := journal.Open(opts.PersistPath)
+	if err != nil {
+		return nil, err
+	}
+	sc.journal = j
+	sc.log.Info("⏰ Scheduler persistence enabled: %s (%d schedules)", opts.PersistPath, len(sc.schedules))
+
+	if err := sc.Compact(); err != nil {
+		sc.log.Error("Scheduler compaction failed: %v", err)
+	}
+	sc.catchUp(time.Now())
+
+	go sc.loop()
+	return sc, nil
+}
+
+func (sc *Scheduler) load() error {
+	err := journal.Replay(sc.opts.PersistPath, func(raw json.RawMessage) error {
+		var r record
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil // Пропускаем мусор
+		}
+		switch {
+		case r.Schedule != nil:
+			if err := r.Schedule.compile(); err != nil {
+				sc.log.Error("Scheduler: dropping bad schedule %q: %v", r.Schedule.Name, err)
+				return nil
+			}
+			sc.schedules[r.Schedule.Name] = r.Schedule
+		case r.Del != "":
+			delete(sc.schedules, r.Del)
+			delete(sc.history, r.Del)
+		case r.Run != nil:
+			sc.addRun(*r.Run)
+			if seq, err := strconv.ParseUint(r.Run.ID, 10, 64); err == nil && seq > sc.runSeq.Load() {
+				sc.runSeq.Store(seq)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Запуски, которые шли в момент падения, не повторяем: гарантия at-most-once
+	for name, runs := range sc.history {
+		for i := range runs {
+			if runs[i].Status == RunRunning {
+				runs[i].Status, runs[i].Error = RunInterrupted, "server stopped during the run"
+			}
+		}
+		sc.history[name] = runs
+	}
+	return nil
+}
+
+// compile проверяет расписание и готовит cron/таймзону
+func (s *Schedule) compile() error {
+	if s.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrBadRequest)
+	}
+	if (s.Cron == "") == (s.At == 0) {
+		return fmt.Errorf("%w: exactly one of cron or at is required", ErrBadRequest)
+	}
+	if (s.URL == "") == (s.Channel == "") {
+		return fmt.Errorf("%w: exactly one of url or channel is required", ErrBadRequest)
+	}
+	if s.Channel != "" {
+		if err := pubsub.ValidateChannel(s.Channel, false); err != nil {
+			return fmt.Errorf("%w: channel: %v", ErrBadRequest, err)
+		}
+	}
+	switch s.Missed {
+	case "":
+		s.Missed = MissedSkip
+	case MissedSkip, MissedOnce, MissedAll:
+	default:
+		return fmt.Errorf("%w: missed must be skip, once or all", ErrBadRequest)
+	}
+
+	var err error
+	if s.loc, err = time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	if s.Cron != "" {
+		if s.cron, err = ParseCron(s.Cron); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadRequest, err)
+		}
+	}
+	return nil
+}
+
+// next — следующий запуск после t (0 — больше не будет)
+func (s *Schedule) next(t time.Time) int64 {
+	if s.cron == nil {
+		return 0 // Разовый запуск уже был
+	}
+	n := s.cron.Next(t.In(s.loc))
+	if n.IsZero() {
+		return 0
+	}
+	return n.UnixMilli()
+}
+
+// sameDefinition — изменилось ли что-то, кроме состояния
+func (s *Schedule) sameDefinition(o *Schedule) bool {
+	a, _ := json.Marshal(s.definition())
+	b, _ := json.Marshal(o.definition())
+	return string(a) == string(b)
+}
+
+func (s *Schedule) definition() Schedule {
+	d := *s
+	d.NextRun, d.LastRun, d.CreatedAt, d.Paused = 0, 0, 0, false
+	return d
+}
+
+// Upsert регистрирует расписание. Повторная регистрация того же определения ничего не меняет
+// (кроме paused), поэтому ее можно делать на старте каждого инстанса.
+func (sc *Scheduler) Upsert(s Schedule) (Schedule, error) {
+	if err := s.compile(); err != nil {
+		return Schedule{}, err
+	}
+	now := time.Now()
+
+	sc.persistMu.RLock()
+	defer sc.persistMu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if cur, ok := sc.schedules[s.Name]; ok && cur.sameDefinition(&s) {
+		if cur.Paused != s.Paused {
+			cur.Paused = s.Paused
+			sc.persist(cur)
+		}
+		return *cur, nil
+	}
+
+	s.CreatedAt = now.UnixMilli()
+	if cur, ok := sc.schedules[s.Name]; ok {
+		s.CreatedAt, s.LastRun = cur.CreatedAt, cur.LastRun
+	}
+	if s.At > 0 {
+		s.NextRun = s.At
+	} else {
+		s.NextRun = s.next(now)
+	}
+
+	sc.schedules[s.Name] = &s
+	sc.persist(&s)
+	sc.signal()
+
+	sc.log.Info("⏰ Schedule %q registered, next run: %s", s.Name, formatMs(s.NextRun))
+	return s, nil
+}
+
+func (sc *Scheduler) Delete(name string) error {
+	sc.persistMu.RLock()
+	defer sc.persistMu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, ok := sc.schedules[name]; !ok {
+		return ErrNotFound
+	}
+	delete(sc.schedules, name)
+	delete(sc.history, name)
+	sc.append(record{Del: name})
+	return nil
+}
+
+func (sc *Scheduler) List() []Schedule {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	out := make([]Schedule, 0, len(sc.schedules))
+	for _, s := range sc.schedules {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (sc *Scheduler) Get(name string) (Schedule, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	s, ok := sc.schedules[name]
+	if !ok {
+		return Schedule{}, ErrNotFound
+	}
+	return *s, nil
+}
+
+// Runs — последние запуски, новые первыми
+func (sc *Scheduler) Runs(name string, limit int) ([]Run, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, ok := sc.schedules[name]; !ok {
+		return nil, ErrNotFound
+	}
+	runs := sc.history[name]
+	out := make([]Run, 0, len(runs))
+	for i := len(runs) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		out = append(out, runs[i])
+	}
+	return out, nil
+}
+
+// Trigger запускает расписание вне очереди (не сдвигая следующий запуск)
+func (sc *Scheduler) Trigger(name string) (Run, error) {
+	sc.persistMu.RLock()
+	defer sc.persistMu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	s, ok := sc.schedules[name]
+	if !ok {
+		return Run{}, ErrNotFound
+	}
+	return sc.start(s, time.Now().UnixMilli()), nil
+}
+
+// catchUp применяет политику пропущенных запусков после простоя
+func (sc *Scheduler) catchUp(now time.Time) {
+	sc.persistMu.RLock()
+	defer sc.persistMu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	nowMs := now.UnixMilli()
+	for _, s := range sc.schedules {
+		if s.NextRun == 0 || s.NextRun > nowMs || s.Paused {
+			continue
+		}
+
+		// Все пропущенные моменты (для cron их может быть много)
+		missed := []int64{s.NextRun}
+		for t := s.next(time.UnixMilli(s.NextRun)); t != 0 && t <= nowMs && len(missed) < maxCatchUp; t = s.next(time.UnixMilli(t)) {
+			missed = append(missed, t)
+		}
+
+		switch s.Missed {
+		case MissedAll:
+			sc.start(s, missed...)
+		case MissedOnce:
+			sc.start(s, missed[len(missed)-1])
+		default:
+			sc.record(Run{ID: sc.newRunID(), Schedule: s.Name, ScheduledAt: missed[0], StartedAt: nowMs, FinishedAt: nowMs,
+				Status: RunMissed, Error: fmt.Sprintf("%d run(s) missed while the server was down", len(missed))})
+		}
+		sc.log.Info("⏰ Schedule %q missed %d run(s), policy: %s", s.Name, len(missed), s.Missed)
+
+		s.NextRun = s.next(now)
+		sc.persist(s)
+	}
+}
+
+func (sc *Scheduler) loop() {
+	compact := time.NewTicker(sc.opts.CompactInterval)
+	defer compact.Stop()
+
+	for {
+		sc.mu.Lock()
+		var next int64
+		for _, s := range sc.schedules {
+			if s.NextRun > 0 && !s.Paused && (next == 0 || s.NextRun < next) {
+				next = s.NextRun
+			}
+		}
+		sc.mu.Unlock()
+
+		wait := time.Hour
+		if next > 0 {
+			wait = max(time.Until(time.UnixMilli(next)), 0)
+		}
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+			sc.tick(time.Now())
+		case <-sc.wake:
+			timer.Stop()
+		case <-compact.C:
+			timer.Stop()
+			if err := sc.Compact(); err != nil {
+				sc.log.Error("Scheduler compaction failed: %v", err)
+			}
+		case <-sc.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// tick запускает созревшие расписания. Следующий запуск и сам факт запуска
+// пишутся в журнал ДО вызова webhook: после падения запуск не повторится (at-most-once).
+func (sc *Scheduler) tick(now time.Time) {
+	sc.persistMu.RLock()
+	defer sc.persistMu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	nowMs := now.UnixMilli()
+	for _, s := range sc.schedules {
+		if s.NextRun == 0 || s.NextRun > nowMs || s.Paused {
+			continue
+		}
+		at := s.NextRun
+		s.NextRun = s.next(now)
+		sc.persist(s)
+		sc.start(s, at)
+	}
+}
+
+// start фиксирует запуски в журнале и выполняет их в фоне по очереди (под sc.mu).
+// Несколько моментов бывает только при догонянии пропущенных по политике all.
+func (sc *Scheduler) start(s *Schedule, scheduledAt ...int64) Run {
+	now := time.Now().UnixMilli()
+	runs := make([]Run, len(scheduledAt))
+	for i, at := range scheduledAt {
+		runs[i] = Run{ID: sc.newRunID(), Schedule: s.Name, ScheduledAt: at, StartedAt: now}
+	}
+
+	// Перекрывающиеся запуски одного расписания не допускаем
+	if sc.running[s.Name] {
+		for i := range runs {
+			runs[i].FinishedAt, runs[i].Status, runs[i].Error = now, RunSkipped, "previous run is still in progress"
+			sc.record(runs[i])
+		}
+		return runs[0]
+	}
+
+	for i := range runs {
+		runs[i].Status = RunRunning
+		sc.record(runs[i])
+	}
+	s.LastRun = now
+	sc.running[s.Name] = true
+
+	snapshot := *s
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+		for _, run := range runs {
+			run.StartedAt = time.Now().UnixMilli()
+			done := sc.fire(snapshot, run)
+			done.FinishedAt = time.Now().UnixMilli()
+			sc.finish(done, false)
+		}
+		sc.finish(Run{Schedule: snapshot.Name}, true)
+	}()
+	return runs[0]
+}
+
+// finish записывает результат запуска; last — снимает отметку "идет запуск"
+func (sc *Scheduler) finish(run Run, last bool) {
+	sc.persistMu.RLock()
+	defer sc.persistMu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if last {
+		delete(sc.running, run.Schedule)
+		return
+	}
+	if _, ok := sc.schedules[run.Schedule]; ok {
+		sc.record(run)
+	}
+}
+
+func (sc *Scheduler) newRunID() string {
+	return strconv.FormatUint(sc.runSeq.Add(1), 10)
+}
+
+// record сохраняет запуск в истории (новый или обновленный по ID)
+func (sc *Scheduler) record(run Run) {
+	sc.addRun(run)
+	sc.append(record{Run: &run})
+}
+
+func (sc *Scheduler) addRun(run Run) {
+	runs := sc.history[run.Schedule]
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].ID == run.ID {
+			runs[i] = run
+			return
+		}
+	}
+	runs = append(runs, run)
+	if len(runs) > sc.opts.HistorySize {
+		runs = runs[len(runs)-sc.opts.HistorySize:]
+	}
+	sc.history[run.Schedule] = runs
+}
+
+func (sc *Scheduler) persist(s *Schedule) {
+	cp := *s
+	sc.append(record{Schedule: &cp})
+}
+
+func (sc *Scheduler) append(r record) {
+	if sc.journal == nil {
+		return
+	}
+	if err := sc.journal.Append(r); err != nil {
+		sc.log.Error("Scheduler journal error: %v", err)
+	}
+}
+
+// signal будит цикл, чтобы он пересчитал ближайший запуск
+func (sc *Scheduler) signal() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Compact переписывает журнал текущими расписаниями и их историей
+func (sc *Scheduler) Compact() error {
+	sc.persistMu.Lock()
+	defer sc.persistMu.Unlock()
+	sc.mu.Lock()
+	var records []any
+	for _, s := range sc.schedules {
+		cp := *s
+		records = append(records, record{Schedule: &cp})
+		for _, run := range sc.history[s.Name] {
+			r := run
+			records = append(records, record{Run: &r})
+		}
+	}
+	sc.mu.Unlock()
+	return sc.journal.Rewrite(records)
+}
+
+func (sc *Scheduler) Close() error {
+	close(sc.stop)
+	sc.wg.Wait() // Даем идущим запускам записать результат
+	if err := sc.Compact(); err != nil {
+		sc.log.Error("Scheduler compaction failed: %v", err)
+	}
+	return sc.journal.Close()
+}
+
+func formatMs(ms int64) string {
+	if ms == 0 {
+		return "never"
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
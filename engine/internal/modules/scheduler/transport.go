@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-engine/internal/core"
+)
+
+// webhookBody — тело запроса к webhook
+type webhookBody struct {
+	Schedule    string `json:"schedule"`
+	RunID       string `json:"run_id"`
+	ScheduledAt int64  `json:"scheduled_at"`
+	Payload     any    `json:"payload,omitempty"`
+}
+
+// fire выполняет запуск: webhook или публикация в канал
+func (m *Module) fire(s Schedule, run Run) Run {
+	body := webhookBody{Schedule: s.Name, RunID: run.ID, ScheduledAt: run.ScheduledAt, Payload: s.Payload}
+
+	if s.Channel != "" {
+		err := core.ErrNoPublisher
+		if m.bus != nil {
+			err = m.bus.PublishChannel(s.Channel, body)
+		}
+		if err != nil {
+			run.Status, run.Error = RunFailed, err.Error()
+			m.log.Error("Schedule %q publish to '%s' failed: %v", s.Name, s.Channel, err)
+			return run
+		}
+		run.Status = RunOK
+		m.log.Debug("⏰ Schedule %q run %s published", s.Name, run.ID)
+		return run
+	}
+
+	timeout := m.timeout
+	if s.TimeoutMs > 0 {
+		timeout = time.Duration(s.TimeoutMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	data, _ := json.Marshal(body)
+	method := s.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.URL, bytes.NewReader(data))
+	if err != nil {
+		run.Status, run.Error = RunFailed, err.Error()
+		return run
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("X-Nexus-Schedule", s.Name)
+	req.Header.Set("X-Nexus-Run-Id", run.ID)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		run.Status, run.Error = RunFailed, err.Error()
+		m.log.Error("Schedule %q webhook failed: %v", s.Name, err)
+		return run
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	run.HTTPStatus = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		run.Status, run.Error = RunFailed, "webhook returned "+resp.Status
+		m.log.Error("Schedule %q webhook returned %s", s.Name, resp.Status)
+		return run
+	}
+	run.Status = RunOK
+	m.log.Debug("⏰ Schedule %q run %s done", s.Name, run.ID)
+	return run
+}
+
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrBadRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal Error", http.StatusInternalServerError)
+	}
+}
+
+// GET /scheduler/schedules — список, ?name= — одно расписание; POST — регистрация
+func (m *Module) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if name := r.URL.Query().Get("name"); name != "" {
+			s, err := m.scheduler.Get(name)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			writeJSON(w, s)
+			return
+		}
+		writeJSON(w, m.scheduler.List())
+
+	case http.MethodPost:
+		var req Schedule
+		if !decode(w, r, &req) {
+			return
+		}
+		s, err := m.scheduler.Upsert(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, s)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *Module) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req nameRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := m.scheduler.Delete(req.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"success\":true}")
+}
+
+// POST /scheduler/trigger — запустить вне расписания
+func (m *Module) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	var req nameRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	run, err := m.scheduler.Trigger(req.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, run)
+}
+
+// GET /scheduler/runs?name=report&limit=10 — история запусков, новые первыми
+func (m *Module) handleRuns(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	runs, err := m.scheduler.Runs(r.URL.Query().Get("name"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, runs)
+}
@@ -0,0 +1,521 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-engine/internal/pkg/logger"
+)
+
+// Кластер узлов Engine: gossip-членство и пересылка сообщений каналов между узлами.
+// Раз в GossipInterval узел обменивается списком известных узлов с несколькими
+// случайными соседями (push-pull по HTTP). В запись узла входит его интерес — каналы
+// и шаблоны, на которые у него есть подписчики, поэтому сообщение уходит только туда,
+// где его ждут. Пересылка — at-most-once, как и сам PubSub.
+// Узлы, которые не присылают heartbeat (или ушли) дольше pruneAfter×DeadAfter, забываются.
+
+const (
+	gossipFanout = 3   // Скольким соседям рассказывать за раунд
+	maxBatch     = 100 // Сообщений в одном запросе пересылки
+	pruneAfter   = 10  // Через сколько DeadAfter мертвый или ушедший узел забывается
+
+	secretHeader = "X-Nexus-Cluster-Secret"
+)
+
+// Member — узел кластера в том виде, в каком его передают по gossip
+type Member struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"` // Базовый URL: http://10.0.0.5:4000
+	// Heartbeat растет каждый раунд. Начинается с Unix ms старта, поэтому
+	// после рестарта с тем же id узел сразу "новее" своей старой записи.
+	Heartbeat uint64   `json:"heartbeat"`
+	Interests []string `json:"interests"`
+	Left      bool     `json:"left,omitempty"` // Узел остановился штатно
+}
+
+// Status — узел глазами этого узла (GET /cluster/members)
+type Status struct {
+	Member
+	Self    bool   `json:"self,omitempty"`
+	Live    bool   `json:"live"`
+	Sent    uint64 `json:"sent"`    // Переслано сообщений
+	Dropped uint64 `json:"dropped"` // Не переслано: очередь полна или узел не ответил
+}
+
+type Config struct {
+	ID     string   // Уникальный id узла (по умолчанию Addr)
+	Addr   string   // Как до этого узла достучаться остальным
+	Seeds  []string // Адреса узлов, с которых начать знакомство
+	Secret string   // Общий секрет узлов (пусто — без проверки)
+
+	GossipInterval time.Duration // По умолчанию 1s
+	DeadAfter      time.Duration // Узел без новых heartbeat дольше не получает сообщений (по умолчанию 5 интервалов)
+	QueueSize      int           // Очередь пересылки на узел (по умолчанию 1024)
+
+	// Интерес: шаблоны и каналы. Pattern отличает шаблон, Covers проверяет, ловит ли он канал.
+	Pattern func(interest string) bool
+	Covers  func(pattern, channel string) bool
+
+	// Interests — текущий интерес этого узла (перечитывается после InterestsChanged)
+	Interests func() []string
+	// Deliver — сообщение с другого узла: разослать местным подписчикам
+	Deliver func(channel string, data json.RawMessage) error
+
+	Log *logger.Logger
+}
+
+// member — известный узел с индексом интереса и очередью пересылки
+type member struct {
+	Member
+	seen     time.Time // Когда Heartbeat последний раз вырос
+	up       bool      // Был живым при прошлой проверке (для логов)
+	exact    map[string]bool
+	patterns []string
+	peer     *peer
+}
+
+// envelope — сообщение канала между узлами
+type envelope struct {
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type peer struct {
+	queue   chan envelope
+	stop    chan struct{} // Узел забыт: пересылка останавливается
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// Node — этот узел кластера
+type Node struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.RWMutex
+	self    Member
+	members map[string]*member // Без себя
+
+	dirty atomic.Bool   // Интерес изменился, перечитать перед следующим раундом
+	wake  chan struct{} // Рассказать об интересе сразу, не дожидаясь раунда
+	stop  chan struct{}
+}
+
+func New(cfg Config) *Node {
+	if cfg.ID == "" {
+		cfg.ID = cfg.Addr
+	}
+	if cfg.GossipInterval <= 0 {
+		cfg.GossipInterval = time.Second
+	}
+	if cfg.DeadAfter <= 0 {
+		cfg.DeadAfter = 5 * cfg.GossipInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1024
+	}
+	cfg.Addr = strings.TrimRight(cfg.Addr, "/")
+
+	n := &Node{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: max(cfg.GossipInterval, 2*time.Second)},
+		self:    Member{ID: cfg.ID, Addr: cfg.Addr, Heartbeat: uint64(time.Now().UnixMilli()), Interests: []string{}},
+		members: make(map[string]*member),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	n.dirty.Store(true)
+	return n
+}
+
+// Start запускает gossip
+func (n *Node) Start() {
+	n.cfg.Log.Info("🌐 Cluster node %s (%s), seeds: %v", n.cfg.ID, n.cfg.Addr, n.cfg.Seeds)
+	go n.run()
+}
+
+// Stop сообщает остальным, что узел уходит, и останавливает пересылку
+func (n *Node) Stop() {
+	n.mu.Lock()
+	n.self.Left = true
+	n.self.Heartbeat++
+	state, targets := n.snapshot(), n.targets(true)
+	n.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.exchange(addr, state)
+		}()
+	}
+	wg.Wait()
+	close(n.stop)
+}
+
+// InterestsChanged — у узла появились или пропали подписчики каналов
+func (n *Node) InterestsChanged() {
+	n.dirty.Store(true)
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) run() {
+	ticker := time.NewTicker(n.cfg.GossipInterval)
+	defer ticker.Stop()
+
+	n.gossip(false)
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.gossip(false)
+		case <-n.wake:
+			// Новый интерес рассказываем всем сразу: иначе первые сообщения канала
+			// на других узлах ушли бы мимо
+			n.gossip(true)
+		}
+	}
+}
+
+// gossip — раунд обмена: свое состояние уходит соседям, их — возвращается в ответе
+func (n *Node) gossip(all bool) {
+	var interests []string
+	if n.dirty.Swap(false) {
+		interests = n.cfg.Interests()
+		sort.Strings(interests)
+	}
+
+	n.mu.Lock()
+	if interests != nil {
+		n.self.Interests = interests
+	}
+	n.self.Heartbeat++
+	n.check(time.Now())
+	state, targets := n.snapshot(), n.targets(all)
+	n.mu.Unlock()
+
+	for _, addr := range targets {
+		go n.exchange(addr, state)
+	}
+}
+
+// exchange — push-pull с одним узлом
+func (n *Node) exchange(addr string, state []Member) {
+	var reply []Member
+	if err := n.post(addr+"/cluster/gossip", state, &reply); err != nil {
+		n.cfg.Log.Debug("Cluster gossip to %s failed: %v", addr, err)
+		return
+	}
+	n.merge(reply)
+}
+
+// snapshot — себя и живые узлы (под локом)
+func (n *Node) snapshot() []Member {
+	now := time.Now()
+	out := []Member{n.self}
+	for _, m := range n.members {
+		if m.live(now, n.cfg.DeadAfter) {
+			out = append(out, m.Member)
+		}
+	}
+	return out
+}
+
+// targets — кому рассказывать: случайные живые узлы (или все), а пока никого не знаем — seeds
+func (n *Node) targets(all bool) []string {
+	now := time.Now()
+	var addrs []string
+	for _, m := range n.members {
+		if m.live(now, n.cfg.DeadAfter) {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	if len(addrs) == 0 {
+		for _, seed := range n.cfg.Seeds {
+			if seed = strings.TrimRight(seed, "/"); seed != "" && seed != n.cfg.Addr {
+				addrs = append(addrs, seed)
+			}
+		}
+		return addrs
+	}
+	if !all && len(addrs) > gossipFanout {
+		rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+		addrs = addrs[:gossipFanout]
+	}
+	return addrs
+}
+
+// merge принимает чужое состояние: побеждает запись с большим heartbeat
+func (n *Node) merge(list []Member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for _, in := range list {
+		if in.ID == "" || in.Addr == "" {
+			continue
+		}
+		if in.ID == n.self.ID {
+			// Кто-то помнит нас "новее" (часы отстали после рестарта): обгоняем
+			if in.Heartbeat >= n.self.Heartbeat && !n.self.Left {
+				n.self.Heartbeat = in.Heartbeat + 1
+			}
+			continue
+		}
+
+		m, ok := n.members[in.ID]
+		if ok && in.Heartbeat <= m.Heartbeat {
+			continue
+		}
+		if !ok {
+			m = &member{peer: &peer{queue: make(chan envelope, n.cfg.QueueSize), stop: make(chan struct{})}}
+			n.members[in.ID] = m
+			go n.forward(m)
+		}
+		if in.Interests == nil {
+			in.Interests = []string{}
+		}
+		m.Member, m.seen = in, now
+		m.index(n.cfg.Pattern)
+
+		switch live := m.live(now, n.cfg.DeadAfter); {
+		case live && !m.up:
+			n.cfg.Log.Info("🌐 Cluster node joined: %s (%s)", m.ID, m.Addr)
+		case !live && m.up && m.Left:
+			n.cfg.Log.Info("🌐 Cluster node left: %s", m.ID)
+		}
+		m.up = m.live(now, n.cfg.DeadAfter)
+	}
+}
+
+// check отмечает узлы, которые перестали присылать heartbeat, и забывает давно мертвые
+// и ушедшие (под локом). Живые соседи их уже не рассказывают: snapshot — только живые.
+func (n *Node) check(now time.Time) {
+	for id, m := range n.members {
+		if m.up && !m.live(now, n.cfg.DeadAfter) {
+			m.up = false
+			n.cfg.Log.Info("🌐 Cluster node is down: %s (no heartbeat for %v)", m.ID, n.cfg.DeadAfter)
+		}
+		if now.Sub(m.seen) > pruneAfter*n.cfg.DeadAfter {
+			delete(n.members, id)
+			close(m.peer.stop)
+			n.cfg.Log.Debug("Cluster node forgotten: %s", m.ID)
+		}
+	}
+}
+
+func (m *member) live(now time.Time, deadAfter time.Duration) bool {
+	return !m.Left && now.Sub(m.seen) < deadAfter
+}
+
+// index раскладывает интерес на точные каналы и шаблоны
+func (m *member) index(pattern func(string) bool) {
+	m.exact = make(map[string]bool, len(m.Interests))
+	m.patterns = m.patterns[:0]
+	for _, interest := range m.Interests {
+		if pattern(interest) {
+			m.patterns = append(m.patterns, interest)
+		} else {
+			m.exact[interest] = true
+		}
+	}
+}
+
+func (m *member) wants(channel string, covers func(string, string) bool) bool {
+	if m.exact[channel] {
+		return true
+	}
+	for _, p := range m.patterns {
+		if covers(p, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Broadcast пересылает сообщение живым узлам, у которых есть подписчики канала.
+// Не блокирует: у каждого узла своя очередь, переполненная — сообщение для него теряется.
+func (n *Node) Broadcast(channel string, data any) {
+	now := time.Now()
+	var targets []*peer
+	n.mu.RLock()
+	for _, m := range n.members {
+		if m.live(now, n.cfg.DeadAfter) && m.wants(channel, n.cfg.Covers) {
+			targets = append(targets, m.peer)
+		}
+	}
+	n.mu.RUnlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		n.cfg.Log.Error("Cluster message for '%s' is not JSON: %v", channel, err)
+		return
+	}
+	env := envelope{Channel: channel, Data: raw}
+	for _, p := range targets {
+		select {
+		case p.queue <- env:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// forward отправляет очередь узла пачками, по порядку (пока узел не забыт)
+func (n *Node) forward(m *member) {
+	p := m.peer
+	for {
+		var env envelope
+		select {
+		case <-n.stop:
+			return
+		case <-p.stop:
+			return
+		case env = <-p.queue:
+		}
+
+		batch := []envelope{env}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case env = <-p.queue:
+				batch = append(batch, env)
+			default:
+				break drain
+			}
+		}
+
+		n.mu.RLock()
+		id, addr := m.ID, m.Addr
+		n.mu.RUnlock()
+		if err := n.post(addr+"/cluster/publish", batch, nil); err != nil {
+			p.dropped.Add(uint64(len(batch)))
+			n.cfg.Log.Debug("Cluster publish to %s failed: %v", id, err)
+			continue
+		}
+		p.sent.Add(uint64(len(batch)))
+	}
+}
+
+func (n *Node) post(url string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.cfg.Secret != "" {
+		req.Header.Set(secretHeader, n.cfg.Secret)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// Members — все известные узлы, включая этот
+func (n *Node) Members() []Status {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	now := time.Now()
+	out := []Status{{Member: n.self, Self: true, Live: !n.self.Left}}
+	for _, m := range n.members {
+		out = append(out, Status{
+			Member:  m.Member,
+			Live:    m.live(now, n.cfg.DeadAfter),
+			Sent:    m.peer.sent.Load(),
+			Dropped: m.peer.dropped.Load(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// --- NODE-TO-NODE API ---
+
+// Handler — роуты /cluster/* для других узлов
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cluster/gossip", n.auth(n.handleGossip))
+	mux.HandleFunc("/cluster/publish", n.auth(n.handlePublish))
+	mux.HandleFunc("/cluster/members", n.auth(n.handleMembers))
+	return mux
+}
+
+func (n *Node) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n.cfg.Secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(n.cfg.Secret)) != 1 {
+			http.Error(w, "Bad cluster secret", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// POST /cluster/gossip — состояние соседа; в ответ — наше
+func (n *Node) handleGossip(w http.ResponseWriter, r *http.Request) {
+	var list []Member
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+	n.merge(list)
+
+	n.mu.RLock()
+	state := n.snapshot()
+	n.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// POST /cluster/publish — пачка сообщений для местных подписчиков
+func (n *Node) handlePublish(w http.ResponseWriter, r *http.Request) {
+	var batch []envelope
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "Bad JSON", http.StatusBadRequest)
+		return
+	}
+	for _, env := range batch {
+		if err := n.cfg.Deliver(env.Channel, env.Data); err != nil {
+			n.cfg.Log.Error("Cluster message for '%s' dropped: %v", env.Channel, err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"ok":true}`))
+}
+
+// GET /cluster/members — кого знает узел
+func (n *Node) handleMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n.Members())
+}
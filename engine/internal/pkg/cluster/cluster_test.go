@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus-engine/internal/pkg/logger"
+)
+
+const (
+	testGossip = 20 * time.Millisecond
+	testDead   = 100 * time.Millisecond
+)
+
+// testNode — узел кластера на httptest.Server; delivered — что ему переслали
+type testNode struct {
+	*Node
+	srv       *httptest.Server
+	delivered chan envelope
+}
+
+// startNode поднимает узел с интересом interests (шаблоны — с '*' или '>')
+func startNode(t *testing.T, id string, seeds []string, interests ...string) *testNode {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	tn := &testNode{srv: srv, delivered: make(chan envelope, 100)}
+	tn.Node = New(Config{
+		ID:             id,
+		Addr:           srv.URL,
+		Seeds:          seeds,
+		GossipInterval: testGossip,
+		DeadAfter:      testDead,
+		Pattern:        func(s string) bool { return strings.ContainsAny(s, "*>") },
+		Covers:         covers,
+		Interests:      func() []string { return append([]string(nil), interests...) },
+		Deliver: func(channel string, data json.RawMessage) error {
+			tn.delivered <- envelope{Channel: channel, Data: data}
+			return nil
+		},
+		Log: logger.New(logger.LevelError),
+	})
+	mux.Handle("/cluster/", tn.Handler())
+	tn.Start()
+	t.Cleanup(srv.Close)
+	return tn
+}
+
+// covers — упрощенный Covers из pubsub: '*' — один сегмент, '>' — хвост
+func covers(pattern, channel string) bool {
+	ps, cs := strings.Split(pattern, "."), strings.Split(channel, ".")
+	for i, p := range ps {
+		if p == ">" {
+			return len(cs) > i
+		}
+		if i >= len(cs) || (p != "*" && p != cs[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(cs)
+}
+
+// waitFor ждет выполнения условия (не дольше 3 секунд)
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// live — сколько узлов (включая себя) n считает живыми
+func live(n *Node) int {
+	count := 0
+	for _, s := range n.Members() {
+		if s.Live {
+			count++
+		}
+	}
+	return count
+}
+
+// status — запись узла id глазами n
+func status(n *Node, id string) (Status, bool) {
+	for _, s := range n.Members() {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Status{}, false
+}
+
+func TestMembershipConverges(t *testing.T) {
+	a := startNode(t, "a", nil)
+	b := startNode(t, "b", []string{a.srv.URL})
+	c := startNode(t, "c", []string{a.srv.URL})
+	defer a.Stop()
+	defer b.Stop()
+	defer c.Stop()
+
+	for _, n := range []*testNode{a, b, c} {
+		waitFor(t, "node "+n.cfg.ID+" to see all nodes", func() bool { return live(n.Node) == 3 })
+	}
+}
+
+func TestForwardingByInterest(t *testing.T) {
+	a := startNode(t, "a", nil, "room.*", "feed")
+	b := startNode(t, "b", []string{a.srv.URL})
+	defer a.Stop()
+	defer b.Stop()
+
+	waitFor(t, "b to learn a's interests", func() bool {
+		s, ok := status(b.Node, "a")
+		return ok && s.Live && len(s.Interests) == 2
+	})
+
+	b.Broadcast("chat.1", map[string]int{"n": 1}) // Интереса нет: не пересылается
+	b.Broadcast("room.1.typing", map[string]int{"n": 2})
+	b.Broadcast("room.1", map[string]int{"n": 3})
+	b.Broadcast("feed", map[string]int{"n": 4})
+
+	for _, want := range []string{"room.1", "feed"} {
+		select {
+		case env := <-a.delivered:
+			if env.Channel != want {
+				t.Fatalf("delivered %q, want %q", env.Channel, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message for %q was not forwarded", want)
+		}
+	}
+	select {
+	case env := <-a.delivered:
+		t.Fatalf("unexpected message for %q", env.Channel)
+	case <-time.After(5 * testGossip):
+	}
+
+	// У b нет интереса: a никуда не пересылает
+	a.Broadcast("room.1", "x")
+	select {
+	case env := <-b.delivered:
+		t.Fatalf("b got %q without interest", env.Channel)
+	case <-time.After(5 * testGossip):
+	}
+	if s, _ := status(a.Node, "b"); s.Sent != 0 {
+		t.Fatalf("a sent %d messages to b without interest", s.Sent)
+	}
+}
+
+func TestLeaveAndDeadDetection(t *testing.T) {
+	a := startNode(t, "a", nil)
+	b := startNode(t, "b", []string{a.srv.URL})
+	c := startNode(t, "c", []string{a.srv.URL})
+	defer a.Stop()
+	waitFor(t, "membership", func() bool { return live(a.Node) == 3 })
+
+	// b уходит штатно: a узнает сразу, без ожидания DeadAfter
+	b.Stop()
+	waitFor(t, "a to see b left", func() bool {
+		s, ok := status(a.Node, "b")
+		return ok && s.Left && !s.Live
+	})
+
+	// c падает без прощания (и не отвечает): через DeadAfter он мертв, а позже забыт
+	close(c.stop)
+	c.srv.Close()
+	waitFor(t, "a to see c dead", func() bool {
+		s, ok := status(a.Node, "c")
+		return ok && !s.Live
+	})
+	waitFor(t, "a to forget b and c", func() bool {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		return len(a.members) == 0
+	})
+	if got := len(a.Members()); got != 1 {
+		t.Fatalf("a knows %d nodes, want only itself", got)
+	}
+}
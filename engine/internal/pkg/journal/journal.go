@@ -0,0 +1,112 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Journal — append-only файл JSON записей (одна запись на строку).
+// Тот же подход, что у WAL в KV: пишем событие, при старте проигрываем,
+// периодически переписываем файл компактным состоянием.
+type Journal struct {
+	file *os.File
+	path string
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+func Open(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{
+		file: file,
+		path: path,
+		enc:  json.NewEncoder(file),
+	}, nil
+}
+
+// Append дописывает запись в конец файла
+func (j *Journal) Append(record any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(record)
+}
+
+// Rewrite атомарно заменяет содержимое журнала записями records (compaction).
+// Пишем во временный файл и переименовываем, чтобы падение посередине не потеряло данные.
+func (j *Journal) Rewrite(records []any) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(tmp)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Закрываем старый файл до Rename (Windows не даст заменить открытый файл)
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		_ = j.reopen()
+		return err
+	}
+	return j.reopen()
+}
+
+func (j *Journal) reopen() error {
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	j.enc = json.NewEncoder(file)
+	return nil
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Replay читает журнал и отдает каждую запись в fn (используется при старте).
+// Битый хвост (запись оборвалась при падении) не считается ошибкой: всё до него валидно.
+func Replay(path string, fn func(raw json.RawMessage) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Файла нет — начинаем с чистого листа
+		}
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment — один шаг пути: либо ключ объекта, либо индекс массива
+type Segment struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// Path — разобранный путь вида $.user.tags[0] или $['odd key'].x
+type Path []Segment
+
+// Parse разбирает строку пути. Ведущий "$" необязателен, пустая строка или "$" — корень документа.
+func Parse(expr string) (Path, error) {
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimPrefix(expr, "$")
+
+	var path Path
+	for i := 0; i < len(expr); {
+		switch expr[i] {
+		case '.':
+			i++
+			start := i
+			for i < len(expr) && expr[i] != '.' && expr[i] != '[' {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("jsonpath: empty key at position %d", start)
+			}
+			path = append(path, Segment{Key: expr[start:i]})
+
+		case '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath: unclosed '[' at position %d", i)
+			}
+			inner := expr[i+1 : i+end]
+			i += end + 1
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, Segment{Key: inner[1 : len(inner)-1]})
+				continue
+			}
+			idx, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath: bad index %q", inner)
+			}
+			path = append(path, Segment{Index: idx, IsIndex: true})
+
+		default:
+			// Разрешаем запись без точки в начале: "user.name"
+			if len(path) == 0 && i == 0 {
+				expr = "." + expr
+				continue
+			}
+			return nil, fmt.Errorf("jsonpath: unexpected %q at position %d", expr[i], i)
+		}
+	}
+	return path, nil
+}
+
+// String возвращает путь в каноническом виде
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, seg := range p {
+		if seg.IsIndex {
+			fmt.Fprintf(&b, "[%d]", seg.Index)
+		} else {
+			b.WriteString(".")
+			b.WriteString(seg.Key)
+		}
+	}
+	return b.String()
+}
+
+// Get достает значение по пути из документа, разобранного encoding/json
+func Get(doc any, path Path) (any, bool) {
+	cur := doc
+	for _, seg := range path {
+		next, ok := child(cur, seg)
+		if !ok {
+			return nil, false
+		}
+		cur = next
+	}
+	return cur, true
+}
+
+func child(node any, seg Segment) (any, bool) {
+	if seg.IsIndex {
+		arr, ok := node.([]any)
+		if !ok {
+			return nil, false
+		}
+		// [-1] — последний элемент
+		idx, ok := resolveIndex(seg.Index, len(arr))
+		if !ok {
+			return nil, false
+		}
+		return arr[idx], true
+	}
+
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	v, ok := obj[seg.Key]
+	return v, ok
+}
+
+// Set возвращает новый документ, где по пути лежит value.
+// Исходный документ не меняется: копируются только контейнеры вдоль пути (copy-on-write),
+// поэтому старую версию можно безопасно отдавать читателям параллельно.
+// Недостающие ключи объектов создаются, выход за границы массива — ошибка.
+func Set(doc any, path Path, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	seg, rest := path[0], path[1:]
+	if seg.IsIndex {
+		arr, ok := doc.([]any)
+		if !ok {
+			return nil, fmt.Errorf("jsonpath: %s is not an array", describe(doc))
+		}
+		idx, ok := resolveIndex(seg.Index, len(arr))
+		if !ok {
+			return nil, fmt.Errorf("jsonpath: index %d out of range", seg.Index)
+		}
+		next, err := Set(arr[idx], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]any, len(arr))
+		copy(out, arr)
+		out[idx] = next
+		return out, nil
+	}
+
+	var obj map[string]any
+	switch v := doc.(type) {
+	case nil:
+		obj = nil
+	case map[string]any:
+		obj = v
+	default:
+		return nil, fmt.Errorf("jsonpath: %s is not an object", describe(doc))
+	}
+
+	next, err := Set(obj[seg.Key], rest, value)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(obj)+1)
+	for k, v := range obj {
+		out[k] = v
+	}
+	out[seg.Key] = next
+	return out, nil
+}
+
+// Delete возвращает новый документ без элемента по пути (copy-on-write, как Set).
+// deleted=false, если удалять было нечего.
+func Delete(doc any, path Path) (out any, deleted bool, err error) {
+	if len(path) == 0 {
+		return nil, doc != nil, nil
+	}
+
+	seg, rest := path[0], path[1:]
+	if seg.IsIndex {
+		arr, ok := doc.([]any)
+		if !ok {
+			return doc, false, nil
+		}
+		idx, ok := resolveIndex(seg.Index, len(arr))
+		if !ok {
+			return doc, false, nil
+		}
+		if len(rest) == 0 {
+			res := make([]any, 0, len(arr)-1)
+			res = append(res, arr[:idx]...)
+			return append(res, arr[idx+1:]...), true, nil
+		}
+		next, deleted, err := Delete(arr[idx], rest)
+		if err != nil || !deleted {
+			return doc, deleted, err
+		}
+		res := make([]any, len(arr))
+		copy(res, arr)
+		res[idx] = next
+		return res, true, nil
+	}
+
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return doc, false, nil
+	}
+	child, ok := obj[seg.Key]
+	if !ok {
+		return doc, false, nil
+	}
+
+	res := make(map[string]any, len(obj))
+	for k, v := range obj {
+		res[k] = v
+	}
+	if len(rest) == 0 {
+		delete(res, seg.Key)
+		return res, true, nil
+	}
+
+	next, deleted, err := Delete(child, rest)
+	if err != nil || !deleted {
+		return doc, deleted, err
+	}
+	res[seg.Key] = next
+	return res, true, nil
+}
+
+func resolveIndex(idx, n int) (int, bool) {
+	if idx < 0 {
+		idx += n
+	}
+	return idx, idx >= 0 && idx < n
+}
+
+func describe(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
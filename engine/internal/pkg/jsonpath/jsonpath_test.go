@@ -0,0 +1,189 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testDoc = `{"user":{"name":"ann","tags":["a","b",{"x":1}]},"meta":{"v":1},"list":[{"k":1},{"k":2}]}`
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func encode(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// same — один и тот же контейнер (а не равная копия)
+func same(a, b any) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() != vb.Kind() || (va.Kind() != reflect.Map && va.Kind() != reflect.Slice) {
+		return false
+	}
+	return va.Pointer() == vb.Pointer()
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      string // set или del
+		path    string
+		value   any
+		want    string   // Документ после операции
+		shared  []string // Поддеревья, которые не копируются
+		copied  []string // Контейнеры вдоль пути: новые
+		noop    bool     // del: удалять нечего, документ тот же
+		wantErr bool
+	}{
+		{
+			name: "set nested key", op: "set", path: "$.user.name", value: "bob",
+			want:   `{"list":[{"k":1},{"k":2}],"meta":{"v":1},"user":{"name":"bob","tags":["a","b",{"x":1}]}}`,
+			shared: []string{"$.meta", "$.list", "$.user.tags"},
+			copied: []string{"$", "$.user"},
+		},
+		{
+			name: "set inside array", op: "set", path: "$.list[1].k", value: 3.0,
+			want:   `{"list":[{"k":1},{"k":3}],"meta":{"v":1},"user":{"name":"ann","tags":["a","b",{"x":1}]}}`,
+			shared: []string{"$.meta", "$.user", "$.list[0]"},
+			copied: []string{"$", "$.list", "$.list[1]"},
+		},
+		{
+			name: "set creates missing keys", op: "set", path: "$.meta.deep.er", value: true,
+			want:   `{"list":[{"k":1},{"k":2}],"meta":{"deep":{"er":true},"v":1},"user":{"name":"ann","tags":["a","b",{"x":1}]}}`,
+			shared: []string{"$.user", "$.list"},
+			copied: []string{"$", "$.meta"},
+		},
+		{
+			name: "set last element", op: "set", path: "$.user.tags[-1].x", value: 2.0,
+			want:   `{"list":[{"k":1},{"k":2}],"meta":{"v":1},"user":{"name":"ann","tags":["a","b",{"x":2}]}}`,
+			shared: []string{"$.meta", "$.list"},
+			copied: []string{"$", "$.user", "$.user.tags", "$.user.tags[2]"},
+		},
+		{
+			name: "set root", op: "set", path: "$", value: map[string]any{"a": 1.0},
+			want: `{"a":1}`,
+		},
+		{name: "set index out of range", op: "set", path: "$.list[5].k", value: 1.0, wantErr: true},
+		{name: "set key in array", op: "set", path: "$.list.k", value: 1.0, wantErr: true},
+		{name: "set index in object", op: "set", path: "$.meta[0]", value: 1.0, wantErr: true},
+		{name: "set under scalar", op: "set", path: "$.user.name.first", value: "x", wantErr: true},
+		{
+			name: "delete key", op: "del", path: "$.user.tags",
+			want:   `{"list":[{"k":1},{"k":2}],"meta":{"v":1},"user":{"name":"ann"}}`,
+			shared: []string{"$.meta", "$.list"},
+			copied: []string{"$", "$.user"},
+		},
+		{
+			name: "delete array element", op: "del", path: "$.list[0]",
+			want:   `{"list":[{"k":2}],"meta":{"v":1},"user":{"name":"ann","tags":["a","b",{"x":1}]}}`,
+			shared: []string{"$.meta", "$.user"},
+			copied: []string{"$", "$.list"},
+		},
+		{name: "delete missing key", op: "del", path: "$.user.age", noop: true},
+		{name: "delete under missing key", op: "del", path: "$.nope.deeper", noop: true},
+		{name: "delete index out of range", op: "del", path: "$.list[9]", noop: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, testDoc)
+			before := encode(t, doc)
+			path, err := Parse(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out any
+			if tt.op == "set" {
+				out, err = Set(doc, path, tt.value)
+			} else {
+				var deleted bool
+				out, deleted, err = Delete(doc, path)
+				if deleted == tt.noop {
+					t.Fatalf("deleted = %v", deleted)
+				}
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, got %s", encode(t, out))
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			// Исходный документ не меняется ни при успехе, ни при ошибке
+			if got := encode(t, doc); got != before {
+				t.Fatalf("original changed: %s", got)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.noop {
+				if !same(out, doc) {
+					t.Fatal("noop delete copied the document")
+				}
+				return
+			}
+			if got := encode(t, out); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+
+			for _, p := range tt.shared {
+				path, _ := Parse(p)
+				a, _ := Get(doc, path)
+				b, _ := Get(out, path)
+				if !same(a, b) {
+					t.Errorf("%s was copied, want shared", p)
+				}
+			}
+			for _, p := range tt.copied {
+				path, _ := Parse(p)
+				a, _ := Get(doc, path)
+				b, _ := Get(out, path)
+				if same(a, b) {
+					t.Errorf("%s is shared, want a copy", p)
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{"", "$", false},
+		{"$", "$", false},
+		{"user.name", "$.user.name", false},
+		{"$.user.tags[0]", "$.user.tags[0]", false},
+		{"$['odd key'].x", "$.odd key.x", false},
+		{`$["q"][-1]`, "$.q[-1]", false},
+		{"$.a..b", "", true},
+		{"$.a[1", "", true},
+		{"$.a[x]", "", true},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) err = %v", tt.expr, err)
+			continue
+		}
+		if err == nil && p.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.expr, p, tt.want)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Предел размера строк/списков/словарей, которые может собрать скрипт
+const maxCollection = 1 << 20
+
+var errTooLarge = errors.New("value too large")
+
+// builtins — функции языка (без доступа к хосту)
+var builtins map[string]Func
+
+func init() {
+	builtins = map[string]Func{
+		"+":   arith(func(a, b float64) float64 { return a + b }),
+		"-":   minus,
+		"*":   arith(func(a, b float64) float64 { return a * b }),
+		"/":   divide,
+		"%":   modulo,
+		"min": arith(math.Min),
+		"max": arith(math.Max),
+		"abs": func(args []any) (any, error) {
+			n, err := oneNumber(args)
+			return math.Abs(n), err
+		},
+		"floor": func(args []any) (any, error) {
+			n, err := oneNumber(args)
+			return math.Floor(n), err
+		},
+		"ceil": func(args []any) (any, error) {
+			n, err := oneNumber(args)
+			return math.Ceil(n), err
+		},
+
+		"=":  func(args []any) (any, error) { return equalAll(args) },
+		"!=": func(args []any) (any, error) { eq, err := equalAll(args); return !eq, err },
+		"<":  compare(func(c int) bool { return c < 0 }),
+		"<=": compare(func(c int) bool { return c <= 0 }),
+		">":  compare(func(c int) bool { return c > 0 }),
+		">=": compare(func(c int) bool { return c >= 0 }),
+		"not": func(args []any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("expects 1 argument")
+			}
+			return !truthy(args[0]), nil
+		},
+		"nil?": func(args []any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("expects 1 argument")
+			}
+			return args[0] == nil, nil
+		},
+
+		"str":    str,
+		"num":    num,
+		"len":    length,
+		"list":   func(args []any) (any, error) { return append([]any{}, args...), nil },
+		"nth":    nth,
+		"append": appendList,
+		"dict":   dict,
+		"get":    get,
+		"assoc":  assoc,
+		"dissoc": dissoc,
+		"keys":   keys,
+
+		"now": func(args []any) (any, error) {
+			return float64(time.Now().UnixMilli()), nil
+		},
+		"error": func(args []any) (any, error) {
+			s, _ := str(args)
+			return nil, errors.New(s.(string))
+		},
+	}
+}
+
+func toNumber(v any) (float64, error) {
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expected number, got %s", typeName(v))
+	}
+	return n, nil
+}
+
+func oneNumber(args []any) (float64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("expects 1 argument")
+	}
+	return toNumber(args[0])
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "dict"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func arith(op func(a, b float64) float64) Func {
+	return func(args []any) (any, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("expects at least 1 argument")
+		}
+		acc, err := toNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range args[1:] {
+			n, err := toNumber(a)
+			if err != nil {
+				return nil, err
+			}
+			acc = op(acc, n)
+		}
+		return acc, nil
+	}
+}
+
+func minus(args []any) (any, error) {
+	if len(args) == 1 {
+		n, err := toNumber(args[0])
+		return -n, err
+	}
+	return arith(func(a, b float64) float64 { return a - b })(args)
+}
+
+func divide(args []any) (any, error) {
+	for _, a := range args[min(1, len(args)):] {
+		if n, ok := a.(float64); ok && n == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+	}
+	return arith(func(a, b float64) float64 { return a / b })(args)
+}
+
+func modulo(args []any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expects 2 arguments")
+	}
+	a, err := toNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := toNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return math.Mod(a, b), nil
+}
+
+func equalAll(args []any) (bool, error) {
+	if len(args) < 2 {
+		return false, fmt.Errorf("expects at least 2 arguments")
+	}
+	for _, a := range args[1:] {
+		if !reflect.DeepEqual(args[0], a) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compare сравнивает соседние аргументы: числа с числами, строки со строками
+func compare(ok func(c int) bool) Func {
+	return func(args []any) (any, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("expects at least 2 arguments")
+		}
+		for i := 0; i+1 < len(args); i++ {
+			var c int
+			switch a := args[i].(type) {
+			case float64:
+				b, err := toNumber(args[i+1])
+				if err != nil {
+					return nil, err
+				}
+				c = cmpFloat(a, b)
+			case string:
+				b, isStr := args[i+1].(string)
+				if !isStr {
+					return nil, fmt.Errorf("cannot compare string with %s", typeName(args[i+1]))
+				}
+				c = strings.Compare(a, b)
+			default:
+				return nil, fmt.Errorf("cannot compare %s", typeName(a))
+			}
+			if !ok(c) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// str склеивает аргументы в строку
+func str(args []any) (any, error) {
+	var b strings.Builder
+	for _, a := range args {
+		switch v := a.(type) {
+		case nil:
+		case string:
+			b.WriteString(v)
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			fmt.Fprint(&b, v)
+		}
+		if b.Len() > maxCollection {
+			return nil, errTooLarge
+		}
+	}
+	return b.String(), nil
+}
+
+func num(args []any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expects 1 argument")
+	}
+	switch v := args[0].(type) {
+	case float64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, nil // Не число — nil, а не ошибка: удобно для (or (num x) 0)
+		}
+		return n, nil
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	}
+	return nil, nil
+}
+
+func length(args []any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expects 1 argument")
+	}
+	switch v := args[0].(type) {
+	case nil:
+		return 0.0, nil
+	case string:
+		return float64(len(v)), nil
+	case []any:
+		return float64(len(v)), nil
+	case map[string]any:
+		return float64(len(v)), nil
+	}
+	return nil, fmt.Errorf("len of %s", typeName(args[0]))
+}
+
+func nth(args []any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expects 2 arguments")
+	}
+	list, ok := args[0].([]any)
+	if !ok {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("expected list, got %s", typeName(args[0]))
+	}
+	i, err := toNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+	idx := int(i)
+	if idx < 0 {
+		idx += len(list)
+	}
+	if idx < 0 || idx >= len(list) {
+		return nil, nil
+	}
+	return list[idx], nil
+}
+
+func appendList(args []any) (any, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("expects a list")
+	}
+	var list []any
+	switch v := args[0].(type) {
+	case nil:
+	case []any:
+		list = v
+	default:
+		return nil, fmt.Errorf("expected list, got %s", typeName(args[0]))
+	}
+	if len(list)+len(args)-1 > maxCollection {
+		return nil, errTooLarge
+	}
+	out := make([]any, 0, len(list)+len(args)-1)
+	out = append(out, list...)
+	return append(out, args[1:]...), nil
+}
+
+// dict собирает словарь из пар: (dict "a" 1 "b" 2)
+func dict(args []any) (any, error) {
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("expects key/value pairs")
+	}
+	m := make(map[string]any, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		k, ok := args[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key must be string, got %s", typeName(args[i]))
+		}
+		m[k] = args[i+1]
+	}
+	return m, nil
+}
+
+func asDict(v any) (map[string]any, error) {
+	switch m := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return m, nil
+	}
+	return nil, fmt.Errorf("expected dict, got %s", typeName(v))
+}
+
+// get достает поле словаря: (get d "field") или (get d "field" default)
+func get(args []any) (any, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expects 2 or 3 arguments")
+	}
+	m, err := asDict(args[0])
+	if err != nil {
+		return nil, err
+	}
+	k, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("key must be string")
+	}
+	if v, ok := m[k]; ok {
+		return v, nil
+	}
+	if len(args) == 3 {
+		return args[2], nil
+	}
+	return nil, nil
+}
+
+// assoc возвращает копию словаря с новым полем (исходное значение не меняется)
+func assoc(args []any) (any, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return nil, fmt.Errorf("expects dict and key/value pairs")
+	}
+	m, err := asDict(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(m)+len(args)/2 > maxCollection {
+		return nil, errTooLarge
+	}
+	out := make(map[string]any, len(m)+len(args)/2)
+	for k, v := range m {
+		out[k] = v
+	}
+	for i := 1; i < len(args); i += 2 {
+		k, ok := args[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("key must be string")
+		}
+		out[k] = args[i+1]
+	}
+	return out, nil
+}
+
+func dissoc(args []any) (any, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("expects a dict")
+	}
+	m, err := asDict(args[0])
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	for _, a := range args[1:] {
+		if k, ok := a.(string); ok {
+			delete(out, k)
+		}
+	}
+	return out, nil
+}
+
+func keys(args []any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expects 1 argument")
+	}
+	m, err := asDict(args[0])
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	out := make([]any, len(names))
+	for i, k := range names {
+		out[i] = k
+	}
+	return out, nil
+}
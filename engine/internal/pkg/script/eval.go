@@ -0,0 +1,257 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrStepLimit — скрипт превысил лимит шагов исполнения
+	ErrStepLimit = errors.New("script: step limit exceeded")
+)
+
+// Error — ошибка исполнения (в том числе вызванная из скрипта через (error "..."))
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("script: %s (at %d)", e.Msg, e.Pos)
+}
+
+// Func — функция, доступная скрипту. Аргументы уже вычислены.
+type Func func(args []any) (any, error)
+
+// Limits — ограничения песочницы
+type Limits struct {
+	MaxSteps int // Сколько узлов можно вычислить за один запуск
+}
+
+// Run исполняет программу. globals — переменные (например KEYS, ARGV), funcs — функции хоста
+// (например kv.get). Встроенные функции языка доступны всегда, хост может их переопределить.
+func (p *Program) Run(globals map[string]any, funcs map[string]Func, limits Limits) (any, error) {
+	vm := &vm{
+		funcs:    funcs,
+		maxSteps: limits.MaxSteps,
+	}
+	root := &env{vars: make(map[string]any, len(globals))}
+	for k, v := range globals {
+		root.vars[k] = v
+	}
+
+	var result any
+	for _, n := range p.body {
+		var err error
+		if result, err = vm.eval(n, root, 0); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type vm struct {
+	funcs    map[string]Func
+	steps    int
+	maxSteps int
+}
+
+// env — область видимости переменных
+type env struct {
+	vars   map[string]any
+	parent *env
+}
+
+func (e *env) lookup(name string) (any, bool) {
+	for cur := e; cur != nil; cur = cur.parent {
+		if v, ok := cur.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (e *env) assign(name string, v any) bool {
+	for cur := e; cur != nil; cur = cur.parent {
+		if _, ok := cur.vars[name]; ok {
+			cur.vars[name] = v
+			return true
+		}
+	}
+	return false
+}
+
+func errAt(n *node, format string, args ...any) error {
+	return &Error{Pos: n.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// truthy: ложны только nil и false
+func truthy(v any) bool {
+	return v != nil && v != false
+}
+
+func (vm *vm) eval(n *node, e *env, depth int) (any, error) {
+	vm.steps++
+	if vm.maxSteps > 0 && vm.steps > vm.maxSteps {
+		return nil, ErrStepLimit
+	}
+	if depth > maxDepth {
+		return nil, errAt(n, "nesting too deep")
+	}
+
+	switch n.kind {
+	case nodeLiteral:
+		return n.val, nil
+	case nodeSymbol:
+		v, ok := e.lookup(n.sym)
+		if !ok {
+			return nil, errAt(n, "undefined symbol '%s'", n.sym)
+		}
+		return v, nil
+	}
+
+	if len(n.list) == 0 {
+		return nil, nil
+	}
+
+	head := n.list[0]
+	if head.kind != nodeSymbol {
+		return nil, errAt(head, "expected function name")
+	}
+	args := n.list[1:]
+
+	// Специальные формы (аргументы вычисляются не все или не сразу)
+	switch head.sym {
+	case "if":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, errAt(n, "if expects 2 or 3 arguments")
+		}
+		cond, err := vm.eval(args[0], e, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return vm.eval(args[1], e, depth+1)
+		}
+		if len(args) == 3 {
+			return vm.eval(args[2], e, depth+1)
+		}
+		return nil, nil
+
+	case "do":
+		return vm.evalBody(args, e, depth)
+
+	case "let":
+		// (let ((a 1) (b (+ a 1))) body...) — привязки последовательные
+		if len(args) < 1 || args[0].kind != nodeList {
+			return nil, errAt(n, "let expects a binding list")
+		}
+		scope := &env{vars: make(map[string]any), parent: e}
+		for _, b := range args[0].list {
+			if b.kind != nodeList || len(b.list) != 2 || b.list[0].kind != nodeSymbol {
+				return nil, errAt(b, "let binding must be (name expr)")
+			}
+			v, err := vm.eval(b.list[1], scope, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			scope.vars[b.list[0].sym] = v
+		}
+		return vm.evalBody(args[1:], scope, depth)
+
+	case "set!":
+		if len(args) != 2 || args[0].kind != nodeSymbol {
+			return nil, errAt(n, "set! expects (set! name expr)")
+		}
+		v, err := vm.eval(args[1], e, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if !e.assign(args[0].sym, v) {
+			return nil, errAt(args[0], "undefined symbol '%s'", args[0].sym)
+		}
+		return v, nil
+
+	case "and":
+		var v any = true
+		for _, a := range args {
+			var err error
+			if v, err = vm.eval(a, e, depth+1); err != nil {
+				return nil, err
+			}
+			if !truthy(v) {
+				return v, nil
+			}
+		}
+		return v, nil
+
+	case "or":
+		var v any
+		for _, a := range args {
+			var err error
+			if v, err = vm.eval(a, e, depth+1); err != nil {
+				return nil, err
+			}
+			if truthy(v) {
+				return v, nil
+			}
+		}
+		return v, nil
+
+	case "while":
+		if len(args) < 1 {
+			return nil, errAt(n, "while expects a condition")
+		}
+		for {
+			cond, err := vm.eval(args[0], e, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(cond) {
+				return nil, nil
+			}
+			if _, err := vm.evalBody(args[1:], e, depth); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Обычный вызов функции
+	fn, ok := vm.funcs[head.sym]
+	if !ok {
+		fn, ok = builtins[head.sym]
+	}
+	if !ok {
+		return nil, errAt(head, "unknown function '%s'", head.sym)
+	}
+
+	vals := make([]any, len(args))
+	for i, a := range args {
+		v, err := vm.eval(a, e, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+
+	res, err := fn(vals)
+	if err != nil {
+		var se *Error
+		if errors.As(err, &se) || errors.Is(err, ErrStepLimit) {
+			return nil, err
+		}
+		return nil, errAt(head, "%s: %v", head.sym, err)
+	}
+	return res, nil
+}
+
+func (vm *vm) evalBody(body []*node, e *env, depth int) (any, error) {
+	var v any
+	for _, n := range body {
+		var err error
+		if v, err = vm.eval(n, e, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
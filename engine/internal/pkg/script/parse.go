@@ -0,0 +1,180 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Максимальная вложенность выражений (и при парсинге, и при исполнении)
+const maxDepth = 128
+
+type nodeKind int
+
+const (
+	nodeLiteral nodeKind = iota
+	nodeSymbol
+	nodeList
+)
+
+// node — узел AST
+type node struct {
+	kind nodeKind
+	val  any     // для nodeLiteral
+	sym  string  // для nodeSymbol
+	list []*node // для nodeList
+	pos  int
+}
+
+// Program — разобранный скрипт, готовый к многократному запуску
+type Program struct {
+	body []*node
+}
+
+// Parse разбирает исходник: последовательность S-выражений, результат — значение последнего
+func Parse(src string) (*Program, error) {
+	p := &parser{src: src}
+	var body []*node
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			break
+		}
+		n, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, n)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("script: empty program")
+	}
+	return &Program{body: body}, nil
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("script: parse error at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpace пропускает пробелы и комментарии (; до конца строки)
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ';' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if !unicode.IsSpace(rune(c)) {
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) parse(depth int) (*node, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("nesting too deep")
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		n := &node{kind: nodeList, pos: start}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.src) {
+				return nil, p.errorf("unclosed '(' opened at %d", start)
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				return n, nil
+			}
+			child, err := p.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, child)
+		}
+
+	case c == ')':
+		return nil, p.errorf("unexpected ')'")
+
+	case c == '"':
+		return p.parseString()
+
+	default:
+		for p.pos < len(p.src) && !unicode.IsSpace(rune(p.src[p.pos])) && !strings.ContainsRune("()\";", rune(p.src[p.pos])) {
+			p.pos++
+		}
+		return parseAtom(p.src[start:p.pos], start), nil
+	}
+}
+
+func (p *parser) parseString() (*node, error) {
+	start := p.pos
+	p.pos++ // открывающая кавычка
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return &node{kind: nodeLiteral, val: b.String(), pos: start}, nil
+		case '\\':
+			p.pos++
+			if p.pos >= len(p.src) {
+				return nil, p.errorf("unterminated string")
+			}
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+func parseAtom(tok string, pos int) *node {
+	switch tok {
+	case "nil":
+		return &node{kind: nodeLiteral, val: nil, pos: pos}
+	case "true":
+		return &node{kind: nodeLiteral, val: true, pos: pos}
+	case "false":
+		return &node{kind: nodeLiteral, val: false, pos: pos}
+	}
+	if looksNumeric(tok) {
+		if n, err := strconv.ParseFloat(tok, 64); err == nil {
+			return &node{kind: nodeLiteral, val: n, pos: pos}
+		}
+	}
+	return &node{kind: nodeSymbol, sym: tok, pos: pos}
+}
+
+// looksNumeric отсекает "inf"/"nan" и символы вроде "-", которые ParseFloat понял бы иначе
+func looksNumeric(tok string) bool {
+	t := strings.TrimLeft(tok, "+-")
+	return t != "" && (t[0] >= '0' && t[0] <= '9' || t[0] == '.' && len(t) > 1)
+}
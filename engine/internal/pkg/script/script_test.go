@@ -0,0 +1,117 @@
+package script
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// nested — выражение из n вложенных (do ...) вокруг 1
+func nested(n int) string {
+	return strings.Repeat("(do ", n) + "1" + strings.Repeat(")", n)
+}
+
+func TestStepLimit(t *testing.T) {
+	const loop = "(let ((i 0)) (while (< i 10) (set! i (+ i 1))) i)"
+	tests := []struct {
+		name     string
+		src      string
+		maxSteps int
+		want     any
+		wantErr  error
+	}{
+		{"exact budget", "(+ 1 2)", 3, 3.0, nil}, // Список и два литерала
+		{"one step short", "(+ 1 2)", 2, nil, ErrStepLimit},
+		{"loop fits", loop, 1000, 10.0, nil},
+		{"loop over budget", loop, 50, nil, ErrStepLimit},
+		{"endless loop", "(while true 1)", 10000, nil, ErrStepLimit},
+		{"no limit", "(let ((i 0)) (while (< i 100000) (set! i (+ i 1))) i)", 0, 100000.0, nil},
+		{"counted across top-level forms", "(+ 1 2) (+ 1 2)", 5, nil, ErrStepLimit},
+		{"host error passes through", "(host)", 100, nil, ErrStepLimit},
+	}
+	funcs := map[string]Func{
+		"host": func([]any) (any, error) { return nil, ErrStepLimit },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Run(nil, funcs, Limits{MaxSteps: tt.maxSteps})
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// Счетчик шагов свой у каждого запуска: программу можно гонять повторно
+func TestStepLimitPerRun(t *testing.T) {
+	p, err := Parse("(+ 1 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.Run(nil, nil, Limits{MaxSteps: 3}); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		parseErr bool
+	}{
+		{"max depth", nested(maxDepth), false},
+		{"too deep", nested(maxDepth + 1), true},
+		{"too deep in a later form", "1 " + nested(maxDepth+1), true},
+		{"too deep list literal", strings.Repeat("(list ", maxDepth+1) + strings.Repeat(")", maxDepth+1), true},
+		{"deep but unclosed", strings.Repeat("(", 10*maxDepth), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.src)
+			if tt.parseErr {
+				if err == nil || !strings.Contains(err.Error(), "parse error") {
+					t.Fatalf("err = %v, want a parse error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Что прошло парсер, не упирается в лимит вложенности при исполнении
+			got, err := p.Run(nil, nil, Limits{})
+			var se *Error
+			if errors.As(err, &se) || got != 1.0 {
+				t.Fatalf("got %v, %v", got, err)
+			}
+		})
+	}
+}
+
+// Исполнитель проверяет вложенность сам, не полагаясь на парсер (AST можно собрать и в обход Parse)
+func TestEvalDepthLimit(t *testing.T) {
+	p, err := Parse(nested(maxDepth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deeper := &Program{body: []*node{{kind: nodeList, list: []*node{{kind: nodeSymbol, sym: "do"}, p.body[0]}}}}
+
+	_, err = deeper.Run(nil, nil, Limits{})
+	var se *Error
+	if !errors.As(err, &se) || se.Msg != "nesting too deep" {
+		t.Fatalf("err = %v, want nesting too deep", err)
+	}
+}
@@ -0,0 +1,135 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const bloomVersion = 1
+
+// Не даем одним запросом занять гигабайты памяти
+const maxBloomBits = 1 << 33 // 1 GiB
+
+var ErrBadParams = errors.New("bad sketch parameters")
+
+// Bloom — фильтр Блума: "точно не видели" или "скорее всего видели"
+type Bloom struct {
+	m         uint64 // Бит в фильтре
+	k         uint32 // Хеш-функций
+	n         uint64 // Добавлено элементов (для информации)
+	capacity  uint64
+	errorRate float64
+	bits      []uint64
+}
+
+// NewBloom рассчитывает размер под capacity элементов с заданной вероятностью ложного срабатывания
+func NewBloom(capacity uint64, errorRate float64) (*Bloom, error) {
+	if capacity == 0 || errorRate <= 0 || errorRate >= 1 {
+		return nil, ErrBadParams
+	}
+
+	m := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if m > maxBloomBits {
+		return nil, ErrBadParams
+	}
+	k := max(1, uint32(math.Round(m/float64(capacity)*math.Ln2)))
+
+	words := (uint64(m) + 63) / 64
+	return &Bloom{
+		m:         words * 64,
+		k:         k,
+		capacity:  capacity,
+		errorRate: errorRate,
+		bits:      make([]uint64, words),
+	}, nil
+}
+
+func (b *Bloom) Kind() string { return KindBloom }
+
+// Add добавляет элемент. Возвращает false, если элемент (вероятно) уже был.
+func (b *Bloom) Add(item string) bool {
+	h1, h2 := hashPair(item)
+	added := false
+	for i := uint64(0); i < uint64(b.k); i++ {
+		pos := (h1 + i*h2) % b.m
+		word, mask := pos/64, uint64(1)<<(pos%64)
+		if b.bits[word]&mask == 0 {
+			b.bits[word] |= mask
+			added = true
+		}
+	}
+	if added {
+		b.n++
+	}
+	return added
+}
+
+// Test проверяет, мог ли элемент быть добавлен
+func (b *Bloom) Test(item string) bool {
+	h1, h2 := hashPair(item)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(uint64(1)<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomInfo — параметры фильтра
+type BloomInfo struct {
+	Capacity  uint64  `json:"capacity"`
+	ErrorRate float64 `json:"error_rate"`
+	Bits      uint64  `json:"bits"`
+	Hashes    uint32  `json:"hashes"`
+	Items     uint64  `json:"items"`
+}
+
+func (b *Bloom) Info() BloomInfo {
+	return BloomInfo{Capacity: b.capacity, ErrorRate: b.errorRate, Bits: b.m, Hashes: b.k, Items: b.n}
+}
+
+func (b *Bloom) Clone() Sketch {
+	cp := *b
+	cp.bits = append([]uint64(nil), b.bits...)
+	return &cp
+}
+
+// Формат: версия | k uint32 | n | capacity | errorRate (float64 bits) | слова битового массива
+func (b *Bloom) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, 1+4+8*3+len(b.bits)*8)
+	out = append(out, bloomVersion)
+	out = binary.LittleEndian.AppendUint32(out, b.k)
+	out = binary.LittleEndian.AppendUint64(out, b.n)
+	out = binary.LittleEndian.AppendUint64(out, b.capacity)
+	out = binary.LittleEndian.AppendUint64(out, math.Float64bits(b.errorRate))
+	for _, w := range b.bits {
+		out = binary.LittleEndian.AppendUint64(out, w)
+	}
+	return out, nil
+}
+
+func unmarshalBloom(data []byte) (*Bloom, error) {
+	const header = 1 + 4 + 8*3
+	if len(data) < header || data[0] != bloomVersion || (len(data)-header)%8 != 0 {
+		return nil, ErrCorrupted
+	}
+
+	b := &Bloom{
+		k:         binary.LittleEndian.Uint32(data[1:]),
+		n:         binary.LittleEndian.Uint64(data[5:]),
+		capacity:  binary.LittleEndian.Uint64(data[13:]),
+		errorRate: math.Float64frombits(binary.LittleEndian.Uint64(data[21:])),
+	}
+	words := (len(data) - header) / 8
+	if words == 0 || b.k == 0 {
+		return nil, ErrCorrupted
+	}
+	b.bits = make([]uint64, words)
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[header+i*8:])
+	}
+	b.m = uint64(words) * 64
+	return b, nil
+}
@@ -0,0 +1,193 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const cmsVersion = 1
+
+// Ограничение на размер таблицы (ячейки по 8 байт)
+const maxCMSCells = 1 << 26
+
+// CMS — Count-Min Sketch с отслеживанием top-k самых частых элементов.
+// Оценка частоты никогда не занижена, завышена не больше чем на ~e/width * total.
+type CMS struct {
+	width, depth uint32
+	total        uint64
+	counts       []uint64 // depth строк по width ячеек
+
+	k   int               // Размер top-k (0 — не отслеживаем)
+	top map[string]uint64 // Кандидаты в top-k с последней оценкой
+}
+
+// TopEntry — элемент top-k
+type TopEntry struct {
+	Item  string `json:"item"`
+	Count uint64 `json:"count"`
+}
+
+func NewCMS(width, depth uint32, k int) (*CMS, error) {
+	if width == 0 || depth == 0 || uint64(width)*uint64(depth) > maxCMSCells || k < 0 || k > 1000 {
+		return nil, ErrBadParams
+	}
+	return &CMS{
+		width:  width,
+		depth:  depth,
+		counts: make([]uint64, int(width)*int(depth)),
+		k:      k,
+		top:    make(map[string]uint64, k),
+	}, nil
+}
+
+func (c *CMS) Kind() string { return KindCMS }
+
+// Incr увеличивает счетчик элемента и возвращает новую оценку
+func (c *CMS) Incr(item string, by uint64) uint64 {
+	h1, h2 := hashPair(item)
+	est := ^uint64(0)
+	for row := uint64(0); row < uint64(c.depth); row++ {
+		cell := row*uint64(c.width) + (h1+row*h2)%uint64(c.width)
+		c.counts[cell] += by
+		est = min(est, c.counts[cell])
+	}
+	c.total += by
+	c.trackTop(item, est)
+	return est
+}
+
+// Query возвращает оценку частоты элемента
+func (c *CMS) Query(item string) uint64 {
+	h1, h2 := hashPair(item)
+	est := ^uint64(0)
+	for row := uint64(0); row < uint64(c.depth); row++ {
+		cell := row*uint64(c.width) + (h1+row*h2)%uint64(c.width)
+		est = min(est, c.counts[cell])
+	}
+	return est
+}
+
+// trackTop держит k кандидатов: новый элемент вытесняет самый редкий, если встречается чаще
+func (c *CMS) trackTop(item string, est uint64) {
+	if c.k == 0 {
+		return
+	}
+	if _, ok := c.top[item]; ok || len(c.top) < c.k {
+		c.top[item] = est
+		return
+	}
+
+	minItem, minCount := "", ^uint64(0)
+	for it, n := range c.top {
+		if n < minCount || (n == minCount && it < minItem) {
+			minItem, minCount = it, n
+		}
+	}
+	if est > minCount {
+		delete(c.top, minItem)
+		c.top[item] = est
+	}
+}
+
+// Top возвращает top-k по убыванию частоты
+func (c *CMS) Top() []TopEntry {
+	out := make([]TopEntry, 0, len(c.top))
+	for it := range c.top {
+		// Оценка могла вырасти из-за коллизий с другими элементами — берем актуальную
+		out = append(out, TopEntry{Item: it, Count: c.Query(it)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Item < out[j].Item
+	})
+	return out
+}
+
+// Total — сумма всех инкрементов
+func (c *CMS) Total() uint64 { return c.total }
+
+func (c *CMS) Clone() Sketch {
+	cp := *c
+	cp.counts = append([]uint64(nil), c.counts...)
+	cp.top = make(map[string]uint64, len(c.top))
+	for it, n := range c.top {
+		cp.top[it] = n
+	}
+	return &cp
+}
+
+// Формат: версия | width | depth | k | total | счетчики (uvarint, таблица в основном из нулей) | top-k
+func (c *CMS) MarshalBinary() ([]byte, error) {
+	out := []byte{cmsVersion}
+	out = binary.LittleEndian.AppendUint32(out, c.width)
+	out = binary.LittleEndian.AppendUint32(out, c.depth)
+	out = binary.LittleEndian.AppendUint32(out, uint32(c.k))
+	out = binary.AppendUvarint(out, c.total)
+	for _, n := range c.counts {
+		out = binary.AppendUvarint(out, n)
+	}
+
+	out = binary.AppendUvarint(out, uint64(len(c.top)))
+	for it, n := range c.top {
+		out = binary.AppendUvarint(out, uint64(len(it)))
+		out = append(out, it...)
+		out = binary.AppendUvarint(out, n)
+	}
+	return out, nil
+}
+
+func unmarshalCMS(data []byte) (*CMS, error) {
+	if len(data) < 13 || data[0] != cmsVersion {
+		return nil, ErrCorrupted
+	}
+	// Каждый счетчик (и total) — хотя бы байт: таблицу больше данных не выделяем
+	width, depth := binary.LittleEndian.Uint32(data[1:]), binary.LittleEndian.Uint32(data[5:])
+	if uint64(width)*uint64(depth) >= uint64(len(data)-13) {
+		return nil, ErrCorrupted
+	}
+	c, err := NewCMS(width, depth, int(binary.LittleEndian.Uint32(data[9:])))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+
+	r := data[13:]
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(r)
+		if n <= 0 {
+			return 0, false
+		}
+		r = r[n:]
+		return v, true
+	}
+
+	var ok bool
+	if c.total, ok = next(); !ok {
+		return nil, ErrCorrupted
+	}
+	for i := range c.counts {
+		if c.counts[i], ok = next(); !ok {
+			return nil, ErrCorrupted
+		}
+	}
+
+	topLen, ok := next()
+	if !ok || topLen > uint64(c.k) {
+		return nil, ErrCorrupted
+	}
+	for i := uint64(0); i < topLen; i++ {
+		l, ok := next()
+		if !ok || l > uint64(len(r)) {
+			return nil, ErrCorrupted
+		}
+		it := string(r[:l])
+		r = r[l:]
+		n, ok := next()
+		if !ok {
+			return nil, ErrCorrupted
+		}
+		c.top[it] = n
+	}
+	return c, nil
+}
@@ -0,0 +1,136 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// Точность: 2^14 регистров, стандартная ошибка ~0.81%
+const (
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+)
+
+// Форматы сериализации HLL
+const (
+	hllDense  = 1 // Все регистры подряд
+	hllSparse = 2 // Только ненулевые: пары (индекс uint16, значение uint8)
+)
+
+// HLL — HyperLogLog для оценки числа уникальных элементов
+type HLL struct {
+	reg []uint8
+}
+
+func NewHLL() *HLL {
+	return &HLL{reg: make([]uint8, hllRegisters)}
+}
+
+func (h *HLL) Kind() string { return KindHLL }
+
+// Add добавляет элемент. Возвращает true, если оценка могла измениться.
+func (h *HLL) Add(item string) bool {
+	x := hash64(item)
+	idx := x >> (64 - hllPrecision)
+	// Ставим сторожевой бит, чтобы rho не превысил 64-p+1
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	rho := uint8(bits.LeadingZeros64(w) + 1)
+
+	if rho > h.reg[idx] {
+		h.reg[idx] = rho
+		return true
+	}
+	return false
+}
+
+// Count возвращает оценку числа уникальных элементов
+func (h *HLL) Count() uint64 {
+	const m = float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum, zeros := 0.0, 0
+	for _, r := range h.reg {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	est := alpha * m * m / sum
+	// На малых мощностях точнее linear counting
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+// Merge объединяет other в h (оценка станет оценкой объединения множеств)
+func (h *HLL) Merge(other *HLL) {
+	for i, r := range other.reg {
+		if r > h.reg[i] {
+			h.reg[i] = r
+		}
+	}
+}
+
+func (h *HLL) Clone() Sketch {
+	return &HLL{reg: append([]uint8(nil), h.reg...)}
+}
+
+// MarshalBinary выбирает разреженный формат, пока он меньше плотного
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	nonZero := 0
+	for _, r := range h.reg {
+		if r != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero*3 < hllRegisters {
+		out := make([]byte, 1, 1+nonZero*3)
+		out[0] = hllSparse
+		for i, r := range h.reg {
+			if r != 0 {
+				out = binary.LittleEndian.AppendUint16(out, uint16(i))
+				out = append(out, r)
+			}
+		}
+		return out, nil
+	}
+
+	out := make([]byte, 1+hllRegisters)
+	out[0] = hllDense
+	copy(out[1:], h.reg)
+	return out, nil
+}
+
+func unmarshalHLL(data []byte) (*HLL, error) {
+	if len(data) == 0 {
+		return nil, ErrCorrupted
+	}
+	h := NewHLL()
+
+	switch data[0] {
+	case hllDense:
+		if len(data) != 1+hllRegisters {
+			return nil, ErrCorrupted
+		}
+		copy(h.reg, data[1:])
+	case hllSparse:
+		body := data[1:]
+		if len(body)%3 != 0 {
+			return nil, ErrCorrupted
+		}
+		for i := 0; i < len(body); i += 3 {
+			idx := binary.LittleEndian.Uint16(body[i:])
+			if int(idx) >= hllRegisters {
+				return nil, ErrCorrupted
+			}
+			h.reg[idx] = body[i+2]
+		}
+	default:
+		return nil, ErrCorrupted
+	}
+	return h, nil
+}
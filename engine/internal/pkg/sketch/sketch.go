@@ -0,0 +1,61 @@
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// Типы структур (они же — метка в сериализованном виде)
+const (
+	KindHLL   = "hll"
+	KindBloom = "bloom"
+	KindCMS   = "cms"
+)
+
+var ErrCorrupted = errors.New("corrupted sketch data")
+
+// Sketch — вероятностная структура с компактным бинарным представлением.
+// Методы не потокобезопасны: синхронизация на вызывающей стороне.
+type Sketch interface {
+	Kind() string
+	MarshalBinary() ([]byte, error)
+	Clone() Sketch
+}
+
+// Unmarshal восстанавливает структуру по метке типа и бинарным данным
+func Unmarshal(kind string, data []byte) (Sketch, error) {
+	switch kind {
+	case KindHLL:
+		return unmarshalHLL(data)
+	case KindBloom:
+		return unmarshalBloom(data)
+	case KindCMS:
+		return unmarshalCMS(data)
+	default:
+		return nil, fmt.Errorf("unknown sketch type %q", kind)
+	}
+}
+
+// hash64 — FNV-1a с финальным перемешиванием (splitmix64).
+// Сам FNV плохо распределяет старшие биты на коротких строках, а HLL берет индекс именно из них.
+// Хеш детерминирован между запусками: иначе сохраненные фильтры перестанут совпадать.
+func hash64(item string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	x := h.Sum64()
+
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// hashPair — два независимых хеша для double hashing (Kirsch–Mitzenmacher): h1 + i*h2
+func hashPair(item string) (uint64, uint64) {
+	h1 := hash64(item)
+	h2 := hash64(item + "\x00")
+	return h1, h2 | 1 // Нечетный шаг, чтобы не зациклиться на части позиций
+}
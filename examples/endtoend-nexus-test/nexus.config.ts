@@ -6,6 +6,8 @@ export default {
   engine: {
     port: 4000,
     path: "../../engine", // Путь к Go Engine
+    // Сообщения чата публикуются прямо из браузера, API только проверяет их
+    args: ["--ws-publish-hook", "http://localhost:3000/api/chat/validate"],
   },
   frontend: {
     port: 5173,
@@ -55,6 +55,11 @@ export function Chat({ username }: { username: string }) {
         ws.onmessage = (event) => {
           if (!isMounted) return;
           const msg = JSON.parse(event.data);
+          if (msg.type === "error") {
+            console.error("Message rejected:", msg.error);
+            return;
+          }
+          if (msg.type) return; // ack
           const newMsg = msg.data as Message;
 
           setMessages((prev) => {
@@ -84,8 +89,9 @@ export function Chat({ username }: { username: string }) {
     };
   }, [username]);
 
-  const sendMessage = async () => {
-    if (!inputValue) return;
+  const sendMessage = () => {
+    const ws = wsRef.current;
+    if (!inputValue || !ws || ws.readyState !== WebSocket.OPEN) return;
     const messageId = crypto.randomUUID();
 
     setMessages((prev) => [
@@ -98,13 +104,16 @@ export function Chat({ username }: { username: string }) {
       },
     ]);
 
-    await client.api.chat.send.$post({
-      json: {
+    // Публикуем прямо в сокет: Engine проверит право по тикету,
+    // а содержимое — через хук нашего API (/api/chat/validate)
+    ws.send(
+      JSON.stringify({
+        type: "publish",
         id: messageId,
-        username,
-        text: inputValue,
-      },
-    });
+        channel: "global-chat",
+        data: { id: messageId, username, text: inputValue },
+      })
+    );
 
     setInputValue("");
   };
@@ -3,6 +3,7 @@ import { cors } from "hono/cors";
 import { z } from "zod";
 import { zValidator } from "@hono/zod-validator";
 import { createNexus } from "@nexus/sdk";
+import type { PublishHookRequest } from "@nexus/sdk";
 
 const app = new Hono();
 const nexus = createNexus();
@@ -18,8 +19,8 @@ const userSchema = z.object({
 
 const messageSchema = z.object({
   id: z.uuid(),
-  text: z.string().min(1),
-  username: z.string(), // В реальном приложении это берется из Session/JWT
+  text: z.string().min(1).max(2000),
+  username: z.string(), // Engine подставляет user_id из тикета, ниже сверяем
 });
 
 // Экспорт типов для использования на фронтенде
@@ -38,17 +39,20 @@ const chatApp = new Hono()
       const ticket = await nexus.ws.createTicket({
         userId: username,
         channels: ["global-chat"],
+        publish: ["global-chat"],
       });
       return c.json({ ticket, wsUrl: "ws://localhost:4000/ws" });
     }
   )
-  .post("/send", zValidator("json", messageSchema), async (c) => {
-    const msg = c.req.valid("json");
-    await nexus.ws.publish("global-chat", {
-      ...msg,
-      timestamp: Date.now(),
-    });
-    return c.json({ success: true });
+  // Хук Engine: проверяет сообщения, которые браузер публикует через сокет
+  .post("/validate", async (c) => {
+    const req = await c.req.json<PublishHookRequest>();
+    const parsed = messageSchema.safeParse(req.data);
+    if (!parsed.success) return c.text("Invalid message", 422);
+    if (parsed.data.username !== req.user_id) {
+      return c.text("Username does not match ticket", 403);
+    }
+    return c.json({ data: { ...parsed.data, timestamp: Date.now() } });
   });
 
 // 2. Пользователи (Sub-App)
@@ -18,6 +18,7 @@ export async function startDev(config: NexusConfig) {
       "data",
       "--log-level",
       "2",
+      ...(config.engine.args || []),
     ],
     // Указываем явно, что cwd — строка (хотя она и так строка в конфиге)
     cwd: config.engine.path,
@@ -8,6 +8,7 @@ export interface NexusConfig {
   engine: {
     port: number;
     path: string; // Путь к папке engine (../../engine)
+    args?: string[]; // Дополнительные флаги Engine (["--ws-publish-hook", "..."])
   };
   frontend?: {
     port: number;
@@ -1,11 +1,17 @@
 import { NexusClient } from "./core/client";
 import type { NexusConfig } from "./core/client";
 import { KVModule } from "./modules/kv";
+import { QueueModule } from "./modules/queue";
+import { RateLimitModule } from "./modules/ratelimit";
+import { SchedulerModule } from "./modules/scheduler";
 import { WSModule } from "./modules/ws";
 
 export class Nexus {
   public readonly kv: KVModule;
   public readonly ws: WSModule;
+  public readonly rateLimit: RateLimitModule;
+  public readonly queue: QueueModule;
+  public readonly scheduler: SchedulerModule;
 
   private client: NexusClient;
 
@@ -13,6 +19,9 @@ export class Nexus {
     this.client = new NexusClient(config);
     this.kv = new KVModule(this.client);
     this.ws = new WSModule(this.client);
+    this.rateLimit = new RateLimitModule(this.client);
+    this.queue = new QueueModule(this.client);
+    this.scheduler = new SchedulerModule(this.client);
   }
 }
 
@@ -26,3 +35,13 @@ export const createNexus = (config?: Partial<NexusConfig>) => {
 
 // Экспортируем типы, чтобы пользователь мог их использовать
 export * from "./types";
+export type {
+  HistoryReplay,
+  PollResponse,
+  PresenceEvent,
+  PresenceMember,
+  PublishHookRequest,
+  ResumeInfo,
+  WSCommand,
+  WSMessage,
+} from "./modules/ws";
@@ -5,6 +5,132 @@ export interface SetOptions {
   ttl?: number;
 }
 
+export type JsonOperation =
+  | { op: "get"; path: string }
+  | { op: "set"; path: string; value: JsonValue }
+  | { op: "del"; path: string }
+  | { op: "append"; path: string; value: JsonValue }
+  | { op: "incr"; path: string; by: number };
+
+export interface IndexDefinition {
+  name: string;
+  /** Префикс ключей документов, например "order:" */
+  prefix: string;
+  /** JSON пути индексируемых полей, например ["$.status", "$.customerId"] */
+  fields: string[];
+}
+
+export interface QueryOptions {
+  where?: {
+    field: string;
+    op: "eq" | "ne" | "lt" | "lte" | "gt" | "gte";
+    value: string | number | boolean | null;
+  }[];
+  sort?: { field: string; desc?: boolean };
+  limit?: number;
+  offset?: number;
+}
+
+export interface QueryResult<T> {
+  items: { key: string; value: T }[];
+  total: number;
+  /** Отсутствует, если это последняя страница */
+  next_offset?: number;
+}
+
+export interface KeyVersion<T> {
+  version?: number;
+  /** RFC3339 время записи версии */
+  at: string;
+  value?: T;
+  deleted?: boolean;
+}
+
+export interface LockOptions {
+  /** Аренда в миллисекундах */
+  leaseMs: number;
+  /** Сколько ждать в очереди, если лок занят (0 — не ждать) */
+  waitMs?: number;
+}
+
+export interface Lock {
+  name: string;
+  owner: string;
+  /** Fencing token: передавайте его в downstream, чтобы отсекать запоздавших владельцев */
+  token: number;
+  /** Unix ms */
+  expires_at: number;
+}
+
+export interface TxCondition {
+  exists?: boolean;
+  equals?: JsonValue;
+  /** Для incr: результат не меньше */
+  min?: number;
+  /** Для incr: результат не больше */
+  max?: number;
+}
+
+export type TxOperation =
+  | { op: "get"; key: string; if?: TxCondition }
+  | { op: "set"; key: string; value: JsonValue; ttl?: number; if?: TxCondition }
+  | { op: "del"; key: string; if?: TxCondition }
+  | { op: "incr"; key: string; by: number; if?: TxCondition };
+
+export interface TxResult {
+  key: string;
+  value?: JsonValue;
+  found: boolean;
+  version?: number;
+}
+
+export type TxResponse =
+  | { ok: true; results: TxResult[] }
+  | { ok: false; reason: "watch" | "condition"; key: string; op: number };
+
+export interface BloomOptions {
+  /** Ожидаемое число элементов */
+  capacity?: number;
+  /** Допустимая вероятность ложного срабатывания, например 0.01 */
+  errorRate?: number;
+  ttl?: number;
+}
+
+export interface SketchOptions {
+  width?: number;
+  depth?: number;
+  /** Сколько самых частых элементов отслеживать (0 — не отслеживать) */
+  topK?: number;
+  ttl?: number;
+}
+
+export interface TopEntry {
+  item: string;
+  count: number;
+}
+
+export interface StreamEntry<T> {
+  /** "<unix ms>-<seq>", растет монотонно */
+  id: string;
+  /** null, если запись уже обрезана по maxlen, но еще висит в pending */
+  value: T;
+}
+
+export interface PendingEntry {
+  id: string;
+  consumer: string;
+  /** Unix ms последней выдачи */
+  delivered_at: number;
+  deliveries: number;
+  idle_ms: number;
+}
+
+export interface StreamReadOptions {
+  count?: number;
+  /** Ждать новых записей (long-poll), мс */
+  blockMs?: number;
+}
+
 export class KVModule {
   constructor(private readonly client: NexusClient) {}
 
@@ -25,6 +151,37 @@ export class KVModule {
     }
   }
 
+  /**
+   * getAt возвращает значение ключа на момент времени (нужна история для префикса: -kv-history)
+   */
+  async getAt<T extends JsonValue>(key: string, at: Date): Promise<T | null> {
+    try {
+      return await this.client.request<T>(
+        "GET",
+        `/kv/get?key=${encodeURIComponent(key)}&at=${at.getTime()}`
+      );
+    } catch (e: any) {
+      if (e.message && e.message.includes("404")) {
+        return null;
+      }
+      throw e;
+    }
+  }
+
+  /**
+   * history возвращает сохраненные версии ключа (новые первыми)
+   */
+  async history<T extends JsonValue>(
+    key: string,
+    limit?: number
+  ): Promise<KeyVersion<T>[]> {
+    const query = limit ? `&limit=${limit}` : "";
+    return await this.client.request<KeyVersion<T>[]>(
+      "GET",
+      `/kv/history?key=${encodeURIComponent(key)}${query}`
+    );
+  }
+
   /**
    * set принимает только валидный JsonValue.
    * Если пользователь попытается сунуть функцию () => {}, TS выдаст ошибку компиляции.
@@ -40,4 +197,384 @@ export class KVModule {
       ttl: options?.ttl || 0,
     });
   }
+
+  /**
+   * delete удаляет ключ (и из origin, если включен write-through/write-behind)
+   */
+  async delete(key: string): Promise<void> {
+    await this.client.request("POST", "/kv/delete", { key });
+  }
+
+  /**
+   * tx атомарно выполняет набор операций.
+   * watch: ключ -> версия, которую видел клиент (0 = ключа не было).
+   * Если ключ успел измениться или условие не выполнено — ничего не применяется, ok=false.
+   */
+  async tx(
+    ops: TxOperation[],
+    watch?: Record<string, number>
+  ): Promise<TxResponse> {
+    try {
+      return await this.client.request<TxResponse>("POST", "/kv/tx", {
+        ops,
+        watch: watch || {},
+      } as unknown as JsonValue);
+    } catch (e: any) {
+      // 409 — транзакция отклонена, тело содержит причину
+      const match = e.message?.match(/409: (.*)$/s);
+      if (match) {
+        return JSON.parse(match[1]) as TxResponse;
+      }
+      throw e;
+    }
+  }
+
+  /**
+   * json выполняет операцию над частью JSON документа по пути ($.user.tags[0]).
+   * Документ целиком не пересылается: сервер меняет его атомарно и пишет в журнал только патч.
+   */
+  async json<T extends JsonValue>(
+    key: string,
+    operation: JsonOperation
+  ): Promise<T | null> {
+    try {
+      const res = await this.client.request<{ result: T }>(
+        "POST",
+        "/kv/json",
+        { key, ...operation }
+      );
+      return res.result;
+    } catch (e: any) {
+      if (e.message && e.message.includes("404")) {
+        return null;
+      }
+      throw e;
+    }
+  }
+
+  /**
+   * createIndex объявляет вторичный индекс по полям JSON документов с префиксом
+   */
+  async createIndex(definition: IndexDefinition): Promise<void> {
+    await this.client.request("POST", "/kv/index", {
+      ...definition,
+    });
+  }
+
+  /**
+   * query ищет документы по индексу (равенство, диапазоны, сортировка, пагинация)
+   */
+  async query<T extends JsonValue>(
+    index: string,
+    options: QueryOptions = {}
+  ): Promise<QueryResult<T>> {
+    return await this.client.request<QueryResult<T>>("POST", "/kv/query", {
+      index,
+      ...options,
+    } as unknown as JsonValue);
+  }
+
+  /**
+   * acquireLock захватывает распределенный лок. Возвращает null, если не дождались.
+   */
+  async acquireLock(
+    name: string,
+    owner: string,
+    options: LockOptions
+  ): Promise<Lock | null> {
+    try {
+      return await this.client.request<Lock>("POST", "/kv/lock/acquire", {
+        name,
+        owner,
+        lease_ms: options.leaseMs,
+        wait_ms: options.waitMs || 0,
+      });
+    } catch (e: any) {
+      if (e.message && e.message.includes("409")) {
+        return null;
+      }
+      throw e;
+    }
+  }
+
+  /**
+   * renewLock продлевает аренду. Бросает ошибку, если лок уже потерян.
+   */
+  async renewLock(lock: Lock, leaseMs: number): Promise<Lock> {
+    return await this.client.request<Lock>("POST", "/kv/lock/renew", {
+      name: lock.name,
+      owner: lock.owner,
+      token: lock.token,
+      lease_ms: leaseMs,
+    });
+  }
+
+  /**
+   * releaseLock отпускает лок (только владелец с актуальным токеном)
+   */
+  async releaseLock(lock: Lock): Promise<void> {
+    await this.client.request("POST", "/kv/lock/release", {
+      name: lock.name,
+      owner: lock.owner,
+      token: lock.token,
+    });
+  }
+
+  /**
+   * loadScript компилирует скрипт на сервере и возвращает его SHA1 для evalScript
+   */
+  async loadScript(source: string): Promise<string> {
+    const res = await this.client.request<{ sha: string }>(
+      "POST",
+      "/kv/script/load",
+      { source }
+    );
+    return res.sha;
+  }
+
+  /**
+   * evalScript атомарно выполняет скрипт (по SHA или исходнику) над ключами keys.
+   * Внутри скрипта доступны KEYS, ARGV и функции kv.get/kv.set/kv.del/kv.incr/kv.exists.
+   */
+  async evalScript<T extends JsonValue>(
+    script: { sha: string } | { source: string },
+    keys: string[],
+    args: JsonValue[] = []
+  ): Promise<T> {
+    const res = await this.client.request<{ result: T }>(
+      "POST",
+      "/kv/script/eval",
+      { ...script, keys, args }
+    );
+    return res.result;
+  }
+
+  private async sketch<T>(
+    type: "hll" | "bloom" | "cms",
+    body: Record<string, JsonValue | undefined>
+  ): Promise<T> {
+    const res = await this.client.request<{ result: T }>(
+      "POST",
+      `/kv/${type}`,
+      body as unknown as JsonValue
+    );
+    return res.result;
+  }
+
+  /**
+   * hllAdd добавляет элементы в HyperLogLog. true — оценка изменилась.
+   */
+  async hllAdd(key: string, items: string[]): Promise<boolean> {
+    return await this.sketch<boolean>("hll", { key, op: "add", items });
+  }
+
+  /**
+   * hllCount оценивает число уникальных элементов (объединение, если ключей несколько)
+   */
+  async hllCount(...keys: string[]): Promise<number> {
+    const [key, ...rest] = keys;
+    return await this.sketch<number>("hll", { key, op: "count", keys: rest });
+  }
+
+  /**
+   * hllMerge записывает в dest объединение dest и sources
+   */
+  async hllMerge(dest: string, sources: string[]): Promise<number> {
+    return await this.sketch<number>("hll", {
+      key: dest,
+      op: "merge",
+      keys: sources,
+    });
+  }
+
+  /**
+   * bloomReserve создает фильтр под capacity элементов с заданной долей ложных срабатываний.
+   * Без reserve фильтр создается первым add с параметрами по умолчанию.
+   */
+  async bloomReserve(key: string, options: BloomOptions): Promise<void> {
+    await this.sketch("bloom", {
+      key,
+      op: "reserve",
+      capacity: options.capacity,
+      error_rate: options.errorRate,
+      ttl: options.ttl,
+    });
+  }
+
+  /**
+   * bloomAdd возвращает для каждого элемента: true — добавлен впервые
+   */
+  async bloomAdd(key: string, items: string[]): Promise<boolean[]> {
+    return await this.sketch<boolean[]>("bloom", { key, op: "add", items });
+  }
+
+  /**
+   * bloomCheck: false — элемента точно не было, true — скорее всего был
+   */
+  async bloomCheck(key: string, items: string[]): Promise<boolean[]> {
+    try {
+      return await this.sketch<boolean[]>("bloom", { key, op: "check", items });
+    } catch (e: any) {
+      if (e.message && e.message.includes("404")) {
+        return items.map(() => false);
+      }
+      throw e;
+    }
+  }
+
+  /**
+   * cmsInit создает Count-Min Sketch. Без init он создается первым incr с параметрами по умолчанию.
+   */
+  async cmsInit(key: string, options: SketchOptions): Promise<void> {
+    await this.sketch("cms", {
+      key,
+      op: "init",
+      width: options.width,
+      depth: options.depth,
+      top_k: options.topK,
+      ttl: options.ttl,
+    });
+  }
+
+  /**
+   * cmsIncr увеличивает частоты элементов и возвращает новые оценки
+   */
+  async cmsIncr(key: string, items: string[], by = 1): Promise<number[]> {
+    return await this.sketch<number[]>("cms", { key, op: "incr", items, by });
+  }
+
+  /**
+   * cmsQuery возвращает оценки частот (никогда не занижены)
+   */
+  async cmsQuery(key: string, items: string[]): Promise<number[]> {
+    return await this.sketch<number[]>("cms", { key, op: "query", items });
+  }
+
+  /**
+   * cmsTop возвращает самые частые элементы по убыванию
+   */
+  async cmsTop(key: string): Promise<TopEntry[]> {
+    return await this.sketch<TopEntry[]>("cms", { key, op: "top" });
+  }
+
+  private async stream<T>(body: Record<string, unknown>): Promise<T> {
+    const res = await this.client.request<{ result: T }>(
+      "POST",
+      "/kv/stream",
+      body as unknown as JsonValue
+    );
+    return res.result;
+  }
+
+  /**
+   * streamAdd дописывает запись в стрим и возвращает ее ID.
+   * maxLen ограничивает длину стрима (старые записи обрезаются), значение запоминается.
+   */
+  async streamAdd(
+    key: string,
+    value: JsonValue,
+    options?: { maxLen?: number }
+  ): Promise<string> {
+    return await this.stream<string>({
+      key,
+      op: "add",
+      value,
+      maxlen: options?.maxLen,
+    });
+  }
+
+  /**
+   * streamRange читает записи с ID в [start, end] ("-" и "+" — границы стрима)
+   */
+  async streamRange<T extends JsonValue>(
+    key: string,
+    start = "-",
+    end = "+",
+    count?: number
+  ): Promise<StreamEntry<T>[]> {
+    return await this.stream({ key, op: "range", start, end, count });
+  }
+
+  /**
+   * streamRead читает записи после ID ("$" — только новые). С blockMs ждет их появления.
+   */
+  async streamRead<T extends JsonValue>(
+    key: string,
+    after: string,
+    options?: StreamReadOptions
+  ): Promise<StreamEntry<T>[]> {
+    return await this.stream({
+      key,
+      op: "read",
+      after,
+      count: options?.count,
+      block_ms: options?.blockMs,
+    });
+  }
+
+  /**
+   * createGroup создает группу консьюмеров. start: "$" — только новые записи, "0" — с начала стрима.
+   */
+  async createGroup(key: string, group: string, start = "$"): Promise<void> {
+    await this.stream({ key, op: "group.create", group, id: start });
+  }
+
+  /**
+   * readGroup выдает консьюмеру новые записи группы. Они остаются в pending до ack.
+   */
+  async readGroup<T extends JsonValue>(
+    key: string,
+    group: string,
+    consumer: string,
+    options?: StreamReadOptions
+  ): Promise<StreamEntry<T>[]> {
+    return await this.stream({
+      key,
+      op: "group.read",
+      group,
+      consumer,
+      count: options?.count,
+      block_ms: options?.blockMs,
+    });
+  }
+
+  /**
+   * ack подтверждает обработку записей. Возвращает число снятых с pending.
+   */
+  async ack(key: string, group: string, ids: string[]): Promise<number> {
+    return await this.stream<number>({ key, op: "ack", group, ids });
+  }
+
+  /**
+   * pending возвращает неподтвержденные записи группы (или одного консьюмера)
+   */
+  async pending(
+    key: string,
+    group: string,
+    consumer?: string
+  ): Promise<PendingEntry[]> {
+    return await this.stream({ key, op: "pending", group, consumer });
+  }
+
+  /**
+   * claim забирает записи, зависшие у других консьюмеров дольше minIdleMs.
+   * Без ids — самые старые зависшие (до count).
+   */
+  async claim<T extends JsonValue>(
+    key: string,
+    group: string,
+    consumer: string,
+    minIdleMs: number,
+    options?: { ids?: string[]; count?: number }
+  ): Promise<StreamEntry<T>[]> {
+    return await this.stream({
+      key,
+      op: "claim",
+      group,
+      consumer,
+      min_idle_ms: minIdleMs,
+      ids: options?.ids,
+      count: options?.count,
+    });
+  }
 }
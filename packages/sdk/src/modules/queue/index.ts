@@ -0,0 +1,166 @@
+import { NexusClient } from "../../core/client";
+import type { JsonValue } from "../../types";
+
+export interface EnqueueOptions {
+  /** Больше — раньше (по умолчанию 0) */
+  priority?: number;
+  /** Выполнить не раньше чем через N мс */
+  delayMs?: number;
+  /** Или не раньше момента времени */
+  runAt?: Date;
+  /** Попыток до dead-letter queue (по умолчанию -queue-max-attempts) */
+  maxAttempts?: number;
+  /** База экспоненциальной задержки между попытками */
+  backoffMs?: number;
+}
+
+export interface DequeueOptions {
+  count?: number;
+  /** Аренда: если не подтвердить за это время, задачу выдадут снова */
+  visibilityMs?: number;
+  /** Ждать задач (long-poll), мс */
+  waitMs?: number;
+}
+
+export interface Job<T> {
+  id: string;
+  queue: string;
+  payload: T;
+  priority?: number;
+  state: "ready" | "delayed" | "inflight" | "dead";
+  attempts: number;
+  max_attempts: number;
+  backoff_ms: number;
+  run_at?: number;
+  visible_at?: number;
+  /** Токен аренды: нужен для ack/nack/extend */
+  receipt?: string;
+  last_error?: string;
+  created_at: number;
+  died_at?: number;
+}
+
+export interface QueueStats {
+  ready: number;
+  delayed: number;
+  inflight: number;
+  dead: number;
+}
+
+export class QueueModule {
+  constructor(private readonly client: NexusClient) {}
+
+  async enqueue<T extends JsonValue>(
+    queue: string,
+    payload: T,
+    options: EnqueueOptions = {}
+  ): Promise<Job<T>> {
+    return await this.client.request<Job<T>>("POST", "/queue/enqueue", {
+      queue,
+      payload,
+      priority: options.priority || 0,
+      delay_ms: options.delayMs || 0,
+      run_at: options.runAt ? options.runAt.getTime() : 0,
+      max_attempts: options.maxAttempts || 0,
+      backoff_ms: options.backoffMs || 0,
+    });
+  }
+
+  /**
+   * dequeue берет задачи в работу. Каждую нужно подтвердить ack или вернуть nack.
+   */
+  async dequeue<T extends JsonValue>(
+    queue: string,
+    options: DequeueOptions = {}
+  ): Promise<Job<T>[]> {
+    return await this.client.request<Job<T>[]>("POST", "/queue/dequeue", {
+      queue,
+      count: options.count || 1,
+      visibility_ms: options.visibilityMs || 0,
+      wait_ms: options.waitMs || 0,
+    });
+  }
+
+  async ack(job: Job<unknown>): Promise<void> {
+    await this.client.request("POST", "/queue/ack", {
+      queue: job.queue,
+      id: job.id,
+      receipt: job.receipt || "",
+    });
+  }
+
+  /**
+   * nack возвращает задачу на повтор (с backoff или через delayMs) либо в DLQ, если попытки кончились
+   */
+  async nack(
+    job: Job<unknown>,
+    error?: string,
+    delayMs?: number
+  ): Promise<void> {
+    const body: Record<string, JsonValue> = {
+      queue: job.queue,
+      id: job.id,
+      receipt: job.receipt || "",
+      error: error || "",
+    };
+    if (delayMs !== undefined) {
+      body.delay_ms = delayMs;
+    }
+    await this.client.request("POST", "/queue/nack", body);
+  }
+
+  /**
+   * extend продлевает аренду долгой задачи
+   */
+  async extend<T extends JsonValue>(
+    job: Job<T>,
+    visibilityMs: number
+  ): Promise<Job<T>> {
+    return await this.client.request<Job<T>>("POST", "/queue/extend", {
+      queue: job.queue,
+      id: job.id,
+      receipt: job.receipt || "",
+      visibility_ms: visibilityMs,
+    });
+  }
+
+  async stats(queue?: string): Promise<Record<string, QueueStats>> {
+    const query = queue ? `?queue=${encodeURIComponent(queue)}` : "";
+    return await this.client.request<Record<string, QueueStats>>(
+      "GET",
+      `/queue/stats${query}`
+    );
+  }
+
+  async dead<T extends JsonValue>(
+    queue: string,
+    limit?: number
+  ): Promise<Job<T>[]> {
+    const query = limit ? `&limit=${limit}` : "";
+    return await this.client.request<Job<T>[]>(
+      "GET",
+      `/queue/dead?queue=${encodeURIComponent(queue)}${query}`
+    );
+  }
+
+  /**
+   * requeue возвращает задачи из DLQ в очередь (без ids — все)
+   */
+  async requeue(queue: string, ids?: string[]): Promise<number> {
+    const res = await this.client.request<{ requeued: number }>(
+      "POST",
+      "/queue/dead/requeue",
+      { queue, ids: ids || [] }
+    );
+    return res.requeued;
+  }
+
+  async purge(queue: string, ids?: string[]): Promise<number> {
+    const res = await this.client.request<{ purged: number }>(
+      "POST",
+      "/queue/dead/purge",
+      { queue, ids: ids || [] }
+    );
+    return res.purged;
+  }
+}
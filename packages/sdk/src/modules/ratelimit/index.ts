@@ -0,0 +1,60 @@
+import { NexusClient } from "../../core/client";
+import type { JsonValue } from "../../types";
+
+export interface RateLimitDefinition {
+  name: string;
+  algorithm: "token_bucket" | "sliding_window" | "gcra";
+  /** Запросов за период */
+  limit: number;
+  period_ms: number;
+  /** token_bucket/gcra: сколько можно сразу (по умолчанию = limit) */
+  burst?: number;
+}
+
+export interface RateLimitResult {
+  allowed: boolean;
+  limit: number;
+  remaining: number;
+  /** Через сколько повторить (-1 — cost больше емкости, не пройдет никогда) */
+  retry_after_ms: number;
+  /** Через сколько квота восстановится полностью */
+  reset_after_ms: number;
+}
+
+export class RateLimitModule {
+  constructor(private readonly client: NexusClient) {}
+
+  /**
+   * define создает или заменяет именованный лимит
+   */
+  async define(limit: RateLimitDefinition): Promise<void> {
+    await this.client.request(
+      "POST",
+      "/ratelimit/limits",
+      limit as unknown as JsonValue
+    );
+  }
+
+  async limits(): Promise<RateLimitDefinition[]> {
+    return await this.client.request<RateLimitDefinition[]>(
+      "GET",
+      "/ratelimit/limits"
+    );
+  }
+
+  /**
+   * check атомарно проверяет и списывает cost из квоты ключа.
+   * Отказ не бросает исключение: смотрите allowed и retry_after_ms.
+   */
+  async check(
+    limit: string,
+    key: string,
+    cost = 1
+  ): Promise<RateLimitResult> {
+    return await this.client.request<RateLimitResult>(
+      "POST",
+      "/ratelimit/check",
+      { limit, key, cost }
+    );
+  }
+}
@@ -0,0 +1,109 @@
+import { NexusClient } from "../../core/client";
+import type { JsonValue } from "../../types";
+
+export interface ScheduleOptions {
+  /** Cron выражение ("*\/5 * * * *", "@daily", "@every 30s") */
+  cron?: string;
+  /** Или разовый запуск */
+  at?: Date;
+  /** IANA таймзона для cron (по умолчанию UTC) */
+  timezone?: string;
+  /** Webhook, который вызовет Engine */
+  url?: string;
+  method?: string;
+  headers?: Record<string, string>;
+  /** Или канал PubSub */
+  channel?: string;
+  payload?: JsonValue;
+  /** Что делать с запусками, пропущенными, пока Engine лежал (по умолчанию skip) */
+  missed?: "skip" | "once" | "all";
+  timeoutMs?: number;
+  paused?: boolean;
+}
+
+export interface Schedule {
+  name: string;
+  cron?: string;
+  at?: number;
+  timezone?: string;
+  url?: string;
+  method?: string;
+  headers?: Record<string, string>;
+  channel?: string;
+  payload?: JsonValue;
+  missed: "skip" | "once" | "all";
+  timeout_ms?: number;
+  paused?: boolean;
+  /** 0 — больше не сработает */
+  next_run: number;
+  last_run?: number;
+  created_at: number;
+}
+
+export interface ScheduleRun {
+  id: string;
+  schedule: string;
+  scheduled_at: number;
+  started_at: number;
+  finished_at?: number;
+  status: "running" | "ok" | "failed" | "skipped" | "missed" | "interrupted";
+  http_status?: number;
+  error?: string;
+}
+
+export class SchedulerModule {
+  constructor(private readonly client: NexusClient) {}
+
+  /**
+   * schedule регистрирует расписание. Вызов идемпотентен: все инстансы приложения
+   * могут регистрировать одно и то же на старте, срабатывание будет одно.
+   */
+  async schedule(name: string, options: ScheduleOptions): Promise<Schedule> {
+    return await this.client.request<Schedule>("POST", "/scheduler/schedules", {
+      name,
+      cron: options.cron || "",
+      at: options.at ? options.at.getTime() : 0,
+      timezone: options.timezone || "",
+      url: options.url || "",
+      method: options.method || "",
+      headers: options.headers || {},
+      channel: options.channel || "",
+      payload: options.payload ?? null,
+      missed: options.missed || "",
+      timeout_ms: options.timeoutMs || 0,
+      paused: options.paused || false,
+    });
+  }
+
+  async list(): Promise<Schedule[]> {
+    return await this.client.request<Schedule[]>("GET", "/scheduler/schedules");
+  }
+
+  async get(name: string): Promise<Schedule> {
+    return await this.client.request<Schedule>(
+      "GET",
+      `/scheduler/schedules?name=${encodeURIComponent(name)}`
+    );
+  }
+
+  async delete(name: string): Promise<void> {
+    await this.client.request("POST", "/scheduler/delete", { name });
+  }
+
+  /**
+   * trigger запускает расписание сейчас, не сдвигая следующий запуск
+   */
+  async trigger(name: string): Promise<ScheduleRun> {
+    return await this.client.request<ScheduleRun>("POST", "/scheduler/trigger", {
+      name,
+    });
+  }
+
+  async runs(name: string, limit?: number): Promise<ScheduleRun[]> {
+    const query = limit ? `&limit=${limit}` : "";
+    return await this.client.request<ScheduleRun[]>(
+      "GET",
+      `/scheduler/runs?name=${encodeURIComponent(name)}${query}`
+    );
+  }
+}
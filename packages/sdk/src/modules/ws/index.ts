@@ -3,7 +3,114 @@ import type { JsonValue } from "../../types";
 
 export interface TicketOptions {
   userId: string;
+  /**
+   * Каналы, на которые можно подписаться. Можно шаблоны по сегментам через точку:
+   * "room.*" — один сегмент, "tenant.42.>" — все вложенные каналы.
+   */
   channels: string[];
+  /** Каналы (или шаблоны), в которые клиент может публиковать прямо через сокет */
+  publish?: string[];
+}
+
+/**
+ * Команды, которые браузер шлет в сокет: менять подписки без переподключения
+ * и публиковать. Канал должен быть в тикете соединения (channels или publish),
+ * иначе нужен свежий тикет того же пользователя.
+ */
+export interface WSCommand<T = JsonValue> {
+  /**
+   * presence — свои метаданные в канале (статус, курсор), members — кто в канале,
+   * ack — подтвердить сообщения durable канала (все до offset включительно)
+   */
+  type: "subscribe" | "unsubscribe" | "publish" | "presence" | "members" | "ack";
+  /** Вернется в ответе ack/error */
+  id?: string;
+  channel: string;
+  ticket?: string;
+  /** Для publish и presence */
+  data?: T;
+  /**
+   * Для subscribe: дослать сообщения после этого offset ("" — всю историю).
+   * Работает для каналов с историей (флаг Engine -ws-history). В ack придет ResumeInfo.
+   */
+  since?: string;
+  /** Для ack: offset последнего обработанного сообщения */
+  offset?: string;
+}
+
+export interface ResumeInfo {
+  /** Часть сообщений уже не хранится — перечитайте состояние целиком */
+  gap: boolean;
+  /** Досланы не все — подпишитесь снова с since = offset последнего полученного */
+  more?: boolean;
+  /** Offset последнего сообщения канала */
+  last?: string;
+}
+
+export interface HistoryReplay<T = JsonValue> extends ResumeInfo {
+  messages: WSMessage<T>[];
+}
+
+/**
+ * Запрос к хуку проверки публикаций (флаг Engine -ws-publish-hook).
+ * Ответ 2xx пропускает сообщение ({ data } в ответе заменяет данные), иначе — отказ.
+ */
+export interface PublishHookRequest<T = JsonValue> {
+  user_id: string;
+  channel: string;
+  data: T;
+}
+
+/**
+ * Сообщения из сокета: данные канала (без type) или ответ на команду
+ */
+export interface WSMessage<T = JsonValue> {
+  /**
+   * presence — data это PresenceEvent; gap — часть сообщений durable канала
+   * выкинута по лимитам хранения, доставка продолжается после offset
+   */
+  type?: "ack" | "error" | "presence" | "gap";
+  id?: string;
+  channel: string;
+  data?: T;
+  /**
+   * Позиция сообщения в канале с историей: сохраните, чтобы продолжить после переподключения.
+   * В durable каналах (флаг Engine -ws-durable) подтвердите ее командой ack, иначе
+   * сообщение придет снова; без ack в полете не больше 64 сообщений на соединение.
+   */
+  offset?: string;
+  error?: string;
+}
+
+/**
+ * Ответ long-polling (GET /poll?ticket=... или /poll?session=...&seq=...) — для клиентов,
+ * у которых не открывается WebSocket. Есть и поток SSE: GET /sse?ticket=..., первое
+ * событие "session", дальше WSMessage в data. Команды (WSCommand) для обоих — POST /command?session=...
+ */
+export interface PollResponse<T = JsonValue> {
+  session: string;
+  /** Номер пачки: передайте в следующем запросе, иначе придет та же пачка */
+  seq: number;
+  messages: WSMessage<T>[];
+  /**
+   * Offset всех каналов (как id событий SSE): при новом подключении со свежим тикетом
+   * передайте в last_event_id, и каналы продолжатся с места разрыва
+   */
+  last_event_id?: string;
+}
+
+export interface PresenceMember {
+  user_id: string;
+  meta?: JsonValue;
+  joined_at: number;
+  /** Вкладки/устройства пользователя в канале */
+  connections: number;
+}
+
+export interface PresenceEvent {
+  event: "join" | "leave" | "update";
+  user_id: string;
+  meta?: JsonValue;
 }
 
 export interface PublishOptions<T> {
@@ -24,6 +131,7 @@ export class WSModule {
       {
         user_id: opts.userId,
         channels: opts.channels,
+        publish: opts.publish || [],
       }
     );
     return res.ticket;
@@ -38,4 +146,31 @@ export class WSModule {
       data,
     });
   }
+
+  /**
+   * Кто сейчас подписан на канал (по user_id из тикетов)
+   */
+  async presence(channel: string): Promise<PresenceMember[]> {
+    return await this.client.request<PresenceMember[]>(
+      "GET",
+      `/pubsub/presence?channel=${encodeURIComponent(channel)}`
+    );
+  }
+
+  /**
+   * Сообщения канала после offset (для каналов с историей)
+   */
+  async history<T extends JsonValue>(
+    channel: string,
+    since?: string,
+    limit?: number
+  ): Promise<HistoryReplay<T>> {
+    const query = new URLSearchParams({ channel });
+    if (since) query.set("since", since);
+    if (limit) query.set("limit", String(limit));
+    return await this.client.request<HistoryReplay<T>>(
+      "GET",
+      `/pubsub/history?${query}`
+    );
+  }
 }